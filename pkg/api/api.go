@@ -92,6 +92,11 @@ func New(cfg config.API, srv *server.Server) (*API, error) {
 	}
 	api.createRoutes()
 
+	// Request explanations are subject to the same access control list.
+	if srv != nil {
+		srv.AllowExplain(filter.Allows)
+	}
+
 	return api, nil
 }
 
@@ -119,6 +124,10 @@ func (a *API) RegisterRoute(method string, p string, handler http.HandlerFunc) {
 // createRoutes registers the core service endpoints.
 func (a *API) createRoutes() {
 	// Purge cache key: curl -v -X PURGE -H 'X-Purge-Key: <cache-key>' kacheserver:PORT
+	// Purge cache keys by glob: curl -v -X PURGE -H 'X-Purge-Glob: <pattern>' kacheserver:PORT
+	// Purge cache keys by regex: curl -v -X PURGE -H 'X-Purge-Regex: <regex>' kacheserver:PORT
+	// Soft purge (mark stale) with any of the above: curl -v -X PURGE -H 'X-Purge-Soft: true' ...
+	// Purge cache tags: curl -v -X PURGE -H 'X-Purge-Tag: <tag> [<tag> ...]' kacheserver:PORT
 	a.router.Methods("PURGE").Path("/").
 		HandlerFunc(a.filter.Wrap(a.server.CacheKeyPurgeHandler))
 
@@ -142,6 +151,31 @@ func (a *API) createRoutes() {
 		Path(path.Join(a.prefix, "/cache/invalidate")).
 		HandlerFunc(a.server.CacheInvalidateHandler)
 
+	// Invalidates the exact keys listed in the JSON body.
+	a.router.Methods(http.MethodDelete).
+		Path(path.Join(a.prefix, "/cache/invalidate/keys")).
+		HandlerFunc(a.server.CacheInvalidateKeysHandler)
+
+	// Invalidates all keys tagged with the tags in the 'X-Purge-Tag' header.
+	a.router.Methods(http.MethodDelete).
+		Path(path.Join(a.prefix, "/cache/invalidate/tags")).
+		HandlerFunc(a.server.CacheInvalidateTagsHandler)
+
+	// List and add bans, e.g. curl -X POST -d '{"expr": "req.url ~ ^/news"}' kacheserver:PORT/api/cache/bans
+	a.router.Methods(http.MethodGet, http.MethodPost).
+		Path(path.Join(a.prefix, "/cache/bans")).
+		HandlerFunc(a.server.CacheBansHandler)
+
+	// Render and toggle the offline mode, e.g. curl -X PUT -d '{"offline": true}' kacheserver:PORT/api/cache/offline
+	a.router.Methods(http.MethodGet, http.MethodPut).
+		Path(path.Join(a.prefix, "/cache/offline")).
+		HandlerFunc(a.server.CacheOfflineHandler)
+
+	// Explain how a request would be handled, e.g. curl -X POST -d '{"url": "http://example.com/"}' kacheserver:PORT/api/cache/explain
+	a.router.Methods(http.MethodPost).
+		Path(path.Join(a.prefix, "/cache/explain")).
+		HandlerFunc(a.server.CacheExplainHandler)
+
 	// Flush all keys from the cache.
 	a.router.Methods(http.MethodDelete).
 		Path(path.Join(a.prefix, "/cache/flush")).
@@ -86,28 +86,31 @@ func NewIPFilter(whitelist string) (*IPFilter, error) {
 // request bypasses the filter.
 func (f *IPFilter) Wrap(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if len(f.allowedIPs) == 0 {
-			next(w, r)
-			return
-		}
-
-		// Get the original client IP.
-		ip, err := originalIP(r)
-		if err != nil {
-			defaultBlockedHandler.ServeHTTP(w, r)
-			return
-		}
-
-		// Check if the IP is allowed or blocked.
-		if !f.IsAllowed(ip) {
+		if !f.Allows(r) {
 			defaultBlockedHandler.ServeHTTP(w, r)
 			return
 		}
-
 		next(w, r)
 	})
 }
 
+// Allows checks if the request is allowed according to its original IP.
+// If the list of allowed IPs is empty, any request is allowed.
+func (f *IPFilter) Allows(r *http.Request) bool {
+	if len(f.allowedIPs) == 0 {
+		return true
+	}
+
+	// Get the original client IP.
+	ip, err := originalIP(r)
+	if err != nil {
+		return false
+	}
+
+	// Check if the IP is allowed or blocked.
+	return f.IsAllowed(ip)
+}
+
 // IsAllowed checks if the given IP is allowed.
 func (f *IPFilter) IsAllowed(ip netip.Addr) bool {
 	if !ip.IsValid() {
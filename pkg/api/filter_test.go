@@ -78,6 +78,23 @@ func TestIsAllowed(t *testing.T) {
 	}
 }
 
+func TestAllows(t *testing.T) {
+	req, _ := http.NewRequest("GET", "", nil)
+	req.RemoteAddr = "192.0.2.1:6087"
+
+	f, err := NewIPFilter("192.0.2.1")
+	require.NoError(t, err)
+	assert.True(t, f.Allows(req))
+
+	req.RemoteAddr = "192.0.2.2:6087"
+	assert.False(t, f.Allows(req))
+
+	// Any request is allowed by an inactive filter.
+	f, err = NewIPFilter("")
+	require.NoError(t, err)
+	assert.True(t, f.Allows(req))
+}
+
 func TestOriginalIP(t *testing.T) {
 	req, _ := http.NewRequest("GET", "", nil)
 	req.RemoteAddr = "192.0.2.1:6087"
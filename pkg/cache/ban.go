@@ -0,0 +1,353 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// banKey is the metadata key the ban list is stored under in the provider. Metadata is
+// not affected by eviction, purges or flushes, which would otherwise resurrect banned
+// entries.
+const banKey = "bans"
+
+// banRefreshInterval is the interval in which the ban list is reloaded from the provider,
+// to pick up bans added by other instances sharing the same (remote) cache.
+const banRefreshInterval = time.Second
+
+// ErrInvalidBan is returned if a ban expression cannot be parsed.
+var ErrInvalidBan = errors.New("invalid ban expression")
+
+// Ban invalidates all cache entries stored before the time of the ban and matching
+// the ban expression. Bans are evaluated lazily when an entry is looked up; banned
+// entries are deleted and treated as cache misses.
+//
+// A ban expression consists of one or more conditions joined by '&&', e.g.
+//
+//	req.url ~ ^/news && obj.http.content-type ~ image
+//
+// A condition compares a field with an argument using one of the operators '==', '!=',
+// '~' (matches regular expression) or '!~'. Arguments may be double quoted. Fields are
+// 'req.url' (path and query of the request), 'req.http.<name>' (request header),
+// 'obj.status' (status code of the stored response) and 'obj.http.<name>' (header
+// of the stored response).
+type Ban struct {
+	// Expr is the ban expression.
+	Expr string `json:"expr"`
+
+	// Time is the time the ban has been added.
+	Time time.Time `json:"time"`
+
+	// Expires is the time after which no entry stored before the ban can remain.
+	Expires time.Time `json:"expires"`
+
+	// conditions holds the parsed ban expression.
+	conditions []banCondition
+}
+
+// banCondition is a single condition of a ban expression.
+type banCondition struct {
+	field  string
+	header string
+	op     string
+	arg    string
+	re     *regexp.Regexp
+}
+
+// ParseBan parses the ban expression.
+func ParseBan(expr string) (*Ban, error) {
+	ban := &Ban{Expr: strings.TrimSpace(expr)}
+	for _, s := range strings.Split(ban.Expr, "&&") {
+		cond, err := parseBanCondition(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidBan, s, err)
+		}
+		ban.conditions = append(ban.conditions, cond)
+	}
+	return ban, nil
+}
+
+// parseBanCondition parses a condition of the form '<field> <operator> <argument>'.
+func parseBanCondition(s string) (banCondition, error) {
+	var cond banCondition
+	field, rest, _ := strings.Cut(s, " ")
+	op, arg, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	if arg = strings.TrimSpace(arg); arg == "" {
+		return cond, errors.New("expected '<field> <operator> <argument>'")
+	}
+	if strings.HasPrefix(arg, `"`) {
+		unquoted, err := strconv.Unquote(arg)
+		if err != nil {
+			return cond, err
+		}
+		arg = unquoted
+	}
+
+	switch {
+	case field == "req.url" || field == "obj.status":
+		cond.field = field
+	case strings.HasPrefix(field, "req.http.") && len(field) > len("req.http."):
+		cond.field, cond.header = "req.http", field[len("req.http."):]
+	case strings.HasPrefix(field, "obj.http.") && len(field) > len("obj.http."):
+		cond.field, cond.header = "obj.http", field[len("obj.http."):]
+	default:
+		return cond, fmt.Errorf("unknown field %q", field)
+	}
+
+	switch op {
+	case "==", "!=":
+	case "~", "!~":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return cond, err
+		}
+		cond.re = re
+	default:
+		return cond, fmt.Errorf("unknown operator %q", op)
+	}
+	cond.op, cond.arg = op, arg
+	return cond, nil
+}
+
+// Matches returns true if the request and the stored response entry match all
+// conditions of the ban, regardless of the time of the ban.
+func (b *Ban) Matches(req *http.Request, entry *Entry) bool {
+	for _, cond := range b.conditions {
+		if !cond.matches(req, entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches evaluates the condition against the request and the stored response entry.
+func (c banCondition) matches(req *http.Request, entry *Entry) bool {
+	var value string
+	switch c.field {
+	case "req.url":
+		value = req.URL.RequestURI()
+	case "req.http":
+		if strings.EqualFold(c.header, "Host") {
+			value = req.Host
+		} else {
+			value = req.Header.Get(c.header)
+		}
+	case "obj.status":
+		value = strconv.Itoa(entry.StatusCode)
+	case "obj.http":
+		value = entry.Header.Get(c.header)
+	}
+
+	switch c.op {
+	case "==":
+		return value == c.arg
+	case "!=":
+		return value != c.arg
+	case "~":
+		return c.re.MatchString(value)
+	default: // "!~"
+		return !c.re.MatchString(value)
+	}
+}
+
+// banList holds the active bans sorted by time.
+type banList struct {
+	bans []*Ban
+
+	// loaded is the time the list has been loaded from the provider.
+	loaded time.Time
+}
+
+// Ban adds a ban for the expression at the given time. The ban is recorded in the provider
+// and expires once all entries stored before the ban have expired, i.e. after the longest
+// configured TTL. Adding a ban with the same expression and time as an existing ban (e.g.
+// broadcasted by another instance) returns the existing ban.
+func (c *HttpCache) Ban(ctx context.Context, expr string, now time.Time) (*Ban, error) {
+	ban, err := ParseBan(expr)
+	if err != nil {
+		return nil, err
+	}
+	ban.Time = now
+	ban.Expires = now.Add(c.maxTTL())
+
+	c.banMu.Lock()
+	defer c.banMu.Unlock()
+
+	bans := mergeBans(c.loadedBans(), c.fetchBans(ctx))
+	for _, b := range bans {
+		if b.Expr == ban.Expr && b.Time.Equal(ban.Time) {
+			return b, nil
+		}
+	}
+	bans = c.storeBans(mergeBans(bans, []*Ban{ban}), now)
+	c.bans.Store(&banList{bans: bans, loaded: time.Now()})
+	return ban, nil
+}
+
+// Bans returns the active bans sorted by time.
+func (c *HttpCache) Bans(ctx context.Context) []*Ban {
+	return c.currentBans(ctx)
+}
+
+// banned checks whether the entry stored under the key has been stored before any
+// matching ban. Banned entries are deleted from the cache.
+func (c *HttpCache) banned(ctx context.Context, lookup *LookupRequest, key string, entry *Entry) bool {
+	for _, ban := range c.currentBans(ctx) {
+		if !entry.ResponseTime.Before(ban.Time) || ban.Expires.Before(lookup.Timestamp) {
+			continue
+		}
+		if ban.Matches(lookup.Request, entry) {
+			log.Debug().Str("cache-key", key).Str("ban", ban.Expr).Msg("Banned cache entry")
+			c.cache.Delete(ctx, key)
+			return true
+		}
+	}
+	return false
+}
+
+// currentBans returns the active bans. The bans are reloaded from the provider if the
+// refresh interval has passed. Expired bans are dropped.
+func (c *HttpCache) currentBans(ctx context.Context) []*Ban {
+	list := c.bans.Load()
+	if list != nil && time.Since(list.loaded) < banRefreshInterval {
+		return list.bans
+	}
+	if !c.banMu.TryLock() {
+		// Bans are being reloaded or added concurrently.
+		return c.loadedBans()
+	}
+	defer c.banMu.Unlock()
+
+	now := time.Now()
+	var bans []*Ban
+	for _, ban := range mergeBans(c.loadedBans(), c.fetchBans(ctx)) {
+		if ban.Expires.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	c.bans.Store(&banList{bans: bans, loaded: now})
+	return bans
+}
+
+// loadedBans returns the bans loaded from the provider.
+func (c *HttpCache) loadedBans() []*Ban {
+	if list := c.bans.Load(); list != nil {
+		return list.bans
+	}
+	return nil
+}
+
+// fetchBans fetches the ban list from the provider.
+func (c *HttpCache) fetchBans(ctx context.Context) []*Ban {
+	data := c.cache.GetMeta(ctx, banKey)
+	if data == nil {
+		return nil
+	}
+	var stored []*Ban
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Error().Err(err).Msg("Error decoding ban list")
+		return nil
+	}
+	bans := make([]*Ban, 0, len(stored))
+	for _, s := range stored {
+		ban, err := ParseBan(s.Expr)
+		if err != nil {
+			log.Error().Err(err).Msg("Error parsing stored ban")
+			continue
+		}
+		ban.Time, ban.Expires = s.Time, s.Expires
+		bans = append(bans, ban)
+	}
+	return bans
+}
+
+// storeBans stores the ban list in the provider. Bans expired at the
+// given time are dropped. It returns the stored bans.
+func (c *HttpCache) storeBans(bans []*Ban, now time.Time) []*Ban {
+	var active []*Ban
+	for _, ban := range bans {
+		if ban.Expires.After(now) {
+			active = append(active, ban)
+		}
+	}
+	data, err := json.Marshal(active)
+	if err != nil {
+		log.Error().Err(err).Msg("Error encoding ban list")
+		return active
+	}
+	c.cache.SetMeta(banKey, data)
+	return active
+}
+
+// mergeBans merges the ban lists into a list sorted by time, without duplicates.
+func mergeBans(a, b []*Ban) []*Ban {
+	merged := make([]*Ban, 0, len(a)+len(b))
+	merged = append(merged, a...)
+	for _, ban := range b {
+		duplicate := false
+		for _, m := range a {
+			if m.Expr == ban.Expr && m.Time.Equal(ban.Time) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, ban)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Time.Before(merged[j].Time)
+	})
+	return merged
+}
+
+// maxTTL returns the longest time-to-live of a cache entry with the current configuration,
+// including negatively cached responses and TTLs set by rules.
+func (c *HttpCache) maxTTL() time.Duration {
+	config := c.loadConfig()
+	ttl := c.DefaultTTL()
+	for _, t := range config.Timeouts {
+		ttl = max(ttl, t.TTL)
+	}
+	for _, n := range config.NegativeTTLs {
+		ttl = max(ttl, n.TTL)
+	}
+	for _, r := range config.Rules {
+		ttl = max(ttl, r.TTL)
+	}
+	if c.Strict() {
+		ttl += c.StaleIfError()
+	}
+	return ttl
+}
@@ -0,0 +1,226 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBan(t *testing.T) {
+	tests := []struct {
+		expr  string
+		valid bool
+	}{
+		{"req.url ~ ^/news", true},
+		{"req.url == /news", true},
+		{"obj.http.content-type ~ image && obj.status != 200", true},
+		{`req.http.host == "example.com"`, true},
+		{`obj.http.x-tags !~ "product 1"`, true},
+		{"", false},
+		{"req.url", false},
+		{"req.url ~", false},
+		{"req.path ~ ^/news", false},
+		{"req.http. == x", false},
+		{"req.url = /news", false},
+		{"req.url ~ ^/news(", false},
+		{`req.url == "/news`, false},
+		{"req.url ~ ^/news &&", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseBan(tt.expr)
+		if tt.valid {
+			assert.NoError(t, err, tt.expr)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidBan, tt.expr)
+		}
+	}
+}
+
+func TestBanMatches(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/news/today?page=2", nil)
+	req.Header.Set("Accept-Language", "en")
+	entry := &Entry{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"image/png"}},
+	}
+
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{"req.url ~ ^/news", true},
+		{"req.url ~ ^/archive", false},
+		{"req.url == /news/today?page=2", true},
+		{"req.url != /news/today?page=2", false},
+		{"req.http.host == example.com", true},
+		{"req.http.accept-language == en", true},
+		{"obj.http.content-type ~ image", true},
+		{"obj.http.content-type !~ image", false},
+		{`obj.http.content-type == "image/png"`, true},
+		{"obj.status == 200", true},
+		{"req.url ~ ^/news && obj.status == 404", false},
+		{"req.url ~ ^/news && obj.http.content-type ~ ^image/", true},
+	}
+	for _, tt := range tests {
+		ban, err := ParseBan(tt.expr)
+		require.NoError(t, err)
+		assert.Equal(t, tt.match, ban.Matches(req, entry), tt.expr)
+	}
+}
+
+func TestBanLookup(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(nil, p)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now()
+	store := func(url, contentType string, responseTime time.Time) *LookupRequest {
+		req, _ := http.NewRequest("GET", url, nil)
+		lookup := NewLookupRequest(req, now, true)
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Cache-Control": []string{"max-age=3600"},
+				"Content-Type":  []string{contentType},
+				"Date":          []string{formatTime(responseTime)},
+			},
+			Body: io.NopCloser(strings.NewReader("body")),
+		}
+		c.StoreResponse(ctx, lookup, res, responseTime)
+		return lookup
+	}
+
+	news := store("http://example.com/news", "text/html", now.Add(-time.Minute))
+	image := store("http://example.com/news/image.png", "image/png", now.Add(-time.Minute))
+	archive := store("http://example.com/archive", "text/html", now.Add(-time.Minute))
+
+	_, err = c.Ban(ctx, "req.url ~ ^/news && obj.http.content-type ~ ^text/", now.Add(-time.Second))
+	require.NoError(t, err)
+
+	// Matching entries stored before the ban are misses and deleted.
+	assert.Equal(t, EntryInvalid, c.FetchResponse(ctx, *news).Status)
+	assert.Nil(t, p.Get(ctx, news.Key.String()))
+	assert.Equal(t, EntryOk, c.FetchResponse(ctx, *image).Status)
+	assert.Equal(t, EntryOk, c.FetchResponse(ctx, *archive).Status)
+
+	// Entries stored after the ban are not banned.
+	news = store("http://example.com/news", "text/html", now)
+	assert.Equal(t, EntryOk, c.FetchResponse(ctx, *news).Status)
+
+	// The ban is shared with other instances using the same provider.
+	other, err := NewHttpCache(nil, p)
+	require.NoError(t, err)
+	bans := other.Bans(ctx)
+	require.Len(t, bans, 1)
+	assert.Equal(t, "req.url ~ ^/news && obj.http.content-type ~ ^text/", bans[0].Expr)
+	assert.Equal(t, now.Add(-time.Second).Add(DefaultTTL).Unix(), bans[0].Expires.Unix())
+
+	// Adding the same ban again, e.g. broadcasted by another instance, returns the existing ban.
+	ban, err := other.Ban(ctx, bans[0].Expr, bans[0].Time)
+	require.NoError(t, err)
+	assert.Same(t, bans[0], ban)
+
+	// Bans expire once no older entries can remain.
+	_, err = c.Ban(ctx, "obj.status == 200", now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, c.Bans(ctx), 2)
+	assert.Equal(t, EntryOk, c.FetchResponse(ctx, *archive).Status)
+	_, err = c.Ban(ctx, "obj.status == 404", now)
+	require.NoError(t, err)
+	bans = c.Bans(ctx)
+	require.Len(t, bans, 2)
+	assert.Equal(t, "obj.status == 404", bans[1].Expr)
+
+	_, err = c.Ban(ctx, "req.url ~ (", now)
+	assert.ErrorIs(t, err, ErrInvalidBan)
+}
+
+func TestBansSurvivePurgeAndFlush(t *testing.T) {
+	p, err := provider.NewInMemoryCache(provider.DefaultInMemoryCacheConfig)
+	require.NoError(t, err)
+	c, err := NewHttpCache(nil, p)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = c.Ban(ctx, "req.url ~ ^/news", time.Now())
+	require.NoError(t, err)
+
+	// The ban list is not a cache key.
+	assert.Empty(t, p.Keys(ctx, ""))
+
+	m, err := provider.NewGlobMatcher("*")
+	require.NoError(t, err)
+	_, err = p.Purge(ctx, m)
+	require.NoError(t, err)
+	require.NoError(t, p.Flush(ctx))
+
+	// Bans are reloaded from the provider by other instances.
+	other, err := NewHttpCache(nil, p)
+	require.NoError(t, err)
+	bans := other.Bans(ctx)
+	require.Len(t, bans, 1)
+	assert.Equal(t, "req.url ~ ^/news", bans[0].Expr)
+}
+
+func TestBanExpires(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		DefaultTTL: "1m",
+		Timeouts:   []Timeout{{Path: "^/news", TTL: time.Hour}},
+	}, p)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// Bans outlive the longest TTL of a path.
+	ban, err := c.Ban(ctx, "req.url ~ ^/news", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), ban.Expires)
+
+	// Bans outlive negatively cached responses.
+	cfg := *c.Config()
+	cfg.NegativeTTLs = []NegativeTTL{{Status: "404", TTL: 2 * time.Hour}}
+	c.UpdateConfig(&cfg)
+	ban, err = c.Ban(ctx, "obj.status == 404", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Hour), ban.Expires)
+
+	// Bans outlive responses stored with the TTL of a rule.
+	cfg = *c.Config()
+	cfg.Rules = []Rule{{Name: "assets", TTL: 24 * time.Hour}}
+	c.UpdateConfig(&cfg)
+	ban, err = c.Ban(ctx, "req.url ~ ^/assets", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(24*time.Hour), ban.Expires)
+}
@@ -25,6 +25,7 @@ package cache
 import (
 	"math"
 	"net/http"
+	"net/textproto"
 	"strings"
 	"time"
 )
@@ -64,6 +65,12 @@ type RequestCacheControl struct {
 	//   now - expiration_time < max-stale
 	// If max-stale is assigned no value, the client is willing to accept any stale response.
 	MaxStale time.Duration
+
+	// StaleIfError indicates that the client is willing to accept a stale response, if the
+	// origin fails to answer with a fresh one, as long as the response is not stale for longer
+	// than the specified duration.
+	// https://httpwg.org/specs/rfc5861.html#stale-if-error
+	StaleIfError time.Duration
 }
 
 // SetDefaults sets default values.
@@ -71,6 +78,7 @@ func (cc *RequestCacheControl) SetDefaults() {
 	cc.MaxAge = time.Duration(-1)
 	cc.MinFresh = time.Duration(-1)
 	cc.MaxStale = time.Duration(-1)
+	cc.StaleIfError = time.Duration(-1)
 }
 
 // ParseRequestCacheControl parses the cache-control header into a RequestCacheControl.
@@ -78,8 +86,7 @@ func ParseRequestCacheControl(header string) RequestCacheControl {
 	var cc RequestCacheControl
 	cc.SetDefaults()
 
-	directives := strings.Split(header, ",")
-	for _, directive := range directives {
+	for _, directive := range splitDirectives(header) {
 		dir, arg := splitDirective(directive)
 		switch dir {
 		case "no-cache":
@@ -100,22 +107,23 @@ func ParseRequestCacheControl(header string) RequestCacheControl {
 			} else {
 				cc.MaxStale = math.MaxInt64
 			}
+		case "stale-if-error":
+			cc.StaleIfError = parseDuration(arg)
 		}
 	}
 	return cc
 }
 
 // ResponseCacheControl holds the parsed response cache-control header.
-// https://httpwg.org/specs/rfc7234.html#cache-response-directive
+// https://httpwg.org/specs/rfc9111.html#cache-response-directive
 type ResponseCacheControl struct {
-	// MustValidate is true if 'no-cache' directive is present; arguments are ignored for now.
+	// MustValidate is true if the unqualified 'no-cache' directive is present.
 	// This response must not be used to satisfy subsequent requests without successful validation
 	// on the origin.
 	MustValidate bool
 
-	// NoStore is true if any of 'no-store' or 'private' directives is present.
-	// 'private' arguments are ignored for now so it is equivalent to 'no-store'.
-	// Any part of either the immediate request or response must not be cached (stored).
+	// NoStore is true if any of 'no-store' or unqualified 'private' directives is present.
+	// Any part of either the immediate request or response must not be stored by a shared cache.
 	NoStore bool
 
 	// NoTransform is true if the 'no-transform' directive is present.
@@ -133,14 +141,51 @@ type ResponseCacheControl struct {
 	// https://httpwg.org/specs/rfc7234.html#cache-response-directive.public
 	IsPublic bool
 
+	// Private is true if the unqualified 'private' directive is present.
+	// This response is intended for a single user and must not be stored by a shared cache.
+	// https://httpwg.org/specs/rfc9111.html#cache-response-directive.private
+	Private bool
+
+	// PrivateFields holds the header field names listed by the qualified 'private' directive.
+	// These header fields are intended for a single user and must not be stored.
+	PrivateFields []string
+
+	// NoCacheFields holds the header field names listed by the qualified 'no-cache' directive.
+	// These header fields must not be sent in a response to a subsequent request without
+	// successful validation, the remainder of the response may be reused.
+	// https://httpwg.org/specs/rfc9111.html#cache-response-directive.no-cache
+	NoCacheFields []string
+
+	// Immutable is true if the 'immutable' directive is present.
+	// This response will not be updated while it is fresh, hence it is not validated on reloads.
+	// https://httpwg.org/specs/rfc8246.html
+	Immutable bool
+
+	// MustUnderstand is true if the 'must-understand' directive is present.
+	// This response may only be stored, if its status code is understood by the cache.
+	// https://httpwg.org/specs/rfc9111.html#cache-response-directive.must-understand
+	MustUnderstand bool
+
 	// MaxAge is set if to 's-maxage' if present, otherwise is set to 'max-age' if present.
 	// Indicates the maximum time after which this response will be considered stale.
 	MaxAge time.Duration
+
+	// StaleWhileRevalidate indicates the duration after the response became stale, during
+	// which the response may be served stale while it is revalidated in the background.
+	// https://httpwg.org/specs/rfc5861.html#stale-while-revalidate
+	StaleWhileRevalidate time.Duration
+
+	// StaleIfError indicates the duration after the response became stale, during which
+	// the response may be served stale if the origin fails to answer with a fresh one.
+	// https://httpwg.org/specs/rfc5861.html#stale-if-error
+	StaleIfError time.Duration
 }
 
 // SetDefaults sets default values.
 func (cc *ResponseCacheControl) SetDefaults() {
 	cc.MaxAge = time.Duration(-1)
+	cc.StaleWhileRevalidate = time.Duration(-1)
+	cc.StaleIfError = time.Duration(-1)
 }
 
 // ParseResponseCacheControl parses the Cache Control header into a ResponseCacheControl.
@@ -148,14 +193,28 @@ func ParseResponseCacheControl(header string) ResponseCacheControl {
 	var cc ResponseCacheControl
 	cc.SetDefaults()
 
-	directives := strings.Split(header, ",")
-	for _, directive := range directives {
+	for _, directive := range splitDirectives(header) {
 		dir, arg := splitDirective(directive)
 		switch dir {
 		case "no-cache":
-			cc.MustValidate = true
-		case "no-store", "private":
+			if fields := parseFieldNames(arg); len(fields) > 0 {
+				cc.NoCacheFields = append(cc.NoCacheFields, fields...)
+			} else {
+				cc.MustValidate = true
+			}
+		case "no-store":
 			cc.NoStore = true
+		case "private":
+			if fields := parseFieldNames(arg); len(fields) > 0 {
+				cc.PrivateFields = append(cc.PrivateFields, fields...)
+			} else {
+				cc.Private = true
+				cc.NoStore = true
+			}
+		case "immutable":
+			cc.Immutable = true
+		case "must-understand":
+			cc.MustUnderstand = true
 		case "no-transform":
 			cc.NoTransform = true
 		case "must-revalidate", "proxy-revalidate":
@@ -168,11 +227,45 @@ func ParseResponseCacheControl(header string) ResponseCacheControl {
 			if cc.MaxAge < 0 {
 				cc.MaxAge = parseDuration(arg)
 			}
+		case "stale-while-revalidate":
+			cc.StaleWhileRevalidate = parseDuration(arg)
+		case "stale-if-error":
+			cc.StaleIfError = parseDuration(arg)
 		}
 	}
 	return cc
 }
 
+// splitDirectives splits the cache control header into its directives. Commas within
+// quoted arguments, e.g. no-cache="Set-Cookie, Set-Cookie2", do not separate directives.
+func splitDirectives(header string) []string {
+	var directives []string
+	quoted, start := false, 0
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				directives = append(directives, header[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(directives, header[start:])
+}
+
+// parseFieldNames parses the (quoted) list of header field names of a directive argument.
+func parseFieldNames(arg string) []string {
+	var fields []string
+	for _, name := range strings.Split(strings.Trim(arg, "\""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			fields = append(fields, textproto.CanonicalMIMEHeaderKey(name))
+		}
+	}
+	return fields
+}
+
 // splitDirective splits the cache control directive into its token and optional argument.
 // Grammar (https://httpwg.org/specs/rfc7234.html#header.cache-control):
 //
@@ -47,72 +47,77 @@ func TestParseRequestCacheControl(t *testing.T) {
 			"Empty header",
 			"",
 			RequestCacheControl{MustValidate: false, NoStore: false, NoTransform: false,
-				OnlyIfCached: false, MaxAge: -1, MinFresh: -1, MaxStale: -1},
+				OnlyIfCached: false, MaxAge: -1, MinFresh: -1, MaxStale: -1, StaleIfError: -1},
 		},
 		{
 			"Valid header",
 			"max-age=3600, min-fresh=10, no-transform, only-if-cached, no-store",
-			RequestCacheControl{false, true, true, true, seconds(3600), seconds(10), -1},
+			RequestCacheControl{false, true, true, true, seconds(3600), seconds(10), -1, -1},
 		},
 		{
 			"Valid header",
 			"min-fresh=100, max-stale, no-cache",
-			RequestCacheControl{true, false, false, false, -1, seconds(100), math.MaxInt64},
+			RequestCacheControl{true, false, false, false, -1, seconds(100), math.MaxInt64, -1},
 		},
 		{
 			"Valid header",
 			"max-age=10,  max-stale=40",
-			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40)},
+			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40), -1},
 		},
 		{
 			"Quoted args are valid",
 			"max-age=\"3600\", min-fresh=\"10\", no-transform, only-if-cached, no-store",
-			RequestCacheControl{false, true, true, true, seconds(3600), seconds(10), -1},
+			RequestCacheControl{false, true, true, true, seconds(3600), seconds(10), -1, -1},
 		},
 		{
 			"Unknown directives",
 			"max-age=10, max-stale=40, unknown-directive",
-			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40)},
+			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40), -1},
 		},
 		{
 			"Unknown directives with arguments",
 			"max-age=10, max-stale=40, unknown-directive=50",
-			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40)},
+			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40), -1},
 		},
 		{
 			"Unknown directives and with arguments",
 			"max-age=10, max-stale=40, unknown-directive, unknown-with-argument=50",
-			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40)},
+			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40), -1},
 		},
 		{
 			"Unknown directives and quoted",
 			"max-age=10, max-stale=40, unknown-directive, unknown-with-argument=50, unknown-qoted=\"70\"",
-			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40)},
+			RequestCacheControl{false, false, false, false, seconds(10), -1, seconds(40), -1},
+		},
+		{
+			"Stale if error",
+			"max-stale=40, stale-if-error=300",
+			RequestCacheControl{false, false, false, false, -1, -1, seconds(40), seconds(300)},
 		},
 		{
 			"Invalid durations (NaN)",
 			"max-age=ten, min-fresh=20, max-stale=5",
-			RequestCacheControl{false, false, false, false, -1, seconds(20), seconds(5)},
+			RequestCacheControl{false, false, false, false, -1, seconds(20), seconds(5), -1},
 		},
 		{
 			"Invalid durations (negative)",
 			"max-age=ten, min-fresh=20s, max-stale=-5",
-			RequestCacheControl{false, false, false, false, -1, -1, -1},
+			RequestCacheControl{false, false, false, false, -1, -1, -1, -1},
 		},
 		{
 			"Invalid durations (empty)",
 			"max-age=, min-fresh=\"\"",
-			RequestCacheControl{false, false, false, false, -1, -1, -1},
+			RequestCacheControl{false, false, false, false, -1, -1, -1, -1},
 		},
 		{
 			"Invalid header parts (unknown)",
 			"no-cache,,,asdf1337, max-age=20, min-fresh=30=40",
-			RequestCacheControl{true, false, false, false, seconds(20), seconds(30), -1},
+			RequestCacheControl{true, false, false, false, seconds(20), seconds(30), -1, -1},
 		},
 		{
 			"Invalid header parts (misplaced separator)",
 			"no-cache, max-age=10,5, no-store, min-fresh=30",
-			RequestCacheControl{true, true, false, false, seconds(10), seconds(30), -1},
+			RequestCacheControl{true, true, false, false, seconds(10), seconds(30), -1, -1},
 		},
 	}
 	for _, c := range cases {
@@ -132,102 +137,151 @@ func TestParseResponseCacheControl(t *testing.T) {
 			"Empty header",
 			"",
 			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false,
-				NoStale: false, IsPublic: false, MaxAge: -1},
+				NoStale: false, IsPublic: false, MaxAge: -1, StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Valid header",
 			"s-maxage=100, max-age=200, proxy-revalidate, no-store",
-			ResponseCacheControl{false, true, false, true, false, seconds(100)},
+			ResponseCacheControl{MustValidate: false, NoStore: true, NoTransform: false, NoStale: true, IsPublic: false,
+				MaxAge: seconds(100), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Valid header",
 			"s-maxage=100, private, no-cache",
-			ResponseCacheControl{true, true, false, false, false, seconds(100)},
+			ResponseCacheControl{MustValidate: true, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false, Private: true,
+				MaxAge: seconds(100), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Valid header",
 			"max-age=50, must-revalidate, no-cache, no-transform",
-			ResponseCacheControl{true, false, true, true, false, seconds(50)},
+			ResponseCacheControl{MustValidate: true, NoStore: false, NoTransform: true, NoStale: true, IsPublic: false,
+				MaxAge: seconds(50), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Valid header",
 			"private",
-			ResponseCacheControl{false, true, false, false, false, -1},
+			ResponseCacheControl{MustValidate: false, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false, Private: true,
+				MaxAge: -1, StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Valid header",
 			"public, max-age=0",
-			ResponseCacheControl{false, false, false, false, true, seconds(0)},
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: true,
+				MaxAge: seconds(0), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Quoted arg are valid",
 			"s-maxage=\"100\", max-age=\"200\", public",
-			ResponseCacheControl{false, false, false, false, true, seconds(100)},
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: true,
+				MaxAge: seconds(100), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Unknown directives",
 			"no-cache, private, max-age=20, unknown-directive",
-			ResponseCacheControl{true, true, false, false, false, seconds(20)},
+			ResponseCacheControl{MustValidate: true, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false, Private: true,
+				MaxAge: seconds(20), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Unknown directives with arguments",
 			"no-cache, no-store, max-age=20, unknown-with-argument=arg",
-			ResponseCacheControl{true, true, false, false, false, seconds(20)},
+			ResponseCacheControl{MustValidate: true, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: seconds(20), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Unknown directives and with arguments",
 			"no-cache, private, max-age=20, unknown-directive, unknown-with-argument=50",
-			ResponseCacheControl{true, true, false, false, false, seconds(20)},
+			ResponseCacheControl{MustValidate: true, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false, Private: true,
+				MaxAge: seconds(20), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Unknown directives and quoted",
 			"no-cache, private, max-age=20, unknown-directive, unknown-with-argument=50, unknown-qoted=\"arg\"",
-			ResponseCacheControl{true, true, false, false, false, seconds(20)},
+			ResponseCacheControl{MustValidate: true, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false, Private: true,
+				MaxAge: seconds(20), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Invalid durations (NaN)",
 			"max-age=ten",
-			ResponseCacheControl{false, false, false, false, false, -1},
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: -1, StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Invalid durations (negative)",
 			"max-age=-5",
-			ResponseCacheControl{false, false, false, false, false, -1},
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: -1, StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Invalid durations (s-maxage))",
 			"s-maxage=zero, max-age=10",
-			ResponseCacheControl{false, false, false, false, false, seconds(10)},
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: seconds(10), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Invalid durations (max-age))",
 			"s-maxage=20, max-age=zero",
-			ResponseCacheControl{false, false, false, false, false, seconds(20)},
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: seconds(20), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Invalid durations (missing argument))",
 			"max-age=",
-			ResponseCacheControl{false, false, false, false, false, -1},
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: -1, StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Invalid durations (empty quotes)",
 			"no-store, max-age=\"\"",
-			ResponseCacheControl{false, true, false, false, false, -1},
+			ResponseCacheControl{MustValidate: false, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: -1, StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Invalid durations (empty one quote)",
 			"private, max-age=\"\"",
-			ResponseCacheControl{false, true, false, false, false, -1},
+			ResponseCacheControl{MustValidate: false, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false, Private: true,
+				MaxAge: -1, StaleWhileRevalidate: -1, StaleIfError: -1},
+		},
+		{
+			"Stale while revalidate",
+			"max-age=60, stale-while-revalidate=30",
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: seconds(60), StaleWhileRevalidate: seconds(30), StaleIfError: -1},
+		},
+		{
+			"Stale if error",
+			"max-age=60, stale-if-error=300",
+			ResponseCacheControl{MustValidate: false, NoStore: false, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: seconds(60), StaleWhileRevalidate: -1, StaleIfError: seconds(300)},
 		},
 		{
 			"Invalid header parts (unknown)",
 			"no-cache,,,asdf1337, max-age=20",
-			ResponseCacheControl{true, false, false, false, false, seconds(20)},
+			ResponseCacheControl{MustValidate: true, NoStore: false, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: seconds(20), StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 		{
 			"Invalid header parts (misplaced separator)",
 			"no-cache, max-age=10,5, no-store",
-			ResponseCacheControl{true, true, false, false, false, seconds(10)},
+			ResponseCacheControl{MustValidate: true, NoStore: true, NoTransform: false, NoStale: false, IsPublic: false,
+				MaxAge: seconds(10), StaleWhileRevalidate: -1, StaleIfError: -1},
+		},
+		{
+			"Qualified private and no-cache",
+			`max-age=60, private="set-cookie, X-User", no-cache="Set-Cookie2"`,
+			ResponseCacheControl{MaxAge: seconds(60), StaleWhileRevalidate: -1, StaleIfError: -1,
+				PrivateFields: []string{"Set-Cookie", "X-User"}, NoCacheFields: []string{"Set-Cookie2"}},
+		},
+		{
+			"Immutable",
+			"public, max-age=31536000, immutable",
+			ResponseCacheControl{IsPublic: true, Immutable: true, MaxAge: seconds(31536000),
+				StaleWhileRevalidate: -1, StaleIfError: -1},
+		},
+		{
+			"Must understand",
+			"must-understand, no-store",
+			ResponseCacheControl{NoStore: true, MustUnderstand: true, MaxAge: -1,
+				StaleWhileRevalidate: -1, StaleIfError: -1},
 		},
 	}
 	for _, c := range cases {
@@ -0,0 +1,203 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderCacheStatus is the Cache-Status response header field.
+	// https://www.rfc-editor.org/rfc/rfc9211
+	HeaderCacheStatus = "Cache-Status"
+
+	// DefaultCacheStatusName is the default name identifying the cache in the Cache-Status header.
+	DefaultCacheStatusName = "kache"
+)
+
+// Reasons why a request has been forwarded towards the origin.
+// https://www.rfc-editor.org/rfc/rfc9211#section-2.2
+const (
+	// FwdBypass indicates that the cache was configured to not handle the request.
+	FwdBypass = "bypass"
+
+	// FwdMethod indicates that the request method requires the request to be forwarded.
+	FwdMethod = "method"
+
+	// FwdURIMiss indicates that the cache did not contain any response for the request.
+	FwdURIMiss = "uri-miss"
+
+	// FwdStale indicates that the cached response was stale and had to be validated.
+	FwdStale = "stale"
+
+	// FwdRequest indicates that a fresh cached response was selected, but the request
+	// directives did not allow its use.
+	FwdRequest = "request"
+)
+
+// CacheStatus describes how the cache handled a request.
+type CacheStatus struct {
+	// Hit indicates that the request was satisfied by the cache without forwarding it.
+	Hit bool
+
+	// Fwd holds the reason why the request was forwarded towards the origin.
+	Fwd string
+
+	// FwdStatus holds the status code of the response received from the origin.
+	FwdStatus int
+
+	// TTL holds the remaining freshness lifetime of the served response,
+	// if HasTTL is set. A negative TTL indicates a stale response.
+	TTL    time.Duration
+	HasTTL bool
+
+	// Stored indicates that the forwarded response has been stored in the cache.
+	Stored bool
+
+	// Collapsed indicates that the request was collapsed with another request.
+	Collapsed bool
+
+	// Key holds the cache key of the request.
+	Key string
+
+	// Detail holds additional implementation-specific information.
+	Detail string
+}
+
+// Format formats the status as a member of the Cache-Status header of the cache with
+// the given name, e.g. 'kache; fwd=uri-miss; fwd-status=200; stored; key="..."'.
+func (s CacheStatus) Format(name string) string {
+	var b strings.Builder
+	_, _ = b.WriteString(sfItem(name))
+	if s.Hit {
+		_, _ = b.WriteString("; hit")
+	}
+	if s.Fwd != "" {
+		_, _ = b.WriteString("; fwd=")
+		_, _ = b.WriteString(s.Fwd)
+	}
+	if s.FwdStatus > 0 {
+		_, _ = b.WriteString("; fwd-status=")
+		_, _ = b.WriteString(strconv.Itoa(s.FwdStatus))
+	}
+	if s.HasTTL {
+		_, _ = b.WriteString("; ttl=")
+		_, _ = b.WriteString(strconv.FormatInt(int64(s.TTL/time.Second), 10))
+	}
+	if s.Stored {
+		_, _ = b.WriteString("; stored")
+	}
+	if s.Collapsed {
+		_, _ = b.WriteString("; collapsed")
+	}
+	if s.Key != "" {
+		_, _ = b.WriteString("; key=")
+		_, _ = b.WriteString(sfString(s.Key))
+	}
+	if s.Detail != "" {
+		_, _ = b.WriteString("; detail=")
+		_, _ = b.WriteString(sfItem(s.Detail))
+	}
+	return b.String()
+}
+
+// AddCacheStatus adds the status as a member of the Cache-Status header, if enabled.
+// Members added by caches closer to the origin are preserved.
+func (c *HttpCache) AddCacheStatus(header http.Header, status CacheStatus) {
+	if !c.loadConfig().CacheStatus {
+		return
+	}
+	header.Add(HeaderCacheStatus, status.Format(c.CacheStatusName()))
+}
+
+// MarkCollapsed marks the Cache-Status member of the cache in the header, if it is the
+// last member, as collapsed, i.e. the response is shared with another request.
+func (c *HttpCache) MarkCollapsed(header http.Header) {
+	values := header[HeaderCacheStatus]
+	if !c.loadConfig().CacheStatus || len(values) == 0 {
+		return
+	}
+	name := sfItem(c.CacheStatusName())
+	last := values[len(values)-1]
+	if last == name || strings.HasPrefix(last, name+";") {
+		values[len(values)-1] = last + "; collapsed"
+	}
+}
+
+// CacheStatusName returns the name identifying the cache in the Cache-Status header.
+func (c *HttpCache) CacheStatusName() string {
+	config := c.loadConfig()
+	if config.CacheStatusName == "" {
+		return DefaultCacheStatusName
+	}
+	return config.CacheStatusName
+}
+
+// sfItem formats s as a structured field token, or as a string if s is not a valid token.
+// https://www.rfc-editor.org/rfc/rfc8941#section-3.3.4
+func sfItem(s string) string {
+	if isToken(s) {
+		return s
+	}
+	return sfString(s)
+}
+
+// isToken checks if s is a valid structured field token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '*':
+		case i == 0:
+			return false
+		case c >= '0' && c <= '9', strings.ContainsRune("!#$%&'+-.^_`|~:/", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// sfString formats s as a structured field string. Characters
+// which cannot be represented in a string are omitted.
+// https://www.rfc-editor.org/rfc/rfc8941#section-3.3.3
+func sfString(s string) string {
+	var b strings.Builder
+	_ = b.WriteByte('"')
+	for _, c := range s {
+		if c < 0x20 || c > 0x7e {
+			continue
+		}
+		if c == '"' || c == '\\' {
+			_ = b.WriteByte('\\')
+		}
+		_, _ = b.WriteRune(c)
+	}
+	_ = b.WriteByte('"')
+	return b.String()
+}
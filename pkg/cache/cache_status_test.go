@@ -0,0 +1,95 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheStatusFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   CacheStatus
+		expected string
+	}{
+		{"empty", CacheStatus{}, `kache`},
+		{"hit", CacheStatus{Hit: true, TTL: 376 * time.Second, HasTTL: true},
+			`kache; hit; ttl=376`},
+		{"stale hit", CacheStatus{Hit: true, TTL: -30 * time.Second, HasTTL: true, Detail: "stale"},
+			`kache; hit; ttl=-30; detail=stale`},
+		{"miss", CacheStatus{Fwd: FwdURIMiss, FwdStatus: 200, Stored: true, Key: "kache-http://example.com/"},
+			`kache; fwd=uri-miss; fwd-status=200; stored; key="kache-http://example.com/"`},
+		{"collapsed", CacheStatus{Fwd: FwdStale, FwdStatus: 304, Collapsed: true},
+			`kache; fwd=stale; fwd-status=304; collapsed`},
+		{"escaped key", CacheStatus{Fwd: FwdBypass, Key: "a\"b\\c\n"},
+			`kache; fwd=bypass; key="a\"b\\c"`},
+		{"string detail", CacheStatus{Detail: "not a token"}, `kache; detail="not a token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.status.Format(DefaultCacheStatusName))
+		})
+	}
+
+	assert.Equal(t, `"edge cache"; hit`, CacheStatus{Hit: true}.Format("edge cache"))
+}
+
+func TestAddCacheStatus(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{}, p)
+	require.NoError(t, err)
+
+	// Disabled by default.
+	header := http.Header{}
+	c.AddCacheStatus(header, CacheStatus{Hit: true})
+	assert.Empty(t, header.Values(HeaderCacheStatus))
+
+	cfg := *c.Config()
+	cfg.CacheStatus = true
+	c.UpdateConfig(&cfg)
+
+	// Members of caches closer to the origin are preserved.
+	header.Set(HeaderCacheStatus, "origin-cache; hit")
+	c.AddCacheStatus(header, CacheStatus{Fwd: FwdURIMiss, FwdStatus: 200})
+	c.MarkCollapsed(header)
+	assert.Equal(t, []string{"origin-cache; hit", "kache; fwd=uri-miss; fwd-status=200; collapsed"},
+		header.Values(HeaderCacheStatus))
+
+	// Members of other caches are never marked as collapsed.
+	header = http.Header{HeaderCacheStatus: []string{"kache-edge; hit"}}
+	c.MarkCollapsed(header)
+	assert.Equal(t, []string{"kache-edge; hit"}, header.Values(HeaderCacheStatus))
+
+	cfg.CacheStatusName = "edge"
+	c.UpdateConfig(&cfg)
+	header = http.Header{}
+	c.AddCacheStatus(header, CacheStatus{Hit: true})
+	c.MarkCollapsed(header)
+	assert.Equal(t, "edge; hit; collapsed", header.Get(HeaderCacheStatus))
+}
@@ -41,6 +41,7 @@ const (
 
 	// Request headers
 	HeaderPragma            = "Pragma"
+	HeaderRange             = "Range"
 	HeaderIfRange           = "If-Range"
 	HeaderIfMatch           = "If-Match"
 	HeaderIfNoneMatch       = "If-None-Match"
@@ -48,18 +49,47 @@ const (
 	HeaderIfUnmodifiedSince = "If-Unmodified-Since"
 
 	// Response headers
-	HeaderAge          = "Age"
-	HeaderEtag         = "Etag"
-	HeaderExpires      = "Expires"
-	HeaderLastModified = "Last-Modified"
+	HeaderAge             = "Age"
+	HeaderContentLocation = "Content-Location"
+	HeaderEtag            = "Etag"
+	HeaderExpires         = "Expires"
+	HeaderLastModified    = "Last-Modified"
+	HeaderLocation        = "Location"
+	HeaderVary            = "Vary"
+	HeaderWarning         = "Warning"
+
+	// HeaderSurrogateControl holds the cache directives addressed to surrogates (reverse
+	// proxies), see https://www.w3.org/TR/edge-arch/. It is not forwarded downstream.
+	HeaderSurrogateControl = "Surrogate-Control"
+)
+
+const (
+	// WarningResponseIsStale is the Warning header value attached to stale responses.
+	// https://httpwg.org/specs/rfc7234.html#warn.110
+	WarningResponseIsStale = `110 - "Response is Stale"`
+
+	// WarningRevalidationFailed is the Warning header value attached to stale responses
+	// served because the origin failed to respond.
+	// https://httpwg.org/specs/rfc7234.html#warn.111
+	WarningRevalidationFailed = `111 - "Revalidation Failed"`
+
+	// WarningDisconnectedOperation is the Warning header value attached to stale
+	// responses served while the cache is offline.
+	// https://httpwg.org/specs/rfc7234.html#warn.112
+	WarningDisconnectedOperation = `112 - "Disconnected Operation"`
+
+	// WarningHeuristicExpiration is the Warning header value attached to responses older
+	// than 24 hours served fresh based on a heuristic freshness lifetime.
+	// https://httpwg.org/specs/rfc7234.html#warn.113
+	WarningHeuristicExpiration = `113 - "Heuristic Expiration"`
 )
 
 var (
-	// cacheableStatusCodes holds a set of cacheable status codes.
+	// cacheableStatusCodes holds the default set of cacheable status codes,
+	// see HttpCacheConfig.CacheableStatusCodes.
 	// https://tools.ietf.org/html/rfc7231#section-6.1
 	// https://tools.ietf.org/html/rfc7538#section-3
 	// https://tools.ietf.org/html/rfc7725#section-3
-	// TODO(toashd): this should be configurable.
 	cacheableStatusCodes = map[int]struct{}{
 		200: {},
 		203: {},
@@ -90,46 +120,104 @@ var (
 // IsCacheableRequest checks if a request can be served from cache.
 // This does not depend on cache-control headers as request cache-control
 // headers only decide whether validation is required and whether the
-// response can be cached.
+// response can be cached. Preconditions and ranges of the request are
+// evaluated against the cached response. Whether the response to a request
+// with authorization can be stored is decided by `IsCacheableAuthorized`.
 func IsCacheableRequest(req *http.Request) bool {
-	// Check if the request contains any conditional headers.
-	// For now, requests with conditional headers bypass the cache.
-	for _, h := range conditionalHeaders {
-		if _, ok := req.Header[h]; ok {
-			return false
-		}
-	}
-
-	// Check if the request contains authorization headers.
-	// For now, requests with authorization headers bypass the cache.
-	// https://httpwg.org/specs/rfc7234.html#caching.authenticated.responses
-	if _, ok := req.Header[HeaderAuthorization]; ok {
-		return false
-	}
-
 	return req.URL.Path != "" && req.Host != "" &&
 		(req.Method == http.MethodGet || req.Method == http.MethodHead)
 }
 
+// IsCacheableAuthorized checks if the response to a request with an Authorization header
+// can be stored by a shared cache, i.e. the response explicitly allows it by any of the
+// 'public', 's-maxage' or 'must-revalidate' directives. For a shared cache, the directive
+// 'proxy-revalidate' is equivalent to 'must-revalidate'. Responses to requests without
+// authorization are not restricted.
+// https://httpwg.org/specs/rfc9111.html#caching.authenticated.responses
+func IsCacheableAuthorized(req *http.Request, res *http.Response) bool {
+	if _, ok := req.Header[HeaderAuthorization]; !ok {
+		return true
+	}
+	cacheControl := res.Header.Get(HeaderCacheControl)
+	resCacheControl := ParseResponseCacheControl(cacheControl)
+	if resCacheControl.IsPublic || resCacheControl.NoStale {
+		return true
+	}
+	// The parsed max-age does not tell whether it has been set by 's-maxage'.
+	for _, directive := range splitDirectives(cacheControl) {
+		if dir, _ := splitDirective(directive); dir == "s-maxage" {
+			return true
+		}
+	}
+	return false
+}
+
 // IsCacheableResponse checks if a response can be stored in cache.
 // Note that if a request is not cacheable according to `CanServeRequestFromCache`
 // then its response is also not cacheable. Hence, CanServeRequestFromCache and
 // `IsCacheableResponse` together should cover the cacheability of the response.
+// Only responses with one of the default cacheable status codes are cacheable.
 func IsCacheableResponse(res *http.Response) bool {
-	cacheControl := res.Header.Get(HeaderCacheControl)
-	resCacheControl := ParseResponseCacheControl(cacheControl)
+	return uncacheableReason(res, cacheableStatusCodes, heuristic{}) == ""
+}
+
+// uncacheableReason returns the reason why a response with one of the given status codes
+// cannot be stored, or an empty string, if it can be stored. Responses without explicit
+// expiration time can be stored, if the heuristic applies.
+func uncacheableReason(res *http.Response, statusCodes map[int]struct{}, h heuristic) string {
+	resCacheControl := EdgeCacheControl(res.Header)
+
+	// A Vary header field-value of "*" always fails to match, hence
+	// the response can never be used to satisfy subsequent requests.
+	// https://httpwg.org/specs/rfc9111.html#caching.negotiated.responses
+	if VaryAll(res.Header) {
+		return ReasonVaryAll
+	}
+
+	// A response with the must-understand directive is only stored, if its status code is
+	// understood, in which case the no-store directive is ignored.
+	// https://httpwg.org/specs/rfc9111.html#cache-response-directive.must-understand
+	noStore := resCacheControl.NoStore
+	if resCacheControl.MustUnderstand {
+		if !Contains(cacheableStatusCodes, res.StatusCode) {
+			return ReasonMustUnderstand
+		}
+		noStore = resCacheControl.Private
+	}
 
-	// Only cache responses with enough data to calculate freshness lifetime:
-	// https://httpwg.org/specs/rfc7234.html#calculating.freshness.lifetime
-	// Either:
-	//	'no-cache' cache-control directive (requires revalidation anyway)
-	// 	'max-age' or 's-maxage' cache-control directives
-	// 	Both 'Expires' and 'Date' headers
-	hasValidationData := resCacheControl.MustValidate || resCacheControl.MaxAge >= 0 ||
-		(res.Header.Get(HeaderDate) != "" && res.Header.Get(HeaderExpires) != "")
-
-	return !resCacheControl.NoStore && Contains(cacheableStatusCodes, res.StatusCode) &&
-		hasValidationData
+	switch {
+	case noStore:
+		return ReasonNoStore
+	case !Contains(statusCodes, res.StatusCode):
+		return ReasonStatusCode
+	case !hasValidationData(res.Header, resCacheControl) && !h.applies(res.Header):
+		return ReasonNoFreshness
+	}
+	return ""
+}
+
+// removeUnstorableFields removes the header fields which must not be stored, as listed
+// by the qualified 'private' and 'no-cache' directives of the response.
+func removeUnstorableFields(header http.Header) {
+	resCacheControl := ParseResponseCacheControl(header.Get(HeaderCacheControl))
+	for _, name := range resCacheControl.PrivateFields {
+		header.Del(name)
+	}
+	for _, name := range resCacheControl.NoCacheFields {
+		header.Del(name)
+	}
+}
+
+// hasValidationData checks if the response carries enough data to calculate its freshness
+// lifetime: https://httpwg.org/specs/rfc7234.html#calculating.freshness.lifetime
+// Either:
+//
+//	'no-cache' cache-control directive (requires revalidation anyway)
+//	'max-age' or 's-maxage' cache-control directives
+//	Both 'Expires' and 'Date' headers
+func hasValidationData(header http.Header, resCacheControl ResponseCacheControl) bool {
+	return resCacheControl.MustValidate || resCacheControl.MaxAge >= 0 ||
+		(header.Get(HeaderDate) != "" && header.Get(HeaderExpires) != "")
 }
 
 // Contains checks if the given key is in the map.
@@ -0,0 +1,184 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IsConditionalRequest returns true if the request contains any precondition header fields.
+// https://httpwg.org/specs/rfc9110.html#preconditions
+func IsConditionalRequest(req *http.Request) bool {
+	for _, h := range conditionalHeaders {
+		if _, ok := req.Header[h]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RemovePreconditions removes any precondition header fields from the header.
+func RemovePreconditions(header http.Header) {
+	for _, h := range conditionalHeaders {
+		header.Del(h)
+	}
+}
+
+// EvaluatePreconditions evaluates the request preconditions against the header of the
+// selected (cached) response. It returns http.StatusNotModified or
+// http.StatusPreconditionFailed if a precondition applies, or zero otherwise.
+// The order of evaluation follows https://httpwg.org/specs/rfc9110.html#precedence.
+func EvaluatePreconditions(req *http.Request, header http.Header) int {
+	etag := header.Get(HeaderEtag)
+
+	// If-Match takes precedence over If-Unmodified-Since.
+	if ifMatch := req.Header.Get(HeaderIfMatch); ifMatch != "" {
+		if !matchEtag(ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := parseHttpTime(req.Header.Get(HeaderIfUnmodifiedSince)); !ius.IsZero() {
+		if lm := parseHttpTime(header.Get(HeaderLastModified)); !lm.IsZero() && lm.After(ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since.
+	if ifNoneMatch := req.Header.Get(HeaderIfNoneMatch); ifNoneMatch != "" {
+		if matchEtag(ifNoneMatch, etag, false) {
+			if req.Method == http.MethodGet || req.Method == http.MethodHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		if ims := parseHttpTime(req.Header.Get(HeaderIfModifiedSince)); !ims.IsZero() {
+			if lm := lastModified(header); !lm.IsZero() && !lm.After(ims) {
+				return http.StatusNotModified
+			}
+		}
+	}
+
+	return 0
+}
+
+// ConditionalResponse evaluates the request preconditions against the given successful
+// (2xx) response. If a precondition applies, the response is replaced by a 304 (Not Modified)
+// or 412 (Precondition Failed) response. Otherwise, the response is returned unchanged.
+// The body of a replaced response is read completely, so that it can still be stored.
+func ConditionalResponse(req *http.Request, res *http.Response) *http.Response {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res
+	}
+	status := EvaluatePreconditions(req, res.Header)
+	if status == 0 {
+		return res
+	}
+
+	header := make(http.Header)
+	if status == http.StatusNotModified {
+		// A 304 response contains the header fields that would have been sent
+		// in a 200 (OK) response to the same request.
+		// https://httpwg.org/specs/rfc9110.html#status.304
+		for _, k := range notModifiedHeaders {
+			if vv, ok := res.Header[k]; ok {
+				header[k] = vv
+			}
+		}
+	}
+
+	if res.Body != nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      res.Proto,
+		ProtoMajor: res.ProtoMajor,
+		ProtoMinor: res.ProtoMinor,
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// notModifiedHeaders holds the header fields sent in a 304 (Not Modified) response.
+var notModifiedHeaders = []string{
+	HeaderAge,
+	HeaderCacheControl,
+	HeaderContentLocation,
+	HeaderDate,
+	HeaderEtag,
+	HeaderExpires,
+	HeaderLastModified,
+	HeaderVary,
+	HeaderWarning,
+}
+
+// lastModified returns the Last-Modified date of the response, falling back
+// to the Date header if Last-Modified is missing.
+// https://httpwg.org/specs/rfc9111.html#validation.received
+func lastModified(header http.Header) time.Time {
+	if lm := parseHttpTime(header.Get(HeaderLastModified)); !lm.IsZero() {
+		return lm
+	}
+	return parseHttpTime(header.Get(HeaderDate))
+}
+
+// matchEtag checks whether the entity-tag matches any of the entity-tags listed in the
+// field value of a If-Match or If-None-Match header. If strong is true, the strong
+// comparison function is used, the weak comparison function otherwise.
+// https://httpwg.org/specs/rfc9110.html#entity.tag.comparison
+func matchEtag(field string, etag string, strong bool) bool {
+	if strings.TrimSpace(field) == "*" {
+		return etag != ""
+	}
+	if etag == "" || (strong && isWeakEtag(etag)) {
+		return false
+	}
+	for _, candidate := range strings.Split(field, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && isWeakEtag(candidate) {
+			continue
+		}
+		if opaqueTag(candidate) == opaqueTag(etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWeakEtag returns true if the entity-tag has the weak indicator.
+func isWeakEtag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// opaqueTag returns the opaque-tag of an entity-tag without the weak indicator.
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
@@ -0,0 +1,128 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluatePreconditions(t *testing.T) {
+	lastModified := formatTime(currentTime())
+	before := formatTime(currentTime().Add(-seconds(10)))
+	after := formatTime(currentTime().Add(seconds(10)))
+
+	testCases := []struct {
+		name   string
+		method string
+		header map[string]string
+		want   int
+	}{
+		{"No preconditions", http.MethodGet, nil, 0},
+		{"If-None-Match matches", http.MethodGet, map[string]string{HeaderIfNoneMatch: `"a1"`}, http.StatusNotModified},
+		{"If-None-Match matches weak", http.MethodGet, map[string]string{HeaderIfNoneMatch: `W/"a1"`}, http.StatusNotModified},
+		{"If-None-Match matches list", http.MethodGet, map[string]string{HeaderIfNoneMatch: `"a0", "a1"`}, http.StatusNotModified},
+		{"If-None-Match matches any", http.MethodGet, map[string]string{HeaderIfNoneMatch: "*"}, http.StatusNotModified},
+		{"If-None-Match does not match", http.MethodGet, map[string]string{HeaderIfNoneMatch: `"a2"`}, 0},
+		{"If-None-Match matches unsafe method", http.MethodPut, map[string]string{HeaderIfNoneMatch: `"a1"`}, http.StatusPreconditionFailed},
+		{"If-None-Match precedes If-Modified-Since", http.MethodGet,
+			map[string]string{HeaderIfNoneMatch: `"a2"`, HeaderIfModifiedSince: after}, 0},
+		{"If-Modified-Since not modified", http.MethodGet, map[string]string{HeaderIfModifiedSince: after}, http.StatusNotModified},
+		{"If-Modified-Since same date", http.MethodGet, map[string]string{HeaderIfModifiedSince: lastModified}, http.StatusNotModified},
+		{"If-Modified-Since modified", http.MethodGet, map[string]string{HeaderIfModifiedSince: before}, 0},
+		{"If-Modified-Since invalid date", http.MethodGet, map[string]string{HeaderIfModifiedSince: "invalid"}, 0},
+		{"If-Match matches", http.MethodGet, map[string]string{HeaderIfMatch: `"a1"`}, 0},
+		{"If-Match does not match", http.MethodGet, map[string]string{HeaderIfMatch: `"a2"`}, http.StatusPreconditionFailed},
+		{"If-Match weak does not match strong", http.MethodGet, map[string]string{HeaderIfMatch: `W/"a1"`}, http.StatusPreconditionFailed},
+		{"If-Unmodified-Since not modified", http.MethodGet, map[string]string{HeaderIfUnmodifiedSince: after}, 0},
+		{"If-Unmodified-Since modified", http.MethodGet, map[string]string{HeaderIfUnmodifiedSince: before}, http.StatusPreconditionFailed},
+		{"If-Match precedes If-Unmodified-Since", http.MethodGet,
+			map[string]string{HeaderIfMatch: `"a1"`, HeaderIfUnmodifiedSince: before}, 0},
+	}
+
+	header := http.Header{}
+	header.Set(HeaderEtag, `"a1"`)
+	header.Set(HeaderLastModified, lastModified)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, "/", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tc.want, EvaluatePreconditions(req, header))
+		})
+	}
+}
+
+func TestEvaluatePreconditionsDateFallback(t *testing.T) {
+	// If Last-Modified is missing, the Date header is used.
+	header := http.Header{}
+	header.Set(HeaderDate, formatTime(currentTime()))
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderIfModifiedSince, formatTime(currentTime()))
+	assert.Equal(t, http.StatusNotModified, EvaluatePreconditions(req, header))
+}
+
+func TestConditionalResponse(t *testing.T) {
+	newResponse := func() *http.Response {
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("42")),
+		}
+		res.Header.Set(HeaderEtag, `"a1"`)
+		res.Header.Set(HeaderCacheControl, "max-age=60")
+		res.Header.Set("Content-Type", "text/plain")
+		return res
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderIfNoneMatch, `"a1"`)
+
+	res := ConditionalResponse(req, newResponse())
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	assert.Equal(t, "304 Not Modified", res.Status)
+	assert.Equal(t, `"a1"`, res.Header.Get(HeaderEtag))
+	assert.Equal(t, "max-age=60", res.Header.Get(HeaderCacheControl))
+	assert.Equal(t, "", res.Header.Get("Content-Type"))
+
+	req.Header.Set(HeaderIfNoneMatch, `"a2"`)
+	res = ConditionalResponse(req, newResponse())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "42", string(body))
+
+	// Preconditions are only evaluated for successful responses.
+	notFound := newResponse()
+	notFound.StatusCode = http.StatusNotFound
+	req.Header.Set(HeaderIfNoneMatch, `"a1"`)
+	assert.Equal(t, http.StatusNotFound, ConditionalResponse(req, notFound).StatusCode)
+}
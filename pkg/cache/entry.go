@@ -23,9 +23,16 @@
 package cache
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 // EntryStatus is the state of a cached response.
@@ -43,6 +50,10 @@ const (
 
 	// EntryError indicates an error occurred while retrieving the response.
 	EntryLookupError
+
+	// EntryStaleWhileRevalidate indicates that the cached response is stale, but can be
+	// used while it is revalidated in the background (stale-while-revalidate).
+	EntryStaleWhileRevalidate
 )
 
 // String returns the Entry Status as a string.
@@ -56,39 +67,363 @@ func (s EntryStatus) String() string {
 		return "EntryRequiresValidation"
 	case EntryLookupError:
 		return "EntryLookupError"
+	case EntryStaleWhileRevalidate:
+		return "EntryStaleWhileRevalidate"
 	default:
 		return fmt.Sprintf("Unknown state: %d", s)
 	}
 }
 
+// EntryVersion is the version of the binary entry format written by Encode.
+// Entries of version 1, which precede the flags field, are still decoded.
+const EntryVersion byte = 2
+
+// entryInvalidated is the flag of an entry invalidated by a soft purge.
+const entryInvalidated uint64 = 1 << 0
+
+// entryNegative is the flag of a negatively cached entry without freshness information.
+const entryNegative uint64 = 1 << 1
+
+// entryForced is the flag of an entry stored by a force-cache rule.
+const entryForced uint64 = 1 << 2
+
+// entryMagic prefixes every encoded entry. A gob stream never starts with a zero byte,
+// which allows to distinguish entries from legacy gob encoded entries.
+var entryMagic = []byte{0x00, 'k', 'c', 'e'}
+
+var (
+	// ErrIncompatibleEntry indicates that an entry has been encoded with an
+	// unknown version of the entry format and cannot be decoded.
+	ErrIncompatibleEntry = errors.New("incompatible cache entry version")
+
+	// errMalformedEntry indicates that an encoded entry is truncated or corrupt.
+	errMalformedEntry = errors.New("malformed cache entry")
+)
+
 // Entry is the cache entry.
 type Entry struct {
-	// Body is the entry body as a serialized http.Response
+	// StatusCode is the status code of the stored response.
+	StatusCode int
+
+	// Header is the header of the stored response.
+	Header http.Header
+
+	// Body is the body of the stored response.
 	Body []byte
 
-	// Timestamp is the time the body was last modified.
-	Timestamp int64
+	// RequestTime is the time the request for the stored response was received.
+	RequestTime time.Time
+
+	// ResponseTime is the time the stored response was received or last validated.
+	ResponseTime time.Time
+
+	// ETag and LastModified hold the validators of the stored response.
+	ETag         string
+	LastModified string
+
+	// Vary holds the header field names nominated by the Vary header of the
+	// stored response. If set, the entry does not hold a response itself, but
+	// redirects the lookup to the variant stored under the secondary key.
+	Vary []string
+
+	// VaryKey is the secondary key the entry is stored under, if the
+	// entry is a variant of a response with a Vary header.
+	VaryKey string
+
+	// TTL is the time-to-live the entry has been stored with.
+	TTL time.Duration
+
+	// Invalidated marks an entry that has been soft purged. The entry is kept
+	// in the cache, but must be validated by the origin before it is served.
+	Invalidated bool
+
+	// Negative marks a negatively cached error response without explicit freshness
+	// information. The entry is fresh for its TTL, see HttpCacheConfig.NegativeTTLs.
+	Negative bool
+
+	// Forced marks a response stored by a force-cache rule, regardless of its Cache-Control
+	// directives. The entry is served until it expires, see Rule.ForceCache.
+	Forced bool
+}
+
+// NewEntry creates a cache entry from the response. The response body is read
+// completely and replaced, so that the response can still be served afterwards.
+func NewEntry(res *http.Response, requestTime, responseTime time.Time) (*Entry, error) {
+	var body []byte
+	if res.Body != nil {
+		var err error
+		body, err = io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	header := res.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &Entry{
+		StatusCode:   res.StatusCode,
+		Header:       header,
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		ETag:         header.Get(HeaderEtag),
+		LastModified: header.Get(HeaderLastModified),
+	}, nil
 }
 
-// TODO: Benchmark, encoding/decoding might slow down the hot path.
+// Response returns the stored response for the given request.
+func (e *Entry) Response(req *http.Request) *http.Response {
+	res := &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+	if res.Header == nil {
+		res.Header = make(http.Header)
+	}
+	if req != nil && req.Method == http.MethodHead {
+		res.Body = http.NoBody
+	}
+	return res
+}
 
-// Encode encodes an entry into a byte array.
+// Encode encodes an entry into a byte array. The encoded entry starts with the format
+// version, followed by the metadata and header fields, followed by the body:
+//
+//	magic | version | status | request time | response time | ttl | flags |
+//	etag | last-modified | vary key | vary | header | body
 func (e *Entry) Encode() ([]byte, error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(e); err != nil {
-		return nil, err
+	buf := make([]byte, 0, 256+len(e.Body))
+	buf = append(buf, entryMagic...)
+	buf = append(buf, EntryVersion)
+	buf = binary.AppendUvarint(buf, uint64(e.StatusCode))
+	buf = appendTime(buf, e.RequestTime)
+	buf = appendTime(buf, e.ResponseTime)
+	buf = binary.AppendVarint(buf, int64(e.TTL))
+	var flags uint64
+	if e.Invalidated {
+		flags |= entryInvalidated
+	}
+	if e.Negative {
+		flags |= entryNegative
+	}
+	if e.Forced {
+		flags |= entryForced
 	}
-	return buf.Bytes(), nil
+	buf = binary.AppendUvarint(buf, flags)
+	buf = appendString(buf, e.ETag)
+	buf = appendString(buf, e.LastModified)
+	buf = appendString(buf, e.VaryKey)
+	buf = binary.AppendUvarint(buf, uint64(len(e.Vary)))
+	for _, v := range e.Vary {
+		buf = appendString(buf, v)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(e.Header)))
+	for k, vv := range e.Header {
+		buf = appendString(buf, k)
+		buf = binary.AppendUvarint(buf, uint64(len(vv)))
+		for _, v := range vv {
+			buf = appendString(buf, v)
+		}
+	}
+	return append(buf, e.Body...), nil
 }
 
-// DecodeEntry decodes a byte array into an Entry.
+// DecodeEntry decodes a byte array into an Entry. The body of the decoded
+// entry references the given byte array. Legacy gob encoded entries are
+// converted into the current entry format.
 func DecodeEntry(data []byte) (*Entry, error) {
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	var entry *Entry
-	if err := dec.Decode(&entry); err != nil {
+	if !bytes.HasPrefix(data, entryMagic) {
+		return decodeLegacyEntry(data)
+	}
+	entry, n, err := decodeEntryHeader(data)
+	if err != nil {
 		return &Entry{}, err
 	}
+	entry.Body = data[n:]
 	return entry, nil
 }
+
+// DecodeEntryHeader decodes the metadata and header fields of an encoded entry,
+// without the body. Legacy gob encoded entries are decoded completely.
+func DecodeEntryHeader(data []byte) (*Entry, error) {
+	if !bytes.HasPrefix(data, entryMagic) {
+		entry, err := decodeLegacyEntry(data)
+		entry.Body = nil
+		return entry, err
+	}
+	entry, _, err := decodeEntryHeader(data)
+	if err != nil {
+		return &Entry{}, err
+	}
+	return entry, nil
+}
+
+// decodeEntryHeader decodes the metadata and header fields of an encoded entry.
+// It returns the entry and the offset of the body.
+func decodeEntryHeader(data []byte) (*Entry, int, error) {
+	d := entryDecoder{data: data, off: len(entryMagic)}
+	version := d.byte()
+	if version < 1 || version > EntryVersion {
+		return nil, 0, fmt.Errorf("%w: %d", ErrIncompatibleEntry, version)
+	}
+
+	entry := &Entry{
+		StatusCode:   int(d.uvarint()),
+		RequestTime:  d.time(),
+		ResponseTime: d.time(),
+		TTL:          time.Duration(d.varint()),
+	}
+	if version > 1 {
+		flags := d.uvarint()
+		entry.Invalidated = flags&entryInvalidated != 0
+		entry.Negative = flags&entryNegative != 0
+		entry.Forced = flags&entryForced != 0
+	}
+	entry.ETag = d.string()
+	entry.LastModified = d.string()
+	entry.VaryKey = d.string()
+	if n := d.len(); n > 0 {
+		entry.Vary = make([]string, n)
+		for i := range entry.Vary {
+			entry.Vary[i] = d.string()
+		}
+	}
+	if n := d.len(); n > 0 {
+		entry.Header = make(http.Header, n)
+		for i := 0; i < n; i++ {
+			k := d.string()
+			vv := make([]string, d.len())
+			for j := range vv {
+				vv[j] = d.string()
+			}
+			entry.Header[k] = vv
+		}
+	}
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	return entry, d.off, nil
+}
+
+// legacyEntry is the gob encoded cache entry holding a serialized http.Response.
+// It is only decoded to migrate entries stored before the versioned entry format.
+type legacyEntry struct {
+	Body      []byte
+	Timestamp int64
+	Vary      []string
+}
+
+// decodeLegacyEntry decodes a legacy gob encoded entry and converts it into an Entry.
+func decodeLegacyEntry(data []byte) (*Entry, error) {
+	var legacy *legacyEntry
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&legacy); err != nil {
+		return &Entry{}, err
+	}
+	responseTime := time.Unix(legacy.Timestamp, 0)
+	if len(legacy.Vary) > 0 {
+		return &Entry{Vary: legacy.Vary, ResponseTime: responseTime}, nil
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewBuffer(legacy.Body)), nil)
+	if err != nil {
+		return &Entry{}, err
+	}
+	return NewEntry(res, responseTime, responseTime)
+}
+
+// appendString appends a length-prefixed string.
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendTime appends a time as Unix nanoseconds, the zero time as zero.
+func appendTime(buf []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return binary.AppendVarint(buf, 0)
+	}
+	return binary.AppendVarint(buf, t.UnixNano())
+}
+
+// entryDecoder decodes the fields of an encoded entry. The first error is recorded,
+// any following reads return zero values.
+type entryDecoder struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (d *entryDecoder) byte() byte {
+	if d.err != nil || d.off >= len(d.data) {
+		d.err = errMalformedEntry
+		return 0
+	}
+	d.off++
+	return d.data[d.off-1]
+}
+
+func (d *entryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.off:])
+	if n <= 0 {
+		d.err = errMalformedEntry
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *entryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.off:])
+	if n <= 0 {
+		d.err = errMalformedEntry
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+// len decodes a length, which must not exceed the remaining data.
+func (d *entryDecoder) len() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)-d.off) {
+		d.err = errMalformedEntry
+		return 0
+	}
+	return int(n)
+}
+
+func (d *entryDecoder) string() string {
+	n := d.len()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.data[d.off : d.off+n])
+	d.off += n
+	return s
+}
+
+func (d *entryDecoder) time() time.Time {
+	if ns := d.varint(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
@@ -23,7 +23,17 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEntryStatusString(t *testing.T) {
@@ -35,6 +45,7 @@ func TestEntryStatusString(t *testing.T) {
 		{EntryInvalid, "EntryInvalid"},
 		{EntryRequiresValidation, "EntryRequiresValidation"},
 		{EntryLookupError, "EntryLookupError"},
+		{EntryStaleWhileRevalidate, "EntryStaleWhileRevalidate"},
 		{EntryStatus(10), "Unknown state: 10"},
 	}
 
@@ -45,3 +56,129 @@ func TestEntryStatusString(t *testing.T) {
 		}
 	}
 }
+
+func TestEntryEncodeDecode(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=3600"},
+			"Etag":          []string{`"a1"`},
+			"Last-Modified": []string{formatTime(currentTime())},
+			"Set-Cookie":    []string{"a=1", "b=2"},
+		},
+		Body: io.NopCloser(strings.NewReader("hello world")),
+	}
+	entry, err := NewEntry(res, currentTime(), currentTime().Add(time.Second))
+	require.NoError(t, err)
+	entry.Vary = []string{"Accept-Encoding"}
+	entry.VaryKey = "key#vary-1"
+	entry.TTL = time.Hour
+
+	// The response body is still readable after creating the entry.
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	data, err := entry.Encode()
+	require.NoError(t, err)
+
+	decoded, err := DecodeEntry(data)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, decoded.StatusCode)
+	assert.Equal(t, []string{"a=1", "b=2"}, decoded.Header.Values("Set-Cookie"))
+	assert.Equal(t, "11", decoded.Header.Get("Content-Length"))
+	assert.Equal(t, "hello world", string(decoded.Body))
+	assert.True(t, currentTime().Equal(decoded.RequestTime))
+	assert.True(t, currentTime().Add(time.Second).Equal(decoded.ResponseTime))
+	assert.Equal(t, `"a1"`, decoded.ETag)
+	assert.Equal(t, formatTime(currentTime()), decoded.LastModified)
+	assert.Equal(t, []string{"Accept-Encoding"}, decoded.Vary)
+	assert.Equal(t, "key#vary-1", decoded.VaryKey)
+	assert.Equal(t, time.Hour, decoded.TTL)
+	assert.False(t, decoded.Invalidated)
+
+	// Headers are decoded without the body.
+	header, err := DecodeEntryHeader(data)
+	require.NoError(t, err)
+	assert.Nil(t, header.Body)
+	assert.Equal(t, decoded.Header, header.Header)
+	assert.Equal(t, decoded.TTL, header.TTL)
+
+	// The stored response is served for the request.
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	served := decoded.Response(req)
+	assert.Equal(t, "200 OK", served.Status)
+	assert.Equal(t, int64(11), served.ContentLength)
+	body, err = io.ReadAll(served.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	req.Method = http.MethodHead
+	assert.Equal(t, http.NoBody, decoded.Response(req).Body)
+}
+
+func TestDecodeEntryIncompatible(t *testing.T) {
+	data, err := (&Entry{StatusCode: http.StatusOK}).Encode()
+	require.NoError(t, err)
+
+	// Unknown versions are detected.
+	data[len(entryMagic)] = EntryVersion + 1
+	_, err = DecodeEntry(data)
+	assert.ErrorIs(t, err, ErrIncompatibleEntry)
+
+	// Truncated entries are detected.
+	data, err = (&Entry{StatusCode: http.StatusOK, ETag: `"a1"`}).Encode()
+	require.NoError(t, err)
+	_, err = DecodeEntry(data[:len(data)-3])
+	assert.Error(t, err)
+}
+
+func TestDecodeEntryInvalidated(t *testing.T) {
+	data, err := (&Entry{StatusCode: http.StatusOK, ETag: `"a1"`, Invalidated: true}).Encode()
+	require.NoError(t, err)
+	entry, err := DecodeEntry(data)
+	require.NoError(t, err)
+	assert.True(t, entry.Invalidated)
+	assert.Equal(t, `"a1"`, entry.ETag)
+
+	// Version 1 entries have no flags field, which follows the status (2 bytes)
+	// and the zero request time, response time and TTL (1 byte each).
+	data, err = (&Entry{StatusCode: http.StatusOK, ETag: `"a1"`}).Encode()
+	require.NoError(t, err)
+	off := len(entryMagic) + 1 + 2 + 3
+	v1 := append(append([]byte{}, data[:off]...), data[off+1:]...)
+	v1[len(entryMagic)] = 1
+	entry, err = DecodeEntry(v1)
+	require.NoError(t, err)
+	assert.False(t, entry.Invalidated)
+	assert.Equal(t, `"a1"`, entry.ETag)
+}
+
+func TestDecodeLegacyEntry(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1, ProtoMinor: 1,
+		Header:        http.Header{"Etag": []string{`"a1"`}},
+		ContentLength: 5,
+		Body:          io.NopCloser(strings.NewReader("hello")),
+	}
+	dump, err := httputil.DumpResponse(res, true)
+	require.NoError(t, err)
+
+	encode := func(e legacyEntry) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, gob.NewEncoder(&buf).Encode(&e))
+		return buf.Bytes()
+	}
+
+	entry, err := DecodeEntry(encode(legacyEntry{Body: dump, Timestamp: 1257894000}))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, entry.StatusCode)
+	assert.Equal(t, "hello", string(entry.Body))
+	assert.Equal(t, `"a1"`, entry.ETag)
+	assert.Equal(t, int64(1257894000), entry.ResponseTime.Unix())
+
+	entry, err = DecodeEntry(encode(legacyEntry{Vary: []string{"Accept"}, Timestamp: 1257894000}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Accept"}, entry.Vary)
+}
@@ -0,0 +1,216 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Reasons why a request bypasses the cache.
+const (
+	BypassUnsafeMethod       = "unsafe method"
+	BypassRule               = "rule"
+	BypassExcludedPath       = "excluded path"
+	BypassExcludedHeader     = "excluded header"
+	BypassUncacheableRequest = "uncacheable request"
+)
+
+// Reasons why a response is not stored.
+const (
+	ReasonPartialContent    = "partial content"
+	ReasonAuthorization     = "authorized request"
+	ReasonHeadRequest       = "head request"
+	ReasonRule              = "rule"
+	ReasonExcludedContent   = "excluded content"
+	ReasonRequestNoStore    = "request no-store"
+	ReasonVaryAll           = "vary *"
+	ReasonMustUnderstand    = "must-understand with unknown status code"
+	ReasonNoStore           = "no-store"
+	ReasonStatusCode        = "status code not cacheable"
+	ReasonNoFreshness       = "no freshness information"
+	ReasonValidatorMismatch = "validator mismatch"
+)
+
+// Explanation explains how the cache handles a request. Durations are in seconds.
+type Explanation struct {
+	// Key is the computed cache key of the request.
+	Key string `json:"key"`
+
+	// Bypass holds the reason why the request bypasses the cache, if any.
+	Bypass string `json:"bypass,omitempty"`
+
+	// Lookup holds the status of the cache lookup.
+	Lookup string `json:"lookup,omitempty"`
+
+	// Freshness holds the freshness calculation of the cached response, if any.
+	Freshness *Freshness `json:"freshness,omitempty"`
+
+	// PathTTL is the TTL of entries of the request path, or of the rules matching the request.
+	PathTTL int64 `json:"path_ttl"`
+
+	// Rules holds the names of the rules matching the request.
+	Rules []string `json:"rules,omitempty"`
+
+	// Stored indicates whether the response has been stored.
+	Stored bool `json:"stored"`
+
+	// Reason holds the reason why the response has not been stored, if any.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Freshness holds the freshness calculation of a cached response.
+type Freshness struct {
+	// Age is the current age of the response.
+	Age int64 `json:"age"`
+
+	// Lifetime is the freshness lifetime of the response.
+	Lifetime int64 `json:"lifetime"`
+
+	// TTL is the remaining freshness lifetime, negative if the response is stale.
+	TTL int64 `json:"ttl"`
+
+	// Heuristic indicates that the lifetime is a heuristic freshness lifetime.
+	Heuristic bool `json:"heuristic"`
+}
+
+// SetStored records whether the response has been stored, and the reason if not.
+func (e *Explanation) SetStored(stored bool, reason string) {
+	if e == nil {
+		return
+	}
+	e.Stored, e.Reason = stored, reason
+}
+
+// NewExplanation creates the explanation of the request.
+func (c *HttpCache) NewExplanation(req *http.Request) *Explanation {
+	actions := c.RequestRules(req)
+	return &Explanation{
+		Key:     c.KeyFromRequest(req).String(),
+		PathTTL: int64(actions.ttl(c.PathTTL(req.URL.Path)) / time.Second),
+		Rules:   actions.Rules,
+	}
+}
+
+// Explain records the result of the cache lookup in the explanation of the lookup request, if any.
+func (c *HttpCache) Explain(lookup *LookupRequest, cached *LookupResult) {
+	e := lookup.Explanation
+	if e == nil {
+		return
+	}
+	e.Key = lookup.Key.String()
+	e.Lookup = cached.Status.String()
+	if cached.cachedResponse != nil {
+		e.Freshness = &Freshness{
+			Age:       int64(cached.age / time.Second),
+			Lifetime:  int64(cached.lifetime / time.Second),
+			TTL:       int64(cached.TTL() / time.Second),
+			Heuristic: cached.heuristic,
+		}
+	}
+}
+
+// ExplainRequest explains how the cache would handle the request, without forwarding it to
+// the origin. Hence, the explanation does not tell whether the response would be stored.
+func (c *HttpCache) ExplainRequest(ctx context.Context, req *http.Request) *Explanation {
+	if r, err := c.ReadBody(req); err == nil {
+		req = r
+	}
+	e := c.NewExplanation(req)
+	if e.Bypass = c.BypassReason(req); e.Bypass != "" {
+		return e
+	}
+	lookup := c.NewLookup(req, time.Now())
+	lookup.Explanation = e
+	c.Explain(lookup, c.FetchResponse(ctx, *lookup))
+	return e
+}
+
+// AddExplanation adds the explanation in JSON format to the explain header.
+func (c *HttpCache) AddExplanation(header http.Header, e *Explanation) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Error().Err(err).Msg("Error encoding explanation")
+		return
+	}
+	header.Set(c.ExplainHeader(), string(b))
+}
+
+// ExplainHeader returns the explain header, or an empty string if disabled.
+func (c *HttpCache) ExplainHeader() string {
+	config := c.loadConfig()
+	return config.ExplainHeader
+}
+
+// BypassReason returns the reason why the request bypasses the cache, or an empty
+// string, if the request can be served from the cache. POST requests keyed by
+// their body are cacheable, see ReadBody.
+func (c *HttpCache) BypassReason(req *http.Request) string {
+	post := isCacheablePost(req)
+	switch {
+	case IsUnsafeMethod(req.Method) && !post:
+		return BypassUnsafeMethod
+	case c.RequestRules(req).Bypass:
+		return BypassRule
+	case c.IsExcludedPath(req.URL.Path):
+		return BypassExcludedPath
+	case c.IsExcludedHeader(req.Header):
+		return BypassExcludedHeader
+	case !IsCacheableRequest(req) && !post:
+		return BypassUncacheableRequest
+	}
+	return ""
+}
+
+// UnstorableReason returns the reason why the response to the lookup request must not be
+// stored, or an empty string, if it can be stored. Partial responses, and responses to
+// authorized requests which must not be stored by a shared cache, are reported first, as
+// they must not replace the stored response either. Rules may prevent, or force, storing
+// the response. In strict mode, the Cache-Control directives of the request and response
+// are respected.
+func (c *HttpCache) UnstorableReason(lookup *LookupRequest, res *http.Response) string {
+	actions := c.ResponseRules(lookup.Request, res)
+	switch {
+	case res.StatusCode == http.StatusPartialContent:
+		return ReasonPartialContent
+	case lookup.Key.Credential == "" && !IsCacheableAuthorized(lookup.Request, res):
+		return ReasonAuthorization
+	case lookup.Request.Method == http.MethodHead:
+		return ReasonHeadRequest
+	case actions.Bypass:
+		return ReasonRule
+	case actions.ForceCache:
+		return ""
+	case c.IsExcludedContent(res.Header.Get("Content-Type"), res.ContentLength):
+		return ReasonExcludedContent
+	case !c.Strict():
+		return ""
+	case lookup.ReqCacheControl.NoStore:
+		return ReasonRequestNoStore
+	}
+	return c.uncacheableReason(res)
+}
@@ -0,0 +1,158 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBypassReason(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Exclude: &Exclude{
+			Path:   []string{"^/admin"},
+			Header: map[string]string{"X-Requested-With": "XMLHttpRequest"},
+		},
+	}, p)
+	require.NoError(t, err)
+
+	tests := []struct {
+		method   string
+		url      string
+		header   http.Header
+		expected string
+	}{
+		{http.MethodGet, "http://example.com/", nil, ""},
+		{http.MethodHead, "http://example.com/", nil, ""},
+		{http.MethodPost, "http://example.com/", nil, BypassUnsafeMethod},
+		{http.MethodGet, "http://example.com/admin/users", nil, BypassExcludedPath},
+		{http.MethodGet, "http://example.com/", http.Header{"X-Requested-With": {"XMLHttpRequest"}},
+			BypassExcludedHeader},
+		{http.MethodOptions, "http://example.com/", nil, BypassUncacheableRequest},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		if tt.header != nil {
+			req.Header = tt.header
+		}
+		assert.Equal(t, tt.expected, c.BypassReason(req), "%s %s", tt.method, tt.url)
+	}
+}
+
+func TestUnstorableReason(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Strict:  true,
+		Exclude: &Exclude{Content: []Content{{Type: "video/.*"}}},
+	}, p)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		method   string
+		reqCC    string
+		auth     bool
+		status   int
+		header   http.Header
+		expected string
+	}{
+		{"storable", http.MethodGet, "", false, 200,
+			http.Header{"Cache-Control": {"max-age=60"}}, ""},
+		{"partial content", http.MethodGet, "", false, 206,
+			http.Header{"Cache-Control": {"max-age=60"}}, ReasonPartialContent},
+		{"authorized", http.MethodGet, "", true, 200,
+			http.Header{"Cache-Control": {"max-age=60"}}, ReasonAuthorization},
+		{"head", http.MethodHead, "", false, 200,
+			http.Header{"Cache-Control": {"max-age=60"}}, ReasonHeadRequest},
+		{"excluded content", http.MethodGet, "", false, 200,
+			http.Header{"Cache-Control": {"max-age=60"}, "Content-Type": {"video/mp4"}}, ReasonExcludedContent},
+		{"request no-store", http.MethodGet, "no-store", false, 200,
+			http.Header{"Cache-Control": {"max-age=60"}}, ReasonRequestNoStore},
+		{"vary all", http.MethodGet, "", false, 200,
+			http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, ReasonVaryAll},
+		{"no-store", http.MethodGet, "", false, 200,
+			http.Header{"Cache-Control": {"no-store"}}, ReasonNoStore},
+		{"status code", http.MethodGet, "", false, 500,
+			http.Header{"Cache-Control": {"max-age=60"}}, ReasonStatusCode},
+		{"no freshness", http.MethodGet, "", false, 200,
+			http.Header{}, ReasonNoFreshness},
+		{"must-understand", http.MethodGet, "", false, 299,
+			http.Header{"Cache-Control": {"max-age=60, must-understand, no-store"}}, ReasonMustUnderstand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, "http://example.com/", nil)
+			if tt.reqCC != "" {
+				req.Header.Set("Cache-Control", tt.reqCC)
+			}
+			if tt.auth {
+				req.Header.Set("Authorization", "Bearer alice")
+			}
+			lookup := c.NewLookup(req, time.Now())
+			res := &http.Response{StatusCode: tt.status, Header: tt.header}
+			assert.Equal(t, tt.expected, c.UnstorableReason(lookup, res))
+		})
+	}
+}
+
+func TestExplainRequest(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Strict:   true,
+		Timeouts: []Timeout{{Path: "^/news", TTL: 300 * time.Second}},
+	}, p)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/news", nil)
+	assert.Equal(t, &Explanation{
+		Key:     "kache-http://example.com/news",
+		Lookup:  "EntryInvalid",
+		PathTTL: 300,
+	}, c.ExplainRequest(ctx, req))
+
+	now := time.Now()
+	res := &http.Response{StatusCode: 200, Header: http.Header{
+		"Cache-Control": {"max-age=60"},
+		"Date":          {now.Add(-20 * time.Second).UTC().Format(http.TimeFormat)},
+	}}
+	c.StoreResponse(ctx, c.NewLookup(req, now), res, now)
+
+	e := c.ExplainRequest(ctx, req)
+	assert.Equal(t, "EntryOk", e.Lookup)
+	require.NotNil(t, e.Freshness)
+	assert.InDelta(t, 20, e.Freshness.Age, 1)
+	assert.Equal(t, int64(60), e.Freshness.Lifetime)
+	assert.InDelta(t, 40, e.Freshness.TTL, 1)
+	assert.False(t, e.Freshness.Heuristic)
+
+	req, _ = http.NewRequest(http.MethodPost, "http://example.com/news", nil)
+	assert.Equal(t, BypassUnsafeMethod, c.ExplainRequest(ctx, req).Bypass)
+}
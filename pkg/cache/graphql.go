@@ -0,0 +1,211 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// graphQLRequest is a GraphQL request in JSON encoding.
+// https://graphql.github.io/graphql-over-http/draft/#sec-Request-Parameters
+type graphQLRequest struct {
+	Query         string `json:"query"`
+	OperationName string `json:"operationName,omitempty"`
+	Variables     any    `json:"variables,omitempty"`
+	Extensions    any    `json:"extensions,omitempty"`
+}
+
+// normalizeGraphQL returns the normalized encoding of a GraphQL request, if the request
+// executes a query. Ignored tokens of the query, i.e. whitespace, commas and comments, as
+// well as the order of variables and extensions, do not change the normalized encoding.
+// Mutations, subscriptions, batched and malformed requests are rejected.
+func normalizeGraphQL(body []byte) ([]byte, bool) {
+	var req graphQLRequest
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // keep numbers verbatim
+	if err := dec.Decode(&req); err != nil {
+		return nil, false
+	}
+	tokens, ok := graphQLTokens(req.Query)
+	if !ok {
+		return nil, false
+	}
+	if typ, ok := graphQLOperationType(tokens, req.OperationName); !ok || typ != "query" {
+		return nil, false
+	}
+	req.Query = strings.Join(tokens, " ")
+	normalized, err := json.Marshal(req)
+	return normalized, err == nil
+}
+
+// graphQLOperationType returns the type (query, mutation or subscription) of the operation
+// of the document executed by the request, given the tokens of the document. The operation
+// is selected by name, unless the document contains a single operation.
+// https://spec.graphql.org/October2021/#sec-Executing-Requests
+func graphQLOperationType(tokens []string, operationName string) (string, bool) {
+	type operation struct{ typ, name string }
+	var (
+		operations []operation
+		current    *operation
+		expectName bool
+		depth      int
+	)
+	for _, tok := range tokens {
+		switch tok {
+		case "{", "(", "[":
+			if tok == "{" && depth == 0 && current == nil {
+				current = &operation{typ: "query"} // query shorthand
+			}
+			depth++
+			expectName = false
+			continue
+		case "}", ")", "]":
+			if depth--; depth < 0 {
+				return "", false
+			}
+			if tok == "}" && depth == 0 && current != nil {
+				if current.typ != "fragment" {
+					operations = append(operations, *current)
+				}
+				current = nil
+			}
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+		switch {
+		case current == nil && (tok == "query" || tok == "mutation" || tok == "subscription" || tok == "fragment"):
+			current = &operation{typ: tok}
+			expectName = true
+		case expectName && isGraphQLName(tok):
+			current.name = tok
+			expectName = false
+		default:
+			expectName = false
+		}
+	}
+	if depth != 0 || current != nil {
+		return "", false
+	}
+
+	if operationName != "" {
+		for _, op := range operations {
+			if op.name == operationName {
+				return op.typ, true
+			}
+		}
+		return "", false
+	}
+	if len(operations) != 1 {
+		return "", false
+	}
+	return operations[0].typ, true
+}
+
+// graphQLTokens splits a GraphQL document into its lexical tokens, dropping ignored
+// tokens, i.e. whitespace, commas and comments. It returns false, if a string is not
+// terminated. https://spec.graphql.org/October2021/#sec-Language.Source-Text
+func graphQLTokens(doc string) ([]string, bool) {
+	var tokens []string
+	for i := 0; i < len(doc); {
+		ch := doc[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == ',':
+			i++
+		case strings.HasPrefix(doc[i:], "\uFEFF"): // unicode BOM
+			i += len("\uFEFF")
+		case ch == '#':
+			for i < len(doc) && doc[i] != '\n' && doc[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(doc[i:], `"""`):
+			j := i + 3
+			for {
+				k := strings.Index(doc[j:], `"""`)
+				if k < 0 {
+					return nil, false
+				}
+				escaped := k > 0 && doc[j+k-1] == '\\'
+				j += k + 3
+				if !escaped {
+					break
+				}
+			}
+			tokens = append(tokens, doc[i:j])
+			i = j
+		case ch == '"':
+			j := i + 1
+			for ; j < len(doc) && doc[j] != '"'; j++ {
+				if doc[j] == '\\' {
+					j++
+				} else if doc[j] == '\n' || doc[j] == '\r' {
+					return nil, false
+				}
+			}
+			if j >= len(doc) {
+				return nil, false
+			}
+			tokens = append(tokens, doc[i:j+1])
+			i = j + 1
+		case strings.HasPrefix(doc[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 3
+		case ch == '-' || isDigit(ch):
+			j := i + 1
+			for j < len(doc) && (isDigit(doc[j]) || strings.IndexByte(".eE+-", doc[j]) >= 0) {
+				j++
+			}
+			tokens = append(tokens, doc[i:j])
+			i = j
+		case isNameStart(ch):
+			j := i + 1
+			for j < len(doc) && (isNameStart(doc[j]) || isDigit(doc[j])) {
+				j++
+			}
+			tokens = append(tokens, doc[i:j])
+			i = j
+		default:
+			tokens = append(tokens, doc[i:i+1])
+			i++
+		}
+	}
+	return tokens, true
+}
+
+// isGraphQLName checks if the token is a name.
+func isGraphQLName(tok string) bool {
+	return tok != "" && isNameStart(tok[0])
+}
+
+// isNameStart checks if the character can start a name.
+func isNameStart(ch byte) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z')
+}
+
+// isDigit checks if the character is a digit.
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
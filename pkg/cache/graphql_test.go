@@ -0,0 +1,96 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphQLOperationType(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		expected      string
+		ok            bool
+	}{
+		{"shorthand", `{ user(id: 1) { name } }`, "", "query", true},
+		{"query", `query User($id: ID = 1) @cached { user(id: $id) { name } }`, "", "query", true},
+		{"mutation", `mutation { like(id: 1) { count } }`, "", "mutation", true},
+		{"subscription", `subscription OnLike { liked { id } }`, "", "subscription", true},
+		{"fragment", `query Q { user { ...F } } fragment F on User { name }`, "", "query", true},
+		{"select by name", `query Q { a } mutation M { b }`, "M", "mutation", true},
+		{"ambiguous", `query Q { a } mutation M { b }`, "", "", false},
+		{"unknown name", `query Q { a }`, "M", "", false},
+		{"field named mutation", `query { mutation { id } }`, "", "query", true},
+		{"default object value", `query Q($f: Filter = {a: 1}) { items(filter: $f) { id } }`, "", "query", true},
+		{"strings and comments", "# mutation { x }\nquery { a(s: \"} mutation {\", b: \"\"\"\n}\\\"\"\"\"\"\") }", "", "query", true},
+		{"unterminated string", `query { a(s: "x) }`, "", "", false},
+		{"unbalanced", `query { a `, "", "", false},
+		{"empty", ``, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, ok := graphQLTokens(tt.query)
+			if ok {
+				var typ string
+				typ, ok = graphQLOperationType(tokens, tt.operationName)
+				assert.Equal(t, tt.expected, typ)
+			}
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
+func TestNormalizeGraphQL(t *testing.T) {
+	normalized, ok := normalizeGraphQL([]byte(`{"query": "query Q($id: ID) {\n  user(id: $id) {\n    name, email # contact\n  }\n}",
+		"variables": {"id": 12345678901234567890, "lang": "en"}, "operationName": "Q"}`))
+	require.True(t, ok)
+	assert.JSONEq(t, `{"query": "query Q ( $ id : ID ) { user ( id : $ id ) { name email } }",
+		"operationName": "Q", "variables": {"id": 12345678901234567890, "lang": "en"}}`, string(normalized))
+
+	// Ignored tokens and the order of variables do not change the normalized request.
+	other, ok := normalizeGraphQL([]byte(`{"operationName": "Q", "variables": {"lang": "en", "id": 12345678901234567890},
+		"query": "query Q($id:ID){user(id:$id){name email}}"}`))
+	require.True(t, ok)
+	assert.Equal(t, string(normalized), string(other))
+
+	// Variables are not rounded.
+	other, ok = normalizeGraphQL([]byte(`{"operationName": "Q", "variables": {"lang": "en", "id": 12345678901234567891},
+		"query": "query Q($id:ID){user(id:$id){name email}}"}`))
+	require.True(t, ok)
+	assert.NotEqual(t, string(normalized), string(other))
+
+	for _, body := range []string{
+		`{"query": "mutation { like(id: 1) { count } }"}`,
+		`[{"query": "{ a }"}, {"query": "{ b }"}]`,
+		`{"extensions": {"persistedQuery": {"version": 1, "sha256Hash": "abc"}}}`,
+		`not json`,
+	} {
+		_, ok := normalizeGraphQL([]byte(body))
+		assert.False(t, ok, body)
+	}
+}
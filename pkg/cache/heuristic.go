@@ -0,0 +1,74 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultHeuristicMaxAge is the default maximum heuristic freshness lifetime.
+var DefaultHeuristicMaxAge = 24 * time.Hour
+
+// heuristic holds the parameters of the heuristic freshness lifetime calculation.
+// https://httpwg.org/specs/rfc9111.html#heuristic.freshness
+type heuristic struct {
+	// fraction is the fraction of the time since the response was last modified.
+	fraction float64
+
+	// max is the maximum heuristic freshness lifetime.
+	max time.Duration
+}
+
+// applies checks if the heuristic freshness lifetime of a response can be calculated.
+func (h heuristic) applies(header http.Header) bool {
+	return h.fraction > 0 && !parseHttpTime(header.Get(HeaderLastModified)).IsZero()
+}
+
+// lifetime calculates the heuristic freshness lifetime of a response as a fraction of the
+// time between its Date (or response time, if not present) and Last-Modified, capped by
+// the maximum.
+func (h heuristic) lifetime(header http.Header, resTime time.Time) time.Duration {
+	date := parseHttpTime(header.Get(HeaderDate))
+	if date.IsZero() {
+		date = resTime
+	}
+	elapsed := date.Sub(parseHttpTime(header.Get(HeaderLastModified)))
+	return min(max(time.Duration(float64(elapsed)*h.fraction), 0), h.max)
+}
+
+// hasExplicitExpiration checks if the response carries an explicit expiration time.
+// https://httpwg.org/specs/rfc9111.html#calculating.freshness.lifetime
+func hasExplicitExpiration(header http.Header, resCacheControl ResponseCacheControl) bool {
+	return resCacheControl.MaxAge >= 0 || header.Get(HeaderExpires) != ""
+}
+
+// heuristic returns the configured heuristic freshness parameters.
+func (c *HttpCache) heuristic() heuristic {
+	config := c.loadConfig()
+	h := heuristic{fraction: config.HeuristicFraction, max: DefaultHeuristicMaxAge}
+	if t, err := time.ParseDuration(config.HeuristicMaxAge); err == nil && t >= 0 {
+		h.max = t
+	}
+	return h
+}
@@ -0,0 +1,131 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeuristicLifetime(t *testing.T) {
+	now := currentTime()
+	h := heuristic{fraction: 0.1, max: 24 * time.Hour}
+
+	tests := []struct {
+		name         string
+		date         string
+		lastModified string
+		applies      bool
+		lifetime     time.Duration
+	}{
+		{"No Last-Modified", formatTime(now), "", false, 0},
+		{"Invalid Last-Modified", formatTime(now), "yesterday", false, 0},
+		{"Fraction", formatTime(now), formatTime(now.Add(-10 * time.Hour)), true, time.Hour},
+		{"Response time", "", formatTime(now.Add(-10 * time.Hour)), true, time.Hour},
+		{"Capped", formatTime(now), formatTime(now.Add(-30 * 24 * time.Hour)), true, 24 * time.Hour},
+		{"Modified in future", formatTime(now), formatTime(now.Add(time.Hour)), true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.date != "" {
+				header.Set(HeaderDate, tt.date)
+			}
+			header.Set(HeaderLastModified, tt.lastModified)
+			assert.Equal(t, tt.applies, h.applies(header))
+			if tt.applies {
+				assert.Equal(t, tt.lifetime, h.lifetime(header, now))
+			}
+		})
+	}
+
+	assert.False(t, heuristic{}.applies(http.Header{HeaderLastModified: []string{formatTime(now)}}))
+}
+
+func TestHeuristicCacheable(t *testing.T) {
+	c, err := NewHttpCache(&HttpCacheConfig{Strict: true}, nil)
+	require.NoError(t, err)
+
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		HeaderDate:         []string{formatTime(currentTime())},
+		HeaderLastModified: []string{formatTime(currentTime().Add(-time.Hour))},
+	}}
+	assert.False(t, c.IsCacheableResponse(res))
+
+	c.UpdateConfig(&HttpCacheConfig{Strict: true, HeuristicFraction: 0.1})
+	assert.True(t, c.IsCacheableResponse(res))
+
+	res.StatusCode = http.StatusFound
+	assert.False(t, c.IsCacheableResponse(res))
+}
+
+func TestHeuristicFreshness(t *testing.T) {
+	ctx := context.Background()
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Strict:            true,
+		DefaultTTL:        "720h",
+		HeuristicFraction: 0.1,
+		HeuristicMaxAge:   "48h",
+	}, p)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://example.com/static.css", nil)
+	lookup := NewLookupRequest(req, currentTime(), true)
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{
+			HeaderDate:         []string{formatTime(currentTime())},
+			HeaderLastModified: []string{formatTime(currentTime().Add(-20 * 24 * time.Hour))},
+		},
+		Body: io.NopCloser(strings.NewReader("body")),
+	}
+	c.StoreResponse(ctx, lookup, res, currentTime())
+
+	fetch := func(after time.Duration) *LookupResult {
+		return c.FetchResponse(ctx, *NewLookupRequest(req, currentTime().Add(after), true))
+	}
+
+	// Fresh for 10% of 20 days, capped by 48 hours.
+	result := fetch(time.Hour)
+	assert.Equal(t, EntryOk, result.Status)
+	assert.Empty(t, result.Header().Get(HeaderWarning))
+
+	// Heuristically fresh responses older than 24 hours carry a warning.
+	result = fetch(36 * time.Hour)
+	assert.Equal(t, EntryOk, result.Status)
+	assert.Equal(t, WarningHeuristicExpiration, result.Header().Get(HeaderWarning))
+
+	result = fetch(49 * time.Hour)
+	assert.Equal(t, EntryRequiresValidation, result.Status)
+	assert.Empty(t, result.Header().Get(HeaderWarning))
+}
@@ -23,14 +23,12 @@
 package cache
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"net/http"
-	"net/http/httputil"
 	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -49,6 +47,12 @@ const (
 // DefaultTTL is the default time-to-live for cache entries.
 var DefaultTTL = 120 * time.Second
 
+// DefaultSliceSize is the default size of a slice of a sliced response.
+var DefaultSliceSize int64 = 1 << 20 // 1 MiB
+
+// DefaultMaxBodySize is the default maximum size of a response body stored in the cache.
+var DefaultMaxBodySize int64 = 32 << 20 // 32 MiB
+
 // HttpCacheConfig holds the http cache configuration.
 type HttpCacheConfig struct {
 	// Strict specifies the cache mode. When strict mode is enabled (default), the http cache
@@ -57,6 +61,11 @@ type HttpCacheConfig struct {
 	// is expired by its TTL (time-to-live).
 	Strict bool `yaml:"strict" json:"strict"`
 
+	// Offline specifies whether the cache is offline. When offline, requests are answered
+	// from the cache only, including stale responses, and never forwarded upstream.
+	// Requests that cannot be answered from the cache are answered with 504 (Gateway Timeout).
+	Offline bool `yaml:"offline" json:"offline"`
+
 	// XCache specifies if the XCache debug header should be attached to responses.
 	// If the response exists in the cache the header value is HIT, MISS otherwise.
 	XCache bool `yaml:"x_header" json:"x_header"`
@@ -64,6 +73,18 @@ type HttpCacheConfig struct {
 	// XCacheName is the name of the X-Cache header.
 	XCacheName string `yaml:"x_header_name" json:"x_header_name"`
 
+	// CacheStatus specifies if the Cache-Status header (RFC 9211) should be attached
+	// to responses, describing how the cache handled the request.
+	CacheStatus bool `yaml:"cache_status" json:"cache_status"`
+
+	// CacheStatusName is the name identifying the cache in the Cache-Status header.
+	CacheStatusName string `yaml:"cache_status_name" json:"cache_status_name"`
+
+	// ExplainHeader is the request header which makes the cache explain how it handles the
+	// request in the response header of the same name. Only requests of clients allowed by
+	// the API access control list are explained. Disabled if not specified.
+	ExplainHeader string `yaml:"explain_header" json:"explain_header"`
+
 	// Default TTL is the default TTL for cache entries. Overrides 'DefaultTTL'.
 	DefaultTTL string `yaml:"default_ttl" json:"default_ttl"`
 
@@ -73,11 +94,82 @@ type HttpCacheConfig struct {
 	// ForceCacheControl specifies whether to overwrite an existing cache-control header.
 	ForceCacheControl bool `yaml:"force_cache_control" json:"force_cache_control"`
 
+	// BrowserCacheControl specifies the Cache-Control header sent downstream with responses
+	// served from, or stored in, the cache, e.g. 'max-age=60'. The cache itself is governed
+	// by the Cache-Control and Surrogate-Control headers of the origin, or the default cache
+	// control. The Cache-Control header is passed on as is, if not specified.
+	BrowserCacheControl string `yaml:"browser_cache_control" json:"browser_cache_control"`
+
+	// StaleIfError is the grace period during which a stale response is served if the
+	// origin fails to respond (error or 5xx), regardless of any stale-if-error directive.
+	// In strict mode, entries are kept in the cache for the TTL plus the grace period.
+	StaleIfError string `yaml:"stale_if_error" json:"stale_if_error"`
+
+	// MaxBodySize is the maximum size of a response body in bytes to be stored in the cache.
+	// Responses are streamed to the client while being stored; storing is aborted once the
+	// body exceeds the limit. Defaults to 'DefaultMaxBodySize'.
+	MaxBodySize int64 `yaml:"max_body_size" json:"max_body_size"`
+
+	// TagHeader is the name of the response header holding the tags (surrogate keys) of
+	// a response. Stored responses are indexed by their tags, so they can be purged by tag.
+	// Defaults to 'DefaultTagHeader'.
+	TagHeader string `yaml:"tag_header" json:"tag_header"`
+
+	// InvalidateHeader is the name of the origin response header listing the URLs of the
+	// responses to be invalidated, e.g. 'X-Kache-Invalidate: /articles/42, /home'.
+	// Origin-driven invalidation by URL is disabled, if not specified.
+	InvalidateHeader string `yaml:"invalidate_header" json:"invalidate_header"`
+
+	// InvalidateTagsHeader is the name of the origin response header listing the tags of
+	// the responses to be invalidated, e.g. 'X-Kache-Invalidate-Tags: article-42 home'.
+	// Origin-driven invalidation by tag is disabled, if not specified.
+	InvalidateTagsHeader string `yaml:"invalidate_tags_header" json:"invalidate_tags_header"`
+
+	// HeuristicFraction is the fraction of the time since a response was last modified used
+	// as its heuristic freshness lifetime, if it carries Last-Modified, but no explicit
+	// expiration time, e.g. 0.1. Heuristic freshness is disabled, if not specified.
+	HeuristicFraction float64 `yaml:"heuristic_fraction" json:"heuristic_fraction"`
+
+	// HeuristicMaxAge caps the heuristic freshness lifetime. Defaults to 'DefaultHeuristicMaxAge'.
+	HeuristicMaxAge string `yaml:"heuristic_max_age" json:"heuristic_max_age"`
+
+	// CacheableStatusCodes holds the status codes of responses that can be stored in strict
+	// mode. Defaults to the status codes cacheable by default according to RFC 9110.
+	CacheableStatusCodes []int `yaml:"cacheable_status_codes" json:"cacheable_status_codes"`
+
+	// NegativeTTLs holds the TTLs of error responses per status code or class, e.g. 404 or 5xx.
+	// Responses without explicit freshness information are stored for the given TTL, even in
+	// strict mode. A status with a negative TTL is cacheable, regardless of CacheableStatusCodes.
+	NegativeTTLs []NegativeTTL `yaml:"negative_ttls" json:"negative_ttls"`
+
+	// Rules holds the cache rules, evaluated in order. Rules take precedence over the
+	// Timeouts, KeyPolicies and Exclude settings.
+	Rules []Rule `yaml:"rules" json:"rules"`
+
 	// Timeouts holds the TTLs per path/resource.
 	Timeouts []Timeout `yaml:"timeouts" json:"timeouts"`
 
+	// Private holds the paths/resources cached per credential. Responses to requests with an
+	// Authorization header are stored under a key derived from a hash of the credential, even
+	// if they do not explicitly allow shared caching.
+	Private []Private `yaml:"private" json:"private"`
+
+	// KeyPolicies holds the cache key composition per path/resource. The first policy
+	// matching the request path applies. Requests of other paths are keyed by scheme,
+	// host, path and the query sorted by parameter name.
+	KeyPolicies []KeyPolicy `yaml:"key_policies" json:"key_policies"`
+
+	// Post holds the paths/resources whose POST requests are cached by their body.
+	Post []Post `yaml:"post" json:"post"`
+
+	// Slices holds the paths/resources fetched from upstream and cached in slices.
+	Slices []Slice `yaml:"slices" json:"slices"`
+
 	// Exclude contains the cache exclude configuration.
 	Exclude *Exclude `yaml:"exclude" json:"exclude"`
+
+	// statusCodes holds the set of CacheableStatusCodes.
+	statusCodes map[int]struct{}
 }
 
 // Timeout holds the custom TTL configuration
@@ -90,6 +182,27 @@ type Timeout struct {
 	Matcher *regexp.Regexp `json:"-"`
 }
 
+// Slice holds the slice configuration. Responses to GET requests matching the path
+// are fetched from upstream in byte ranges of the given size. Each slice is stored
+// as a separate cache entry, so that large objects do not have to be held in memory
+// or stored in a single cache entry at once.
+type Slice struct {
+	// Path is the path the slicing is applied to. String or Regex.
+	Path string `yaml:"path" json:"path"`
+	// Size is the size of a slice in bytes. Defaults to 'DefaultSliceSize'.
+	Size int64 `yaml:"size,omitempty" json:"size,omitempty"`
+	// Matcher holds the compiled regex.
+	Matcher *regexp.Regexp `json:"-"`
+}
+
+// Private holds the configuration of paths/resources cached per credential.
+type Private struct {
+	// Path is the path cached per credential. String or Regex.
+	Path string `yaml:"path" json:"path"`
+	// Matcher holds the compiled regex.
+	Matcher *regexp.Regexp `json:"-"`
+}
+
 // Exclude holds the cache ignore information.
 type Exclude struct {
 	// Path contains the paths to be ignored by the cache.
@@ -127,6 +240,15 @@ type HttpCache struct {
 
 	// cache holds the inner caching provider.
 	cache provider.Provider
+
+	// offline is the offline mode, initialized from the config and toggled at runtime.
+	offline atomic.Bool
+
+	// bans holds the active bans loaded from the provider.
+	bans atomic.Pointer[banList]
+
+	// banMu guards loading and adding bans.
+	banMu sync.Mutex
 }
 
 // NewHttpCache creates a new http cache.
@@ -166,6 +288,69 @@ func (c *HttpCache) UpdateConfig(config *HttpCacheConfig) {
 		config.Timeouts[i].Matcher = r
 	}
 
+	// Build the set of cacheable status codes.
+	config.statusCodes = cacheableStatusCodes
+	if len(config.CacheableStatusCodes) > 0 {
+		config.statusCodes = make(map[int]struct{}, len(config.CacheableStatusCodes))
+		for _, code := range config.CacheableStatusCodes {
+			config.statusCodes[code] = struct{}{}
+		}
+	}
+
+	// Parse negative TTL status codes and classes.
+	for i, n := range config.NegativeTTLs {
+		from, to, err := parseStatusRange(n.Status)
+		if err != nil {
+			log.Error().Err(err).Str("status", n.Status).Msg("Invalid negative TTL status")
+		}
+		config.NegativeTTLs[i].from, config.NegativeTTLs[i].to = from, to
+	}
+
+	// Compile slice matchers.
+	for i, sl := range config.Slices {
+		r, err := regexp.Compile(sl.Path)
+		if err != nil {
+			log.Error().Err(err).Str("path", sl.Path).Msg("Invalid slice path regex")
+		}
+		config.Slices[i].Matcher = r
+	}
+
+	// Compile post matchers.
+	for i, po := range config.Post {
+		r, err := regexp.Compile(po.Path)
+		if err != nil {
+			log.Error().Err(err).Str("path", po.Path).Msg("Invalid post path regex")
+		}
+		config.Post[i].Matcher = r
+	}
+
+	// Compile private matchers.
+	for i, pr := range config.Private {
+		r, err := regexp.Compile(pr.Path)
+		if err != nil {
+			log.Error().Err(err).Str("path", pr.Path).Msg("Invalid private path regex")
+		}
+		config.Private[i].Matcher = r
+	}
+
+	// Compile key policy matchers.
+	for i, kp := range config.KeyPolicies {
+		r, err := regexp.Compile(kp.Path)
+		if err != nil {
+			log.Error().Err(err).Str("path", kp.Path).Msg("Invalid key policy path regex")
+		}
+		config.KeyPolicies[i].Matcher = r
+	}
+
+	// Compile rule conditions. Rules with invalid conditions never match.
+	for i := range config.Rules {
+		r := &config.Rules[i]
+		if err := r.Match.compile(); err != nil {
+			log.Error().Err(err).Str("rule", r.Name).Msg("Invalid cache rule")
+			r.invalid = true
+		}
+	}
+
 	// Compile cache exclude matchers.
 	if config.Exclude != nil {
 		config.Exclude.PathMatcher = make([]*regexp.Regexp, len(config.Exclude.Path))
@@ -187,6 +372,7 @@ func (c *HttpCache) UpdateConfig(config *HttpCacheConfig) {
 
 	// Safely update config.
 	c.config.Store(config)
+	c.offline.Store(config.Offline)
 }
 
 // Strict returns true if the cache mode is `strict`.
@@ -195,6 +381,16 @@ func (c *HttpCache) Strict() bool {
 	return config.Strict
 }
 
+// Offline returns true if the cache is offline, i.e. requests are not forwarded upstream.
+func (c *HttpCache) Offline() bool {
+	return c.offline.Load()
+}
+
+// SetOffline toggles the offline mode until the next config update.
+func (c *HttpCache) SetOffline(offline bool) {
+	c.offline.Store(offline)
+}
+
 // IsExcludedPath checks whether a specific path is excluded from caching.
 func (c *HttpCache) IsExcludedPath(p string) bool {
 	config := c.loadConfig()
@@ -209,6 +405,17 @@ func (c *HttpCache) IsExcludedPath(p string) bool {
 	return false
 }
 
+// IsPrivatePath checks whether responses of a specific path are cached per credential.
+func (c *HttpCache) IsPrivatePath(p string) bool {
+	config := c.loadConfig()
+	for _, pr := range config.Private {
+		if pr.Matcher != nil && pr.Matcher.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsExcludedHeader checks whether a specific HTTP header is excluded from caching.
 func (c *HttpCache) IsExcludedHeader(h http.Header) bool {
 	config := c.loadConfig()
@@ -282,6 +489,29 @@ func (c *HttpCache) DefaultTTL() time.Duration {
 	return t
 }
 
+// StaleIfError returns the configured stale-if-error grace period as a valid
+// duration. If not specified or invalid, no grace period (zero) is returned.
+func (c *HttpCache) StaleIfError() time.Duration {
+	config := c.loadConfig()
+	if len(config.StaleIfError) == 0 {
+		return 0
+	}
+	t, err := time.ParseDuration(config.StaleIfError)
+	if err != nil || t < 0 {
+		return 0
+	}
+	return t
+}
+
+// MaxBodySize returns the maximum size of a response body to be stored in the cache.
+func (c *HttpCache) MaxBodySize() int64 {
+	config := c.loadConfig()
+	if config.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return config.MaxBodySize
+}
+
 // PathTTL matches a path with the configured path regex. If a match
 // is found, the corresponding TTL is returned, otherwise DefaultTTL.
 func (c *HttpCache) PathTTL(p string) time.Duration {
@@ -294,42 +524,148 @@ func (c *HttpCache) PathTTL(p string) time.Duration {
 	return c.DefaultTTL()
 }
 
+// SliceSize matches a path with the configured slice path regex. If a match is
+// found, the corresponding slice size is returned, otherwise 0 (no slicing).
+func (c *HttpCache) SliceSize(p string) int64 {
+	config := c.loadConfig()
+	for _, sl := range config.Slices {
+		if sl.Matcher != nil && sl.Matcher.MatchString(p) {
+			if sl.Size <= 0 {
+				return DefaultSliceSize
+			}
+			return sl.Size
+		}
+	}
+	return 0
+}
+
 // FetchResponse fetches a response matching the given request.
 func (c *HttpCache) FetchResponse(ctx context.Context, lookup LookupRequest) *LookupResult {
-	cached := c.cache.Get(ctx, lookup.Key.String())
-	if cached == nil {
+	key := lookup.Key.String()
+	entry := c.fetchEntry(ctx, key)
+	if entry == nil {
 		return &LookupResult{}
 	}
+	if len(entry.Vary) > 0 {
+		// The stored response varies, select the variant matching the request.
+		key = lookup.Key.VaryKey(entry.Vary, lookup.Request.Header)
+		entry = c.fetchEntry(ctx, key)
+		if entry == nil {
+			return &LookupResult{}
+		}
+	}
+	if c.banned(ctx, &lookup, key, entry) {
+		return &LookupResult{}
+	}
+	lookup.heuristic = c.heuristic()
+	return lookup.readEntry(entry)
+}
+
+// FetchSlice fetches the slice with the given index of a sliced response matching the request.
+func (c *HttpCache) FetchSlice(ctx context.Context, lookup LookupRequest, index int64) *LookupResult {
+	key := lookup.Key.SliceKey(index)
+	entry := c.fetchEntry(ctx, key)
+	if entry == nil || c.banned(ctx, &lookup, key, entry) {
+		return &LookupResult{}
+	}
+	lookup.heuristic = c.heuristic()
+	return lookup.readEntry(entry)
+}
+
+// fetchEntry fetches and decodes the entry stored under the given key.
+// It returns nil if the entry does not exist or cannot be decoded.
+func (c *HttpCache) fetchEntry(ctx context.Context, key string) *Entry {
+	cached := c.cache.Get(ctx, key)
+	if cached == nil {
+		return nil
+	}
 	entry, err := DecodeEntry(cached)
 	if err != nil {
-		return &LookupResult{}
+		// Entries of an incompatible format version are skipped (cache miss).
+		log.Debug().Err(err).Str("cache-key", key).Msg("Error decoding cache entry")
+		return nil
+	}
+	return entry
+}
+
+// StoreResponse stores a response in the cache. If the response carries a Vary
+// header, the response is stored under a secondary key derived from the nominated
+// request headers and the primary key records the Vary header field names.
+func (c *HttpCache) StoreResponse(ctx context.Context, lookup *LookupRequest,
+	response *http.Response, responseTime time.Time) {
+	if VaryAll(response.Header) {
+		// Responses with 'Vary: *' must never be served from cache.
+		c.Delete(ctx, lookup)
+		return
 	}
-	res, err := http.ReadResponse(bufio.NewReader(bytes.NewBuffer(entry.Body)), lookup.Request)
+	entry, err := NewEntry(response, lookup.Timestamp, responseTime)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return &LookupResult{}
+		return
+	}
+
+	key := lookup.Key.String()
+	actions := c.ResponseRules(lookup.Request, response)
+	ttl := actions.ttl(c.PathTTL(lookup.Request.URL.Path))
+	negativeTTL, negative := c.NegativeTTL(response)
+	switch {
+	case actions.ForceCache:
+		entry.Forced = true
+	case negative:
+		if actions.TTL == 0 {
+			ttl = negativeTTL
+		}
+		entry.Negative = !hasValidationData(response.Header,
+			EdgeCacheControl(response.Header))
+	case c.Strict():
+		// Keep stale entries around to be served if the origin fails.
+		ttl += c.StaleIfError()
 	}
-	return lookup.makeResult(res, time.Unix(entry.Timestamp, 0))
+
+	tags := ParseTags(response.Header.Get(c.TagHeader()))
+	if vary := ParseVary(response.Header); len(vary) > 0 {
+		c.storeEntry(key, &Entry{Vary: vary, ResponseTime: responseTime}, ttl)
+		c.tag(ctx, key, tags, ttl)
+		key = lookup.Key.VaryKey(vary, lookup.Request.Header)
+		entry.VaryKey = key
+	}
+
+	c.storeEntry(key, entry, ttl)
+	c.tag(ctx, key, tags, ttl)
 }
 
-// StoreResponse stores a response in the cache.
-func (c *HttpCache) StoreResponse(_ context.Context, lookup *LookupRequest,
+// StoreSlice stores the slice with the given index of a sliced response in the cache.
+func (c *HttpCache) StoreSlice(ctx context.Context, lookup *LookupRequest, index int64,
 	response *http.Response, responseTime time.Time) {
-	resp, err := httputil.DumpResponse(response, true)
+	entry, err := NewEntry(response, lookup.Timestamp, responseTime)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return
 	}
-	entry := &Entry{
-		Body:      resp,
-		Timestamp: responseTime.Unix(),
-	}
+	actions := c.ResponseRules(lookup.Request, response)
+	entry.Forced = actions.ForceCache
+	key, ttl := lookup.Key.SliceKey(index), actions.ttl(c.PathTTL(lookup.Request.URL.Path))
+	c.storeEntry(key, entry, ttl)
+	c.tag(ctx, key, ParseTags(response.Header.Get(c.TagHeader())), ttl)
+}
+
+// storeEntry encodes and stores the entry under the given key for the given time-to-live.
+func (c *HttpCache) storeEntry(key string, entry *Entry, ttl time.Duration) {
+	entry.TTL = ttl
+	c.setEntry(key, entry, ttl)
+}
+
+// setEntry encodes and stores the entry under the given key for the given time-to-live,
+// keeping the TTL of the entry. Header fields which must not be stored are removed from
+// the entry.
+func (c *HttpCache) setEntry(key string, entry *Entry, ttl time.Duration) {
+	removeUnstorableFields(entry.Header)
 	enc, err := entry.Encode()
 	if err != nil {
 		log.Error().Err(err).Send()
 		return
 	}
-	c.cache.Set(lookup.Key.String(), enc, c.PathTTL(lookup.Request.URL.Path))
+	c.cache.Set(key, enc, ttl)
 }
 
 // Deletes deletes the response matching the request key from the cache.
@@ -337,6 +673,18 @@ func (c *HttpCache) Delete(ctx context.Context, lookup *LookupRequest) {
 	c.cache.Delete(ctx, lookup.Key.String())
 }
 
+// DeleteSlices deletes the first n slices of a sliced response from the cache.
+// It returns the keys of the deleted slices.
+func (c *HttpCache) DeleteSlices(ctx context.Context, lookup *LookupRequest, n int64) []string {
+	keys := make([]string, 0, n)
+	for i := int64(0); i < n; i++ {
+		key := lookup.Key.SliceKey(i)
+		c.cache.Delete(ctx, key)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 // LookupRequest holds the context for looking up a request.
 type LookupRequest struct {
 	// Request is the original request.
@@ -354,6 +702,12 @@ type LookupRequest struct {
 	// strict specifies whether the lookup should consider
 	// Cache-Control directives when validating the result.
 	strict bool
+
+	// Explanation, if set, records how the cache handles the request.
+	Explanation *Explanation
+
+	// heuristic holds the parameters of the heuristic freshness lifetime.
+	heuristic heuristic
 }
 
 // NewLookupRequest creates a new lookup request structure.
@@ -382,46 +736,96 @@ func NewLookupRequest(req *http.Request, timestamp time.Time, strict bool) *Look
 	}
 }
 
+// NewLookup creates the lookup request of the request, keyed according to the key policy
+// of the request path. On private paths, requests with a credential are looked up by a
+// key derived from the credential.
+func (c *HttpCache) NewLookup(req *http.Request, timestamp time.Time) *LookupRequest {
+	lookup := NewLookupRequest(req, timestamp, c.Strict())
+	lookup.Key = c.KeyFromRequest(req)
+	if c.IsPrivatePath(req.URL.Path) {
+		lookup.Key.Credential = CredentialHash(req)
+	}
+	return lookup
+}
+
+// readEntry reads the response of the cache entry and prepares the lookup result.
+// A negatively cached entry is fresh for its TTL. An entry forced into the cache by a
+// rule is served until it expires, regardless of any Cache-Control directives. An
+// invalidated (soft purged) entry always requires validation.
+func (l *LookupRequest) readEntry(entry *Entry) *LookupResult {
+	var result *LookupResult
+	switch {
+	case entry.Forced:
+		forced := *l
+		forced.strict = false
+		result = forced.evaluate(entry.Response(l.Request), entry.ResponseTime, entry.TTL)
+	case entry.Negative:
+		result = l.evaluate(entry.Response(l.Request), entry.ResponseTime, entry.TTL)
+	default:
+		result = l.makeResult(entry.Response(l.Request), entry.ResponseTime)
+	}
+	if entry.Invalidated {
+		result.Status = EntryRequiresValidation
+	}
+	return result
+}
+
 // MakeResult prepares and creates the cache result. Specifically, it sets the cache entry status
 // according to the HTTP caching validation logic, takes care of response headers, parts, and ranges.
+// A response without explicit expiration time is fresh for its heuristic freshness lifetime.
 // TODO: incomplete implementation.
 func (l *LookupRequest) makeResult(res *http.Response, resTime time.Time) *LookupResult {
+	resCacheControl := EdgeCacheControl(res.Header)
+	if hasExplicitExpiration(res.Header, resCacheControl) || !l.heuristic.applies(res.Header) {
+		return l.evaluate(res, resTime, freshnessLifetime(&res.Header, resCacheControl))
+	}
+
+	result := l.evaluate(res, resTime, l.heuristic.lifetime(res.Header, resTime))
+	result.heuristic = true
+	if l.strict && result.Status != EntryRequiresValidation && result.age > 24*time.Hour {
+		res.Header.Add(HeaderWarning, WarningHeuristicExpiration)
+	}
+	return result
+}
+
+// evaluate creates the cache result of the response with the given freshness lifetime.
+func (l *LookupRequest) evaluate(res *http.Response, resTime time.Time, lifetime time.Duration) *LookupResult {
 	age := CalculateAge(&res.Header, resTime, l.Timestamp)
 	res.Header.Set(HeaderAge, fmt.Sprintf("%.0f", age.Seconds()))
 
 	var status EntryStatus
-	if l.strict && l.requiresValidation(&res.Header, age) {
-		status = EntryRequiresValidation
-	} else {
+	switch {
+	case !l.strict || !l.requiresValidation(&res.Header, age, lifetime):
 		status = EntryOk
+	case l.allowsStaleWhileRevalidate(&res.Header, age, lifetime):
+		status = EntryStaleWhileRevalidate
+	default:
+		status = EntryRequiresValidation
 	}
 
 	return &LookupResult{
 		cachedResponse: res,
 		Status:         status,
+		age:            age,
+		lifetime:       lifetime,
 	}
 }
 
-// requiresValidation checks if the cached response needs to be validated by the origin.
-func (l *LookupRequest) requiresValidation(header *http.Header, age time.Duration) bool {
-	resCacheControl := ParseResponseCacheControl(header.Get(HeaderCacheControl))
+// requiresValidation checks if the cached response with the given
+// freshness lifetime needs to be validated by the origin.
+func (l *LookupRequest) requiresValidation(header *http.Header, age, freshness time.Duration) bool {
+	resCacheControl := EdgeCacheControl(*header)
 	reqCacheControl := l.ReqCacheControl
 
 	maxAgeExceeded := reqCacheControl.MaxAge >= 0 && reqCacheControl.MaxAge < age
-	if resCacheControl.MustValidate || reqCacheControl.MustValidate || maxAgeExceeded {
-		return true
+	reload := reqCacheControl.MustValidate || maxAgeExceeded
+	if resCacheControl.Immutable && age <= freshness {
+		// A fresh immutable response is not validated on reloads.
+		// https://httpwg.org/specs/rfc8246.html#the-immutable-cache-control-extension
+		reload = false
 	}
-
-	// Valid expiration data is ensured by `IsCachableResponse(..)`.
-
-	// Calculate freshness lifetime.
-	var freshness time.Duration
-	if resCacheControl.MaxAge >= 0 {
-		freshness = resCacheControl.MaxAge
-	} else {
-		expires := parseHttpTime(header.Get(HeaderExpires))
-		date := parseHttpTime(header.Get(HeaderDate))
-		freshness = expires.Sub(date)
+	if resCacheControl.MustValidate || reload {
+		return true
 	}
 
 	if age > freshness { // Stale response.
@@ -437,6 +841,56 @@ func (l *LookupRequest) requiresValidation(header *http.Header, age time.Duratio
 	return reqCacheControl.MinFresh >= 0 && reqCacheControl.MinFresh > freshness-age
 }
 
+// allowsStaleWhileRevalidate checks if the stale cached response can be served while
+// it is revalidated in the background, i.e. the response is stale for no longer than
+// the 'stale-while-revalidate' window and neither the request nor the response
+// requires a synchronous validation.
+// https://httpwg.org/specs/rfc5861.html#stale-while-revalidate
+func (l *LookupRequest) allowsStaleWhileRevalidate(header *http.Header, age, freshness time.Duration) bool {
+	resCacheControl := EdgeCacheControl(*header)
+	reqCacheControl := l.ReqCacheControl
+
+	if resCacheControl.StaleWhileRevalidate < 0 || resCacheControl.MustValidate ||
+		resCacheControl.NoStale || reqCacheControl.MustValidate || reqCacheControl.MinFresh >= 0 ||
+		(reqCacheControl.MaxAge >= 0 && reqCacheControl.MaxAge < age) {
+		return false
+	}
+
+	staleness := age - freshness
+	return staleness > 0 && staleness <= resCacheControl.StaleWhileRevalidate
+}
+
+// AllowsStaleIfError checks if the stale cached response can be served in case the origin
+// fails to respond, i.e. the response is stale for no longer than the 'stale-if-error'
+// window of either the request or the response, or the configured grace period.
+// https://httpwg.org/specs/rfc5861.html#stale-if-error
+func (l *LookupRequest) AllowsStaleIfError(result *LookupResult, grace time.Duration) bool {
+	if result == nil || result.cachedResponse == nil {
+		return false
+	}
+	header := &result.cachedResponse.Header
+	resCacheControl := EdgeCacheControl(*header)
+	if resCacheControl.NoStale {
+		// must-revalidate and proxy-revalidate prohibit serving stale responses.
+		return false
+	}
+
+	window := max(grace, resCacheControl.StaleIfError, l.ReqCacheControl.StaleIfError)
+	staleness := result.age - result.lifetime
+	return staleness <= window
+}
+
+// freshnessLifetime calculates the freshness lifetime of a response.
+// https://httpwg.org/specs/rfc9111.html#calculating.freshness.lifetime
+func freshnessLifetime(header *http.Header, resCacheControl ResponseCacheControl) time.Duration {
+	if resCacheControl.MaxAge >= 0 {
+		return resCacheControl.MaxAge
+	}
+	expires := parseHttpTime(header.Get(HeaderExpires))
+	date := parseHttpTime(header.Get(HeaderDate))
+	return expires.Sub(date)
+}
+
 // LookupResult wraps the cached response.
 type LookupResult struct {
 	// Status holds the status of the cached entry.
@@ -444,6 +898,21 @@ type LookupResult struct {
 
 	// cachedResponse is the response fetched from the cache.
 	cachedResponse *http.Response
+
+	// age is the current age of the cached response.
+	age time.Duration
+
+	// lifetime is the freshness lifetime of the cached response.
+	lifetime time.Duration
+
+	// heuristic indicates that the lifetime is a heuristic freshness lifetime.
+	heuristic bool
+}
+
+// TTL returns the remaining freshness lifetime of the cached response,
+// which is negative if the response is stale.
+func (r *LookupResult) TTL() time.Duration {
+	return r.lifetime - r.age
 }
 
 // Header returns the cached response header.
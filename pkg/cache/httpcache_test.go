@@ -23,10 +23,14 @@
 package cache
 
 import (
+	"context"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/kacheio/kache/pkg/provider"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -185,6 +189,42 @@ func TestLookupRequest(t *testing.T) {
 			EntryOk,
 			"999",
 		},
+		{
+			"Expired but stale while revalidate satisfied",
+			"",
+			"public, max-age=1000, stale-while-revalidate=100",
+			currentTime().Add(seconds(1099)),
+			currentTime(),
+			EntryStaleWhileRevalidate,
+			"1099",
+		},
+		{
+			"Expired and stale while revalidate unsatisfied",
+			"",
+			"public, max-age=1000, stale-while-revalidate=100",
+			currentTime().Add(seconds(1101)),
+			currentTime(),
+			EntryRequiresValidation,
+			"1101",
+		},
+		{
+			"Expired and stale while revalidate satisfied but response must revalidate",
+			"",
+			"public, max-age=1000, stale-while-revalidate=100, must-revalidate",
+			currentTime().Add(seconds(1099)),
+			currentTime(),
+			EntryRequiresValidation,
+			"1099",
+		},
+		{
+			"Expired and stale while revalidate satisfied but request requires revalidation",
+			"no-cache",
+			"public, max-age=1000, stale-while-revalidate=100",
+			currentTime().Add(seconds(1099)),
+			currentTime(),
+			EntryRequiresValidation,
+			"1099",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -344,6 +384,52 @@ func TestHttpCacheCustomTTL(t *testing.T) {
 	assert.Equal(t, time.Duration(3600*time.Second), c.PathTTL("/no-match"))
 }
 
+func TestHttpCacheSliceSize(t *testing.T) {
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Slices: []Slice{
+			{Path: "^/videos/", Size: 4 << 20},
+			{Path: `\.iso$`},
+		},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4<<20), c.SliceSize("/videos/movie.mp4"))
+	assert.Equal(t, DefaultSliceSize, c.SliceSize("/downloads/image.iso"))
+	assert.Equal(t, int64(0), c.SliceSize("/index.html"))
+}
+
+func TestStoreFetchSlice(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{DefaultTTL: "3600s"}, p)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://example.com/videos/movie.mp4", nil)
+	lookup := NewLookupRequest(req, currentTime(), true)
+
+	res := &http.Response{
+		StatusCode: http.StatusPartialContent,
+		ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=3600"},
+			"Content-Range": []string{"bytes 4-7/10"},
+			"Date":          []string{formatTime(currentTime())},
+		},
+		ContentLength: 4,
+		Body:          io.NopCloser(strings.NewReader("4567")),
+	}
+	c.StoreSlice(context.Background(), lookup, 1, res, currentTime())
+
+	// Slices are not served as the complete response.
+	assert.Equal(t, EntryInvalid, c.FetchResponse(context.Background(), *lookup).Status)
+	assert.Equal(t, EntryInvalid, c.FetchSlice(context.Background(), *lookup, 0).Status)
+
+	result := c.FetchSlice(context.Background(), *lookup, 1)
+	require.Equal(t, EntryOk, result.Status)
+	body, err := io.ReadAll(result.Response().Body)
+	require.NoError(t, err)
+	assert.Equal(t, "4567", string(body))
+	assert.Equal(t, "bytes 4-7/10", result.Response().Header.Get("Content-Range"))
+}
+
 func TestExcludePath(t *testing.T) {
 	c, err := NewHttpCache(&HttpCacheConfig{
 		Exclude: &Exclude{
@@ -412,3 +498,160 @@ func TestIsExcludedContent(t *testing.T) {
 
 	assert.Equal(t, true, c.IsExcludedContent("application/vnd.mozilla.xul+xml", 2024))
 }
+
+func TestStoreFetchVary(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(nil, p)
+	require.NoError(t, err)
+
+	newRequest := func(encoding string) *LookupRequest {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com/vary", nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		return NewLookupRequest(req, currentTime(), true)
+	}
+	newResponse := func(body string, vary string) *http.Response {
+		res := &http.Response{
+			StatusCode:    http.StatusOK,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        make(http.Header),
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}
+		res.Header.Set(HeaderCacheControl, "max-age=3600")
+		res.Header.Set(HeaderDate, currentTime().Format(http.TimeFormat))
+		res.Header.Set(HeaderVary, vary)
+		return res
+	}
+	fetchBody := func(lookup *LookupRequest) string {
+		result := c.FetchResponse(context.Background(), *lookup)
+		if result.Status == EntryInvalid {
+			return ""
+		}
+		body, err := io.ReadAll(result.Response().Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	gzip, plain := newRequest("gzip"), newRequest("")
+	c.StoreResponse(context.Background(), gzip, newResponse("gzipped", "Accept-Encoding"), currentTime())
+
+	// Only the variant matching the nominated request headers is served.
+	assert.Equal(t, "gzipped", fetchBody(newRequest("gzip")))
+	assert.Equal(t, "", fetchBody(plain))
+
+	c.StoreResponse(context.Background(), plain, newResponse("plain", "Accept-Encoding"), currentTime())
+	assert.Equal(t, "gzipped", fetchBody(newRequest("gzip")))
+	assert.Equal(t, "plain", fetchBody(newRequest("")))
+
+	// Vary: * is never stored and invalidates existing variants.
+	c.StoreResponse(context.Background(), gzip, newResponse("any", "*"), currentTime())
+	assert.Equal(t, "", fetchBody(newRequest("gzip")))
+	assert.Equal(t, "", fetchBody(newRequest("")))
+}
+
+func TestAllowsStaleIfError(t *testing.T) {
+	testCases := []struct {
+		name            string
+		reqCacheControl string
+		resCacheControl string
+		grace           time.Duration
+		age             time.Duration
+		want            bool
+	}{
+		{"Fresh", "", "max-age=100", 0, seconds(50), true},
+		{"Stale without stale-if-error", "", "max-age=100", 0, seconds(101), false},
+		{"Response stale-if-error satisfied", "", "max-age=100, stale-if-error=60", 0, seconds(159), true},
+		{"Response stale-if-error unsatisfied", "", "max-age=100, stale-if-error=60", 0, seconds(161), false},
+		{"Request stale-if-error satisfied", "stale-if-error=60", "max-age=100", 0, seconds(159), true},
+		{"Grace period satisfied", "", "max-age=100", seconds(60), seconds(159), true},
+		{"Grace period unsatisfied", "", "max-age=100", seconds(60), seconds(161), false},
+		{"Must revalidate", "", "max-age=100, stale-if-error=60, must-revalidate", seconds(60), seconds(120), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Add(HeaderCacheControl, tc.reqCacheControl)
+
+			lookup := NewLookupRequest(req, currentTime().Add(tc.age), true)
+
+			res := &http.Response{Request: req, Header: make(http.Header, 0)}
+			res.Header.Add(HeaderCacheControl, tc.resCacheControl)
+			res.Header.Add(HeaderDate, currentTime().Format(http.TimeFormat))
+
+			result := lookup.makeResult(res, currentTime())
+
+			assert.Equal(t, tc.want, lookup.AllowsStaleIfError(result, tc.grace))
+		})
+	}
+}
+
+func TestHttpCacheStaleIfError(t *testing.T) {
+	c, err := NewHttpCache(&HttpCacheConfig{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), c.StaleIfError())
+
+	c.UpdateConfig(&HttpCacheConfig{StaleIfError: "300s"})
+	assert.Equal(t, seconds(300), c.StaleIfError())
+
+	c.UpdateConfig(&HttpCacheConfig{StaleIfError: "invalid"})
+	assert.Equal(t, time.Duration(0), c.StaleIfError())
+}
+
+func TestStoreUnstorableFields(t *testing.T) {
+	ctx := context.Background()
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(nil, p)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://example.com/profile", nil)
+	lookup := NewLookupRequest(req, currentTime(), true)
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{`max-age=60, private="X-User", no-cache="Set-Cookie"`},
+			"Date":          []string{formatTime(currentTime())},
+			"Set-Cookie":    []string{"session=42"},
+			"X-User":        []string{"alice"},
+			"X-Shared":      []string{"shared"},
+		},
+		Body: io.NopCloser(strings.NewReader("body")),
+	}
+	c.StoreResponse(ctx, lookup, res, currentTime())
+
+	// The fields are not stored, but kept in the response.
+	assert.Equal(t, "alice", res.Header.Get("X-User"))
+	result := c.FetchResponse(ctx, *lookup)
+	require.Equal(t, EntryOk, result.Status)
+	assert.Empty(t, result.Header().Get("Set-Cookie"))
+	assert.Empty(t, result.Header().Get("X-User"))
+	assert.Equal(t, "shared", result.Header().Get("X-Shared"))
+}
+
+func TestImmutableReload(t *testing.T) {
+	for _, tc := range []struct {
+		resCacheControl string
+		age             time.Duration
+		want            EntryStatus
+	}{
+		{"max-age=3600", time.Minute, EntryRequiresValidation},
+		{"max-age=3600, immutable", time.Minute, EntryOk},
+		{"max-age=3600, immutable", 2 * time.Hour, EntryRequiresValidation},
+		{"max-age=3600, immutable, no-cache", time.Minute, EntryRequiresValidation},
+	} {
+		for _, reload := range []string{"no-cache", "max-age=0"} {
+			req, _ := http.NewRequest("GET", "http://example.com/app.js", nil)
+			req.Header.Set(HeaderCacheControl, reload)
+			lookup := NewLookupRequest(req, currentTime(), true)
+			res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+				"Cache-Control": []string{tc.resCacheControl},
+				"Date":          []string{formatTime(currentTime().Add(-tc.age))},
+			}}
+			result := lookup.makeResult(res, currentTime())
+			assert.Equal(t, tc.want, result.Status, "%s, %s, %s", tc.resCacheControl, tc.age, reload)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// IsUnsafeMethod returns true if the request method is unsafe, i.e. the request
+// is expected to change the state of the origin server.
+// https://httpwg.org/specs/rfc9110.html#safe.methods
+func IsUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// Invalidate invalidates the stored responses of the target URI of an unsafe request, as
+// well as of the URIs in the Location and Content-Location header fields of the response,
+// if they have the same host as the target URI. Responses are only invalidated if the
+// response status code indicates success (2xx or 3xx). Responses stored under keys
+// derived from the invalidated keys are invalidated as well, see deleteDerived. It
+// returns the invalidated keys.
+// https://httpwg.org/specs/rfc9111.html#invalidation
+func (c *HttpCache) Invalidate(ctx context.Context, req *http.Request, res *http.Response) []string {
+	if !IsUnsafeMethod(req.Method) || res == nil || res.StatusCode < 200 || res.StatusCode > 399 {
+		return nil
+	}
+
+	keys := []string{c.KeyFromRequest(req).String()}
+	for _, h := range []string{HeaderLocation, HeaderContentLocation} {
+		if key := c.locationKey(req, res.Header.Get(h)); key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	invalidated := keys
+	for _, key := range keys {
+		invalidated = appendUnique(invalidated, c.deleteDerived(ctx, key)...)
+	}
+	return invalidated
+}
+
+// deleteDerived deletes the response stored under the key, as well as the responses
+// stored under all keys derived from the same primary key: variants, slices, and the
+// responses stored per key policy fields, request body or credential, see primaryTag.
+// It returns the deleted derived keys.
+func (c *HttpCache) deleteDerived(ctx context.Context, key string) []string {
+	c.cache.Delete(ctx, key)
+
+	purged, err := c.cache.PurgeTag(ctx, primaryTag(key))
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Error deleting derived keys")
+	}
+	var derived []string
+	for _, k := range purged {
+		if k != key {
+			derived = append(derived, k)
+		}
+	}
+	return derived
+}
+
+// appendUnique appends the keys not yet contained in the slice.
+func appendUnique(keys []string, add ...string) []string {
+	for _, key := range add {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// InvalidateByOrigin invalidates the stored responses listed by the origin in the
+// invalidation headers of the response, see HttpCacheConfig.InvalidateHeader and
+// HttpCacheConfig.InvalidateTagsHeader. The headers are removed from the response.
+// Listed URLs are resolved against the target URI; URLs of other hosts are ignored.
+// Responses stored under keys derived from the listed URLs are invalidated as well,
+// see deleteDerived. It returns the invalidated keys.
+func (c *HttpCache) InvalidateByOrigin(ctx context.Context, req *http.Request, res *http.Response) []string {
+	config := c.loadConfig()
+
+	var keys []string
+	if h := config.InvalidateHeader; h != "" && res.Header.Get(h) != "" {
+		for _, v := range res.Header.Values(h) {
+			for _, ref := range strings.Split(v, ",") {
+				key := c.locationKey(req, strings.TrimSpace(ref))
+				if key != "" && !slices.Contains(keys, key) {
+					keys = append(keys, key)
+					keys = appendUnique(keys, c.deleteDerived(ctx, key)...)
+				}
+			}
+		}
+		res.Header.Del(h)
+	}
+
+	if h := config.InvalidateTagsHeader; h != "" && res.Header.Get(h) != "" {
+		tags := ParseTags(strings.Join(res.Header.Values(h), " "))
+		purged, err := c.PurgeTags(ctx, tags)
+		if err != nil {
+			log.Error().Err(err).Strs("tags", tags).Msg("Error purging tags")
+		}
+		keys = append(keys, purged...)
+		res.Header.Del(h)
+	}
+
+	return keys
+}
+
+// locationKey returns the cache key of the URI reference in a Location or Content-Location
+// header field, resolved against the target URI of the request. It returns an empty string
+// if the URI reference is invalid or refers to another host than the target URI. The key
+// is the key of a plain GET request for the resolved URI, composed according to its key
+// policy, i.e. the request body and credential are not part of the key.
+func (c *HttpCache) locationKey(req *http.Request, value string) string {
+	if value == "" {
+		return ""
+	}
+	ref, err := url.Parse(value)
+	if err != nil || (ref.Host != "" && !strings.EqualFold(ref.Host, req.Host)) {
+		return ""
+	}
+	u := req.URL.ResolveReference(ref)
+	u.Scheme, u.Host = req.URL.Scheme, req.URL.Host
+	get := &http.Request{Method: http.MethodGet, Host: req.Host, URL: u, Header: http.Header{}, TLS: req.TLS}
+	return c.KeyFromRequest(get).String()
+}
@@ -0,0 +1,253 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidate(t *testing.T) {
+	tests := []struct {
+		method  string
+		url     string
+		status  int
+		header  http.Header
+		keys    []string
+		remains []string
+	}{
+		{
+			method: http.MethodPost, url: "http://example.com/news?id=1", status: http.StatusOK,
+			keys: []string{"kache-http://example.com/news?id=1"},
+		},
+		{
+			method: http.MethodPut, url: "http://example.com/news", status: http.StatusCreated,
+			header: http.Header{"Location": []string{"/news/1"}, "Content-Location": []string{"1?v=2"}},
+			keys:   []string{"kache-http://example.com/news", "kache-http://example.com/news/1", "kache-http://example.com/1?v=2"},
+		},
+		{
+			method: http.MethodPatch, url: "http://example.com/news/1", status: http.StatusSeeOther,
+			header: http.Header{"Location": []string{"http://example.com/news/1"}},
+			keys:   []string{"kache-http://example.com/news/1"},
+		},
+		{
+			// Locations on other hosts are not invalidated.
+			method: http.MethodDelete, url: "http://example.com/news/1", status: http.StatusNoContent,
+			header:  http.Header{"Location": []string{"http://example.org/news"}},
+			keys:    []string{"kache-http://example.com/news/1"},
+			remains: []string{"kache-http://example.org/news"},
+		},
+		{
+			// Error responses do not invalidate.
+			method: http.MethodPost, url: "http://example.com/news", status: http.StatusBadRequest,
+			remains: []string{"kache-http://example.com/news"},
+		},
+		{
+			// Safe methods do not invalidate.
+			method: http.MethodGet, url: "http://example.com/news", status: http.StatusOK,
+			remains: []string{"kache-http://example.com/news"},
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		p, _ := provider.NewSimpleCache(nil)
+		c, err := NewHttpCache(nil, p)
+		require.NoError(t, err)
+		for _, key := range append(tt.keys, tt.remains...) {
+			p.Set(key, []byte("entry"), time.Minute)
+		}
+
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		res := &http.Response{StatusCode: tt.status, Header: tt.header}
+		keys := c.Invalidate(ctx, req, res)
+		assert.Equal(t, tt.keys, keys, "%s %s", tt.method, tt.url)
+		for _, key := range tt.keys {
+			assert.Nil(t, p.Get(ctx, key), key)
+		}
+		for _, key := range tt.remains {
+			assert.NotNil(t, p.Get(ctx, key), key)
+		}
+	}
+}
+
+// unscannable is a provider failing the test if the keyspace is enumerated.
+type unscannable struct {
+	provider.Provider
+	t *testing.T
+}
+
+func (p *unscannable) Keys(_ context.Context, prefix string) []string {
+	p.t.Fatalf("unexpected keyspace enumeration: %q", prefix)
+	return nil
+}
+
+func TestInvalidateDerivedKeys(t *testing.T) {
+	ctx := context.Background()
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Private: []Private{{Path: "^/account"}},
+		Slices:  []Slice{{Path: "^/videos/"}},
+	}, &unscannable{Provider: p, t: t})
+	require.NoError(t, err)
+
+	for _, key := range []string{
+		"kache-http://example.com/account",
+		"kache-http://example.com/account#credential-alice",
+		"kache-http://example.com/account#credential-bob",
+		"kache-http://example.com/account/settings#credential-alice",
+		"kache-http://example.com/videos/1#slice-0",
+		"kache-http://example.com/videos/1#slice-1",
+		"kache-http://example.com/videos/1?t=2#slice-0",
+	} {
+		p.Set(key, []byte("entry"), time.Minute)
+		c.tag(ctx, key, nil, time.Minute)
+	}
+
+	// Store a variant of a negotiated response.
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/news", nil)
+	req.Header.Set("Accept-Language", "en")
+	lookup := c.NewLookup(req, time.Now())
+	c.StoreResponse(ctx, lookup, &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		"Cache-Control": []string{"max-age=60"},
+		"Vary":          []string{"Accept-Language"},
+	}, Body: io.NopCloser(strings.NewReader("news"))}, time.Now())
+	variant := lookup.Key.VaryKey([]string{"Accept-Language"}, req.Header)
+	require.NotNil(t, p.Get(ctx, variant))
+
+	// The responses of all credentials of a private path are invalidated.
+	req, _ = http.NewRequest(http.MethodPost, "http://example.com/account", nil)
+	req.Header.Set("Authorization", "Bearer alice")
+	keys := c.Invalidate(ctx, req, &http.Response{StatusCode: http.StatusOK})
+	assert.Contains(t, keys, "kache-http://example.com/account#credential-bob")
+	assert.Nil(t, p.Get(ctx, "kache-http://example.com/account"))
+	assert.Nil(t, p.Get(ctx, "kache-http://example.com/account#credential-alice"))
+	assert.Nil(t, p.Get(ctx, "kache-http://example.com/account#credential-bob"))
+	assert.NotNil(t, p.Get(ctx, "kache-http://example.com/account/settings#credential-alice"))
+
+	// All slices of a sliced response are invalidated.
+	req, _ = http.NewRequest(http.MethodPut, "http://example.com/videos/1", nil)
+	keys = c.Invalidate(ctx, req, &http.Response{StatusCode: http.StatusNoContent})
+	assert.ElementsMatch(t, []string{
+		"kache-http://example.com/videos/1",
+		"kache-http://example.com/videos/1#slice-0",
+		"kache-http://example.com/videos/1#slice-1",
+	}, keys)
+	assert.NotNil(t, p.Get(ctx, "kache-http://example.com/videos/1?t=2#slice-0"))
+
+	// All variants are invalidated.
+	req, _ = http.NewRequest(http.MethodDelete, "http://example.com/news", nil)
+	keys = c.Invalidate(ctx, req, &http.Response{StatusCode: http.StatusOK})
+	assert.Equal(t, []string{"kache-http://example.com/news", variant}, keys)
+	assert.Nil(t, p.Get(ctx, "kache-http://example.com/news"))
+	assert.Nil(t, p.Get(ctx, variant))
+}
+
+func TestInvalidateLocationKey(t *testing.T) {
+	ctx := context.Background()
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Post:    []Post{{Path: "^/search"}},
+		Private: []Private{{Path: "^/search"}},
+	}, p)
+	require.NoError(t, err)
+
+	p.Set("kache-http://example.com/results/1", []byte("entry"), time.Minute)
+
+	// The body and credential of the request are not part of the location key.
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/search", strings.NewReader(`{"q":"a"}`))
+	req.Header.Set("Authorization", "Bearer alice")
+	req, err = c.ReadBody(req)
+	require.NoError(t, err)
+	require.NotEmpty(t, BodyHash(req))
+
+	keys := c.Invalidate(ctx, req, &http.Response{StatusCode: http.StatusCreated,
+		Header: http.Header{"Location": []string{"/results/1"}}})
+	assert.Contains(t, keys, "kache-http://example.com/results/1")
+	assert.Nil(t, p.Get(ctx, "kache-http://example.com/results/1"))
+}
+
+func TestInvalidateByOrigin(t *testing.T) {
+	ctx := context.Background()
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		InvalidateHeader:     "X-Kache-Invalidate",
+		InvalidateTagsHeader: "X-Kache-Invalidate-Tags",
+	}, &unscannable{Provider: p, t: t})
+	require.NoError(t, err)
+
+	for _, key := range []string{
+		"kache-http://example.com/articles/42",
+		"kache-http://example.com/articles/42#slice-0",
+		"kache-http://example.com/home",
+		"kache-http://example.com/home#credential-alice",
+		"kache-http://example.com/tagged",
+		"kache-http://example.org/home",
+		"kache-http://example.com/other",
+	} {
+		p.Set(key, []byte("entry"), time.Minute)
+		c.tag(ctx, key, nil, time.Minute)
+	}
+	p.Tag(ctx, "kache-http://example.com/tagged", []string{"article-42"}, time.Minute)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/articles", nil)
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		"X-Kache-Invalidate":      []string{"/articles/42, /home", "http://example.org/home"},
+		"X-Kache-Invalidate-Tags": []string{"article-42"},
+		"Cache-Control":           []string{"max-age=60"},
+	}}
+
+	keys := c.InvalidateByOrigin(ctx, req, res)
+	assert.Equal(t, []string{
+		"kache-http://example.com/articles/42",
+		"kache-http://example.com/articles/42#slice-0",
+		"kache-http://example.com/home",
+		"kache-http://example.com/home#credential-alice",
+		"kache-http://example.com/tagged",
+	}, keys)
+	for _, key := range keys {
+		assert.Nil(t, p.Get(ctx, key), key)
+	}
+
+	// URLs of other hosts and unlisted responses are kept.
+	assert.NotNil(t, p.Get(ctx, "kache-http://example.org/home"))
+	assert.NotNil(t, p.Get(ctx, "kache-http://example.com/other"))
+
+	// Invalidation headers are removed from the response.
+	assert.Equal(t, http.Header{"Cache-Control": []string{"max-age=60"}}, res.Header)
+
+	// Invalidation headers are ignored if not configured.
+	c.UpdateConfig(&HttpCacheConfig{})
+	res.Header.Set("X-Kache-Invalidate", "/other")
+	assert.Empty(t, c.InvalidateByOrigin(ctx, req, res))
+	assert.NotNil(t, p.Get(ctx, "kache-http://example.com/other"))
+	assert.Equal(t, "/other", res.Header.Get("X-Kache-Invalidate"))
+}
@@ -23,10 +23,15 @@
 package cache
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"path"
+	"sort"
+	"strings"
 
 	xxhash "github.com/cespare/xxhash/v2"
 )
@@ -38,6 +43,18 @@ type Key struct {
 	Path        string
 	Query       string
 	Scheme      string
+
+	// Fields is the hash of the request header fields and cookies folded
+	// into the key by a key policy, see HttpCacheConfig.KeyPolicies.
+	Fields string
+
+	// Body is the hash of the body of a POST request, if POST requests
+	// are cached by their body, see HttpCacheConfig.Post.
+	Body string
+
+	// Credential is the hash of the credential of the request, if responses
+	// are cached per credential, see HttpCacheConfig.Private.
+	Credential string
 }
 
 // NewFromRequest creates a cache key from the given request.
@@ -49,6 +66,7 @@ func NewKeyFromRequst(req *http.Request) *Key {
 		Path:        cleanPath(req.URL.Path),
 		Query:       req.URL.Query().Encode(),
 		Scheme:      req.URL.Scheme,
+		Body:        BodyHash(req),
 	}
 	if key.Scheme == "" {
 		if req.TLS == nil {
@@ -68,7 +86,29 @@ func (k Key) String() string {
 		Path:     k.Path,
 		RawQuery: k.Query,
 	}
-	return fmt.Sprintf("%s%s", k.ClusterName, url.String())
+	key := fmt.Sprintf("%s%s", k.ClusterName, url.String())
+	if k.Fields != "" {
+		key = fmt.Sprintf("%s#fields-%s", key, k.Fields)
+	}
+	if k.Body != "" {
+		key = fmt.Sprintf("%s#body-%s", key, k.Body)
+	}
+	if k.Credential != "" {
+		key = fmt.Sprintf("%s#credential-%s", key, k.Credential)
+	}
+	return key
+}
+
+// CredentialHash returns the hash of the Authorization header of the request,
+// or an empty string, if the request carries no credential. The credential
+// itself never becomes part of a cache key.
+func CredentialHash(req *http.Request) string {
+	credential := req.Header.Get(HeaderAuthorization)
+	if credential == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:16])
 }
 
 // Hash produces a stable hash of key.
@@ -84,6 +124,92 @@ func StableHashKey(k Key) uint64 {
 	return xxhash.Sum64([]byte(k.String()))
 }
 
+// VaryKey returns the secondary cache key of the response variant selected by the
+// request header fields nominated in vary. The variant key is derived from the
+// primary key so that prefix and pattern based purges also cover all variants.
+// https://httpwg.org/specs/rfc9111.html#caching.negotiated.responses
+func (k Key) VaryKey(vary []string, header http.Header) string {
+	var b strings.Builder
+	for _, name := range vary {
+		_, _ = b.WriteString(name)
+		_ = b.WriteByte('=')
+		_, _ = b.WriteString(normalizeHeaderValue(header.Values(name)))
+		_ = b.WriteByte('\n')
+	}
+	return fmt.Sprintf("%s#vary-%016x", k.String(), xxhash.Sum64String(b.String()))
+}
+
+// SliceKey returns the cache key of the slice with the given index of a sliced response.
+// The slice key is derived from the primary key so that prefix and pattern based purges
+// also cover all slices.
+func (k Key) SliceKey(index int64) string {
+	return fmt.Sprintf("%s#slice-%d", k.String(), index)
+}
+
+// ParseVary returns the canonical, sorted and de-duplicated list of header
+// field names nominated by the Vary header of the given response header.
+func ParseVary(header http.Header) []string {
+	seen := make(map[string]struct{})
+	var vary []string
+	for _, v := range header.Values(HeaderVary) {
+		for _, name := range strings.Split(v, ",") {
+			name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			vary = append(vary, name)
+		}
+	}
+	sort.Strings(vary)
+	return vary
+}
+
+// VaryAll returns true if the Vary header contains the "*" member,
+// indicating that the response varies on aspects beyond the request
+// header fields and must never be served from cache.
+func VaryAll(header http.Header) bool {
+	for _, name := range ParseVary(header) {
+		if name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// VaryMatches checks whether the header fields nominated in vary match
+// in both request headers a and b, i.e. both requests select the same
+// response variant.
+func VaryMatches(vary []string, a, b http.Header) bool {
+	for _, name := range vary {
+		if name == "*" {
+			return false
+		}
+		if normalizeHeaderValue(a.Values(name)) != normalizeHeaderValue(b.Values(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeHeaderValue combines multiple header field lines into a single
+// comma-separated value and removes any optional whitespace around members.
+// https://httpwg.org/specs/rfc9111.html#caching.negotiated.responses
+func normalizeHeaderValue(values []string) string {
+	var members []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				members = append(members, m)
+			}
+		}
+	}
+	return strings.Join(members, ",")
+}
+
 // cleanPath returns the canonical path for p.
 func cleanPath(p string) string {
 	if p == "" {
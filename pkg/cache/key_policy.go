@@ -0,0 +1,189 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	xxhash "github.com/cespare/xxhash/v2"
+)
+
+// KeyPolicy holds the cache key composition of requests matching the path. Query
+// parameters are matched by name or by a shell pattern, e.g. 'utm_*'.
+type KeyPolicy struct {
+	// Path is the path the policy is applied to. String or Regex.
+	Path string `yaml:"path" json:"path"`
+
+	// IncludeQuery holds the query parameters included in the key.
+	// If empty, all query parameters are included.
+	IncludeQuery []string `yaml:"include_query" json:"include_query"`
+
+	// IgnoreQuery holds the query parameters ignored by the key.
+	IgnoreQuery []string `yaml:"ignore_query" json:"ignore_query"`
+
+	// SortQuery specifies whether query parameters are sorted by name and value.
+	// Otherwise, query parameters keep the order of the request.
+	SortQuery bool `yaml:"sort_query" json:"sort_query"`
+
+	// LowercaseHost specifies whether the host is lowercased.
+	LowercaseHost bool `yaml:"lowercase_host" json:"lowercase_host"`
+
+	// IgnoreScheme specifies whether http and https requests share the same key.
+	IgnoreScheme bool `yaml:"ignore_scheme" json:"ignore_scheme"`
+
+	// Headers holds the request header fields whose values are folded into the key.
+	Headers []string `yaml:"headers" json:"headers"`
+
+	// Cookies holds the request cookies whose values are folded into the key.
+	Cookies []string `yaml:"cookies" json:"cookies"`
+
+	// Matcher holds the compiled regex.
+	Matcher *regexp.Regexp `json:"-"`
+}
+
+// apply applies the policy to the key of the request.
+func (p *KeyPolicy) apply(key *Key, req *http.Request) {
+	if p.LowercaseHost {
+		key.Host = strings.ToLower(key.Host)
+	}
+	if p.IgnoreScheme {
+		key.Scheme = ""
+	}
+	key.Query = p.query(req.URL.RawQuery)
+	key.Fields = p.fields(req)
+}
+
+// query returns the raw query filtered and ordered according to the policy.
+// Invalid query parameters are skipped.
+func (p *KeyPolicy) query(rawQuery string) string {
+	var params [][2]string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(name)
+		if err != nil {
+			continue
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil || !p.includesParam(name) {
+			continue
+		}
+		params = append(params, [2]string{name, value})
+	}
+	if p.SortQuery {
+		sort.SliceStable(params, func(i, j int) bool {
+			if params[i][0] != params[j][0] {
+				return params[i][0] < params[j][0]
+			}
+			return params[i][1] < params[j][1]
+		})
+	}
+
+	var b strings.Builder
+	for i, param := range params {
+		if i > 0 {
+			_ = b.WriteByte('&')
+		}
+		_, _ = b.WriteString(url.QueryEscape(param[0]))
+		_ = b.WriteByte('=')
+		_, _ = b.WriteString(url.QueryEscape(param[1]))
+	}
+	return b.String()
+}
+
+// includesParam checks whether the query parameter is part of the key.
+func (p *KeyPolicy) includesParam(name string) bool {
+	if len(p.IncludeQuery) > 0 && !matchesAny(p.IncludeQuery, name) {
+		return false
+	}
+	return !matchesAny(p.IgnoreQuery, name)
+}
+
+// fields returns the hash of the request header fields and cookies folded
+// into the key, or an empty string, if the policy does not fold any.
+func (p *KeyPolicy) fields(req *http.Request) string {
+	if len(p.Headers) == 0 && len(p.Cookies) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, name := range p.Headers {
+		_, _ = b.WriteString(textproto.CanonicalMIMEHeaderKey(name))
+		_ = b.WriteByte('=')
+		_, _ = b.WriteString(normalizeHeaderValue(req.Header.Values(name)))
+		_ = b.WriteByte('\n')
+	}
+	for _, name := range p.Cookies {
+		_, _ = b.WriteString("cookie:")
+		_, _ = b.WriteString(name)
+		_ = b.WriteByte('=')
+		if cookie, err := req.Cookie(name); err == nil {
+			_, _ = b.WriteString(cookie.Value)
+		}
+		_ = b.WriteByte('\n')
+	}
+	return fmt.Sprintf("%016x", xxhash.Sum64String(b.String()))
+}
+
+// matchesAny checks whether the name matches any of the shell patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyFromRequest creates the cache key of the request, composed according
+// to the key policy of the request path, if any.
+func (c *HttpCache) KeyFromRequest(req *http.Request) *Key {
+	key := NewKeyFromRequst(req)
+	if p := c.keyPolicy(req); p != nil {
+		p.apply(key, req)
+	}
+	return key
+}
+
+// keyPolicy returns the key policy of the matching rules, or else
+// the first key policy matching the request path, or nil.
+func (c *HttpCache) keyPolicy(req *http.Request) *KeyPolicy {
+	if actions := c.RequestRules(req); actions.Key != nil {
+		return actions.Key
+	}
+	config := c.loadConfig()
+	for i, kp := range config.KeyPolicies {
+		if kp.Matcher != nil && kp.Matcher.MatchString(req.URL.Path) {
+			return &config.KeyPolicies[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyFromRequest(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		KeyPolicies: []KeyPolicy{
+			{Path: "^/articles", IgnoreQuery: []string{"utm_*", "fbclid"}},
+			{Path: "^/search", IncludeQuery: []string{"q", "page"}, SortQuery: true},
+			{Path: "^/assets", LowercaseHost: true, IgnoreScheme: true},
+			{Path: "^/products", Headers: []string{"x-country"}, Cookies: []string{"currency"}},
+		},
+	}, p)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		url      string
+		header   http.Header
+		expected string
+	}{
+		{"no policy", "https://example.com/home?b=2&a=1",
+			nil, "kache-https://example.com/home?a=1&b=2"},
+		{"ignore query", "https://example.com/articles/42?utm_source=x&id=1&fbclid=y&utm_medium=z",
+			nil, "kache-https://example.com/articles/42?id=1"},
+		{"keep query order", "https://example.com/articles/42?b=2&a=1",
+			nil, "kache-https://example.com/articles/42?b=2&a=1"},
+		{"include and sort query", "https://example.com/search?sid=1&q=kache&page=2&q=cache",
+			nil, "kache-https://example.com/search?page=2&q=cache&q=kache"},
+		{"escaped query", "https://example.com/search?q=a%20b%26c",
+			nil, "kache-https://example.com/search?q=a+b%26c"},
+		{"lowercase host and ignore scheme", "https://Example.COM/assets/app.css",
+			nil, "kache-//example.com/assets/app.css"},
+		{"fold fields", "https://example.com/products/1",
+			http.Header{"X-Country": {"de"}, "Cookie": {"currency=eur; session=1"}},
+			"kache-https://example.com/products/1#fields-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			require.NoError(t, err)
+			if tt.header != nil {
+				req.Header = tt.header
+			}
+			assert.Contains(t, c.KeyFromRequest(req).String(), tt.expected)
+		})
+	}
+
+	// Folded fields select different keys, unrelated header fields and cookies do not.
+	key := func(country, cookie string) string {
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com/products/1", nil)
+		req.Header.Set("X-Country", country)
+		req.Header.Set("Cookie", cookie)
+		req.Header.Set("User-Agent", country+cookie)
+		return c.KeyFromRequest(req).String()
+	}
+	assert.Equal(t, key("de", "currency=eur; session=1"), key("de", "session=2; currency=eur"))
+	assert.NotEqual(t, key("de", "currency=eur"), key("us", "currency=eur"))
+	assert.NotEqual(t, key("de", "currency=eur"), key("de", "currency=usd"))
+}
+
+func TestKeyPolicyInvalidate(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		KeyPolicies: []KeyPolicy{{Path: "^/articles", IgnoreQuery: []string{"utm_*"}}},
+	}, p)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/articles/42?utm_source=x", nil)
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		HeaderLocation: {"/articles/43?utm_medium=y"},
+	}}
+	assert.Equal(t, []string{
+		"kache-https://example.com/articles/42",
+		"kache-https://example.com/articles/43",
+	}, c.Invalidate(req.Context(), req, res))
+}
@@ -24,6 +24,7 @@ package cache
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -59,3 +60,70 @@ func TestHashKey(t *testing.T) {
 	}
 
 }
+
+func TestParseVary(t *testing.T) {
+	h := http.Header{}
+	assert.Empty(t, ParseVary(h))
+
+	h.Add("Vary", "accept-encoding, Accept-Language")
+	h.Add("Vary", "Accept-Encoding,,User-Agent")
+	assert.Equal(t, []string{"Accept-Encoding", "Accept-Language", "User-Agent"}, ParseVary(h))
+	assert.False(t, VaryAll(h))
+
+	h.Set("Vary", "Accept-Encoding, *")
+	assert.True(t, VaryAll(h))
+}
+
+func TestVaryKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.com/with/path", nil)
+	key := NewKeyFromRequst(req)
+	vary := []string{"Accept-Encoding", "Accept-Language"}
+
+	gzip := http.Header{}
+	gzip.Set("Accept-Encoding", "gzip, br")
+	gzip.Set("Accept-Language", "en")
+
+	// Optional whitespace and multiple field lines select the same variant.
+	gzipFolded := http.Header{}
+	gzipFolded.Add("Accept-Encoding", "gzip")
+	gzipFolded.Add("Accept-Encoding", "br")
+	gzipFolded.Set("Accept-Language", " en ")
+
+	plain := http.Header{}
+	plain.Set("Accept-Language", "en")
+
+	assert.True(t, strings.HasPrefix(key.VaryKey(vary, gzip), key.String()))
+	assert.Equal(t, key.VaryKey(vary, gzip), key.VaryKey(vary, gzipFolded))
+	assert.NotEqual(t, key.VaryKey(vary, gzip), key.VaryKey(vary, plain))
+
+	assert.True(t, VaryMatches(vary, gzip, gzipFolded))
+	assert.False(t, VaryMatches(vary, gzip, plain))
+	assert.True(t, VaryMatches(nil, gzip, plain))
+	assert.False(t, VaryMatches([]string{"*"}, gzip, gzip))
+}
+
+func TestSliceKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.com/videos/movie.mp4", nil)
+	key := NewKeyFromRequst(req)
+
+	assert.True(t, strings.HasPrefix(key.SliceKey(0), key.String()))
+	assert.NotEqual(t, key.SliceKey(0), key.SliceKey(1))
+	assert.NotEqual(t, key.String(), key.SliceKey(0))
+}
+
+func TestCredentialKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.com/api/profile", nil)
+	assert.Empty(t, CredentialHash(req))
+	shared := NewKeyFromRequst(req).String()
+
+	req.Header.Set(HeaderAuthorization, "Bearer alice")
+	alice := NewKeyFromRequst(req)
+	alice.Credential = CredentialHash(req)
+	assert.True(t, strings.HasPrefix(alice.String(), shared+"#"))
+	assert.NotContains(t, alice.String(), "alice")
+
+	req.Header.Set(HeaderAuthorization, "Bearer bob")
+	bob := NewKeyFromRequst(req)
+	bob.Credential = CredentialHash(req)
+	assert.NotEqual(t, alice.String(), bob.String())
+}
@@ -0,0 +1,104 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidStatus indicates an invalid status code or status class.
+var ErrInvalidStatus = errors.New("invalid status")
+
+// NegativeTTL holds the TTL of error responses with a specific status code or class.
+type NegativeTTL struct {
+	// Status is the status code (e.g. 404) or the status class (e.g. 5xx).
+	Status string `yaml:"status" json:"status"`
+	// TTL is the time-to-live of responses with the status.
+	TTL time.Duration `yaml:"ttl" json:"ttl"`
+	// from and to hold the parsed range of status codes.
+	from, to int
+}
+
+// matches checks if the status code is covered by the negative TTL.
+func (n *NegativeTTL) matches(code int) bool {
+	return n.from > 0 && n.from <= code && code <= n.to
+}
+
+// parseStatusRange parses a status code, e.g. 404, or a status class, e.g. 5xx,
+// into the range of status codes covered.
+func parseStatusRange(s string) (int, int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+		class := int(s[0]-'0') * 100
+		return class, class + 99, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidStatus, s)
+	}
+	return code, code, nil
+}
+
+// NegativeTTL returns the negative TTL configured for the status code of the response.
+// In strict mode, it is only applied to responses without explicit freshness information.
+func (c *HttpCache) NegativeTTL(res *http.Response) (time.Duration, bool) {
+	config := c.loadConfig()
+	for _, n := range config.NegativeTTLs {
+		if n.TTL <= 0 || !n.matches(res.StatusCode) {
+			continue
+		}
+		if config.Strict && hasValidationData(res.Header,
+			EdgeCacheControl(res.Header)) {
+			return 0, false
+		}
+		return n.TTL, true
+	}
+	return 0, false
+}
+
+// IsCacheableResponse checks if a response can be stored in strict mode, according to the
+// configured cacheable status codes. Error responses with a negative TTL can be stored,
+// even if they lack the data to calculate their freshness lifetime.
+func (c *HttpCache) IsCacheableResponse(res *http.Response) bool {
+	return c.uncacheableReason(res) == ""
+}
+
+// uncacheableReason returns the reason why the response cannot be stored,
+// or an empty string, if it can be stored, see IsCacheableResponse.
+func (c *HttpCache) uncacheableReason(res *http.Response) string {
+	if _, ok := c.NegativeTTL(res); ok {
+		switch {
+		case VaryAll(res.Header):
+			return ReasonVaryAll
+		case EdgeCacheControl(res.Header).NoStore:
+			return ReasonNoStore
+		}
+		return ""
+	}
+	return uncacheableReason(res, c.loadConfig().statusCodes, c.heuristic())
+}
@@ -0,0 +1,164 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		status   string
+		from, to int
+		err      bool
+	}{
+		{"404", 404, 404, false},
+		{" 410 ", 410, 410, false},
+		{"5xx", 500, 599, false},
+		{"4XX", 400, 499, false},
+		{"6xx", 0, 0, true},
+		{"600", 0, 0, true},
+		{"4x4", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		from, to, err := parseStatusRange(tt.status)
+		if tt.err {
+			assert.ErrorIs(t, err, ErrInvalidStatus, tt.status)
+			continue
+		}
+		require.NoError(t, err, tt.status)
+		assert.Equal(t, tt.from, from, tt.status)
+		assert.Equal(t, tt.to, to, tt.status)
+	}
+}
+
+func TestCacheableStatusCodes(t *testing.T) {
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Strict:               true,
+		CacheableStatusCodes: []int{200, 302},
+	}, nil)
+	require.NoError(t, err)
+
+	res := &http.Response{Header: http.Header{"Cache-Control": []string{"max-age=60"}}}
+	for code, want := range map[int]bool{200: true, 302: true, 301: false, 404: false} {
+		res.StatusCode = code
+		assert.Equal(t, want, c.IsCacheableResponse(res), code)
+	}
+
+	// Defaults to the status codes cacheable by default.
+	c.UpdateConfig(&HttpCacheConfig{Strict: true})
+	for code, want := range map[int]bool{200: true, 302: false, 301: true, 404: true} {
+		res.StatusCode = code
+		assert.Equal(t, want, c.IsCacheableResponse(res), code)
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Strict: true,
+		NegativeTTLs: []NegativeTTL{
+			{Status: "404", TTL: 30 * time.Second},
+			{Status: "5xx", TTL: 5 * time.Second},
+			{Status: "invalid", TTL: time.Minute},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		status    int
+		header    http.Header
+		ttl       time.Duration
+		cacheable bool
+	}{
+		{status: http.StatusNotFound, ttl: 30 * time.Second, cacheable: true},
+		{status: http.StatusServiceUnavailable, ttl: 5 * time.Second, cacheable: true},
+		{status: http.StatusGone},
+		{status: http.StatusOK},
+		{
+			// Explicit freshness information takes precedence.
+			status:    http.StatusNotFound,
+			header:    http.Header{"Cache-Control": []string{"max-age=60"}},
+			cacheable: true,
+		},
+		{
+			// The no-store directive is respected.
+			status: http.StatusInternalServerError,
+			header: http.Header{"Cache-Control": []string{"no-store"}},
+			ttl:    5 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		if tt.header == nil {
+			tt.header = http.Header{}
+		}
+		res := &http.Response{StatusCode: tt.status, Header: tt.header}
+		ttl, ok := c.NegativeTTL(res)
+		assert.Equal(t, tt.ttl, ttl, tt.status)
+		assert.Equal(t, tt.ttl > 0, ok, tt.status)
+		assert.Equal(t, tt.cacheable, c.IsCacheableResponse(res), tt.status)
+	}
+}
+
+func TestStoreFetchNegative(t *testing.T) {
+	ctx := context.Background()
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Strict:       true,
+		StaleIfError: "300s",
+		NegativeTTLs: []NegativeTTL{{Status: "404", TTL: 30 * time.Second}},
+	}, p)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://example.com/missing", nil)
+	lookup := NewLookupRequest(req, currentTime(), true)
+	res := &http.Response{
+		StatusCode: http.StatusNotFound,
+		ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{"Date": []string{formatTime(currentTime())}},
+		Body:   io.NopCloser(strings.NewReader("not found")),
+	}
+	c.StoreResponse(ctx, lookup, res, currentTime())
+
+	entry := c.fetchEntry(ctx, lookup.Key.String())
+	require.NotNil(t, entry)
+	assert.True(t, entry.Negative)
+	assert.Equal(t, 30*time.Second, entry.TTL, "negative TTL without stale-if-error grace")
+
+	// The entry is fresh for its TTL.
+	result := c.FetchResponse(ctx, *lookup)
+	assert.Equal(t, EntryOk, result.Status)
+	assert.Equal(t, http.StatusNotFound, result.Response().StatusCode)
+
+	later := NewLookupRequest(req, currentTime().Add(31*time.Second), true)
+	assert.Equal(t, EntryRequiresValidation, c.FetchResponse(ctx, *later).Status)
+}
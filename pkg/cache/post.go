@@ -0,0 +1,154 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultMaxPostBodySize is the default maximum size of POST request bodies keyed by the cache.
+const DefaultMaxPostBodySize = 64 << 10 // 64 KiB
+
+// Post holds the configuration of paths/resources whose POST requests are idempotent, e.g.
+// search or GraphQL endpoints. Their responses are cached under a key derived from the
+// request body, and concurrent requests with the same body are coalesced.
+type Post struct {
+	// Path is the path POST requests are cached for. String or Regex.
+	Path string `yaml:"path" json:"path"`
+	// MaxBodySize is the maximum size of request bodies in bytes. Requests with
+	// larger bodies are not cached. Defaults to 'DefaultMaxPostBodySize'.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty" json:"max_body_size,omitempty"`
+	// GraphQL specifies whether the requests are GraphQL requests. Queries are keyed by
+	// their operation name, query and variables; mutations and subscriptions are not cached.
+	GraphQL bool `yaml:"graphql" json:"graphql"`
+	// Matcher holds the compiled regex.
+	Matcher *regexp.Regexp `json:"-"`
+}
+
+// bodyHashKey is the context key of the body hash of a POST request, see ReadBody.
+type bodyHashKey struct{}
+
+// ReadBody reads the body of a POST request to a path whose POST requests are cached, so that
+// the request can be keyed by its body. The returned request carries the body hash and a body
+// which can be replayed upstream. If the body exceeds the size limit, or is not a cacheable
+// GraphQL query, the returned request is handled as any other POST request. Requests of other
+// methods and paths, and requests whose body has already been read, are returned as is.
+func (c *HttpCache) ReadBody(req *http.Request) (*http.Request, error) {
+	if req.Method != http.MethodPost {
+		return req, nil
+	}
+	if _, ok := req.Context().Value(bodyHashKey{}).(string); ok {
+		return req, nil
+	}
+	post := c.post(req.URL.Path)
+	if post == nil {
+		return req, nil
+	}
+
+	limit := post.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxPostBodySize
+	}
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+		if err != nil {
+			return req, err
+		}
+		body = b
+	}
+
+	hash := ""
+	if int64(len(body)) > limit {
+		log.Debug().Str("path", req.URL.Path).Int64("limit", limit).Msg("POST body too large to be cached")
+	} else if hash = post.bodyHash(body); hash == "" {
+		log.Debug().Str("path", req.URL.Path).Msg("Uncacheable GraphQL request")
+	}
+
+	r := req.WithContext(context.WithValue(req.Context(), bodyHashKey{}, hash))
+	if int64(len(body)) > limit {
+		// The body is replayed in full, but not buffered beyond the limit.
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return r, nil
+	}
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		if len(body) == 0 {
+			return http.NoBody, nil
+		}
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return r, nil
+}
+
+// bodyHash returns the hash of the request body, or an empty string,
+// if the body is not a cacheable GraphQL query.
+func (p *Post) bodyHash(body []byte) string {
+	if p.GraphQL {
+		normalized, ok := normalizeGraphQL(body)
+		if !ok {
+			return ""
+		}
+		body = normalized
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:16])
+}
+
+// BodyHash returns the hash of the body of a POST request keyed by its body,
+// or an empty string, see ReadBody.
+func BodyHash(req *http.Request) string {
+	hash, _ := req.Context().Value(bodyHashKey{}).(string)
+	return hash
+}
+
+// isCacheablePost checks if the request is a POST request keyed by its body.
+func isCacheablePost(req *http.Request) bool {
+	return req.Method == http.MethodPost && BodyHash(req) != ""
+}
+
+// post returns the first post configuration matching the path, or nil.
+func (c *HttpCache) post(p string) *Post {
+	config := c.loadConfig()
+	for i, po := range config.Post {
+		if po.Matcher != nil && po.Matcher.MatchString(p) {
+			return &config.Post[i]
+		}
+	}
+	return nil
+}
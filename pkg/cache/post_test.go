@@ -0,0 +1,109 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBody(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Post: []Post{
+			{Path: "^/graphql", GraphQL: true},
+			{Path: "^/search", MaxBodySize: 16},
+		},
+	}, p)
+	require.NoError(t, err)
+
+	read := func(method, url, body string) (*http.Request, string) {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+		req, err = c.ReadBody(req)
+		require.NoError(t, err)
+		// The body is replayed in full.
+		replayed, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(replayed))
+		return req, BodyHash(req)
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		body      string
+		cacheable bool
+	}{
+		{"search", http.MethodPost, "http://example.com/search", `{"q":"kache"}`, true},
+		{"empty body", http.MethodPost, "http://example.com/search", ``, true},
+		{"body too large", http.MethodPost, "http://example.com/search", `{"q":"kache","page":2}`, false},
+		{"other path", http.MethodPost, "http://example.com/orders", `{"id":1}`, false},
+		{"put", http.MethodPut, "http://example.com/search", `{"q":"kache"}`, false},
+		{"graphql query", http.MethodPost, "http://example.com/graphql", `{"query":"{ a }"}`, true},
+		{"graphql mutation", http.MethodPost, "http://example.com/graphql", `{"query":"mutation { a }"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, hash := read(tt.method, tt.url, tt.body)
+			assert.Equal(t, tt.cacheable, hash != "")
+			if tt.cacheable {
+				assert.Equal(t, "", c.BypassReason(req))
+				assert.Contains(t, c.KeyFromRequest(req).String(), "#body-"+hash)
+				assert.Equal(t, int64(len(tt.body)), req.ContentLength)
+				// The body can be replayed again.
+				body, err := req.GetBody()
+				require.NoError(t, err)
+				replayed, _ := io.ReadAll(body)
+				assert.Equal(t, tt.body, string(replayed))
+			} else {
+				assert.Equal(t, BypassUnsafeMethod, c.BypassReason(req))
+			}
+		})
+	}
+
+	// Requests with the same body share the key, others do not.
+	_, a := read(http.MethodPost, "http://example.com/search", `{"q":"a"}`)
+	_, b := read(http.MethodPost, "http://example.com/search", `{"q":"b"}`)
+	_, a2 := read(http.MethodPost, "http://example.com/search", `{"q":"a"}`)
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, a, a2)
+
+	// GraphQL queries differing in ignored tokens only share the key.
+	_, q1 := read(http.MethodPost, "http://example.com/graphql", `{"query":"{ user { name } }"}`)
+	_, q2 := read(http.MethodPost, "http://example.com/graphql", `{"query": "{user{name}}" }`)
+	assert.Equal(t, q1, q2)
+
+	// A request whose body has been read is returned as is.
+	req, _ := read(http.MethodPost, "http://example.com/search", `{"q":"a"}`)
+	again, err := c.ReadBody(req)
+	require.NoError(t, err)
+	assert.Same(t, req, again)
+}
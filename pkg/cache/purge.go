@@ -0,0 +1,70 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+)
+
+// SoftPurge invalidates all entries with keys matching the matcher, instead of deleting
+// them. An invalidated entry is kept in the cache for the rest of its time-to-live, but must
+// be validated by the origin before it is served again. This allows a conditional request
+// instead of a full fetch and still serves the entry if the origin fails (stale-if-error).
+// Variants and slices of a matching key are invalidated as well. It returns the number of
+// invalidated entries.
+func (c *HttpCache) SoftPurge(ctx context.Context, m *provider.Matcher, now time.Time) int {
+	n := 0
+	for _, key := range c.cache.Keys(ctx, m.Prefix()) {
+		// Keys of variants and slices are derived from the primary key, see Key.VaryKey.
+		primary, _, _ := strings.Cut(key, "#")
+		if !m.Match(key) && !m.Match(primary) {
+			continue
+		}
+		if c.invalidate(ctx, key, now) {
+			n++
+		}
+	}
+	return n
+}
+
+// invalidate marks the response entry stored under the key as invalidated. The entry is
+// stored for its remaining time-to-live only, so that it never outlives the bans added
+// after it has been stored, see HttpCache.Ban. It returns false if there is no valid,
+// unexpired response entry stored under the key.
+func (c *HttpCache) invalidate(ctx context.Context, key string, now time.Time) bool {
+	entry := c.fetchEntry(ctx, key)
+	if entry == nil || len(entry.Vary) > 0 || entry.Invalidated {
+		return false
+	}
+	ttl := entry.ResponseTime.Add(entry.TTL).Sub(now)
+	if ttl <= 0 {
+		return false
+	}
+	entry.Invalidated = true
+	c.setEntry(key, entry, ttl)
+	return true
+}
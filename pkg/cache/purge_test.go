@@ -0,0 +1,143 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftPurge(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(nil, p)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	store := func(url string, header http.Header) *LookupRequest {
+		req, _ := http.NewRequest("GET", url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		lookup := NewLookupRequest(req, currentTime(), true)
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Cache-Control": []string{"max-age=3600"},
+				"Date":          []string{formatTime(currentTime())},
+				"Etag":          []string{`"a1"`},
+				"Vary":          []string{"Accept-Language"},
+			},
+			Body: io.NopCloser(strings.NewReader("body")),
+		}
+		c.StoreResponse(ctx, lookup, res, currentTime())
+		return lookup
+	}
+
+	en := store("http://example.com/news", http.Header{"Accept-Language": []string{"en"}})
+	de := store("http://example.com/news", http.Header{"Accept-Language": []string{"de"}})
+	other := store("http://example.com/archive", nil)
+	assert.Equal(t, EntryOk, c.FetchResponse(ctx, *en).Status)
+
+	// The variants of the purged key are invalidated, the entries are kept.
+	m, err := provider.NewGlobMatcher("kache-http://example.com/news")
+	require.NoError(t, err)
+	assert.Equal(t, 2, c.SoftPurge(ctx, m, currentTime()))
+	assert.Equal(t, 5, p.Size())
+
+	for _, lookup := range []*LookupRequest{en, de} {
+		result := c.FetchResponse(ctx, *lookup)
+		assert.Equal(t, EntryRequiresValidation, result.Status)
+		assert.Equal(t, `"a1"`, result.Header().Get(HeaderEtag))
+	}
+	assert.Equal(t, EntryOk, c.FetchResponse(ctx, *other).Status)
+
+	// Invalidated entries are not invalidated again.
+	assert.Equal(t, 0, c.SoftPurge(ctx, m, currentTime()))
+
+	// Invalidated entries require validation in non-strict mode as well.
+	lookup := NewLookupRequest(en.Request, currentTime(), false)
+	assert.Equal(t, EntryRequiresValidation, c.FetchResponse(ctx, *lookup).Status)
+
+	// Storing the validated response clears the invalidation.
+	store("http://example.com/news", http.Header{"Accept-Language": []string{"en"}})
+	assert.Equal(t, EntryOk, c.FetchResponse(ctx, *en).Status)
+	assert.Equal(t, EntryRequiresValidation, c.FetchResponse(ctx, *de).Status)
+}
+
+// ttlRecorder is a provider recording the TTL of the stored keys.
+type ttlRecorder struct {
+	provider.Provider
+	ttls map[string]time.Duration
+}
+
+func (p *ttlRecorder) Set(key string, val []byte, ttl time.Duration) {
+	p.ttls[key] = ttl
+	p.Provider.Set(key, val, ttl)
+}
+
+func TestSoftPurgeRemainingTTL(t *testing.T) {
+	inner, _ := provider.NewSimpleCache(nil)
+	p := &ttlRecorder{Provider: inner, ttls: map[string]time.Duration{}}
+	c, err := NewHttpCache(nil, p)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	req, _ := http.NewRequest("GET", "http://example.com/news", nil)
+	lookup := c.NewLookup(req, currentTime())
+	c.StoreResponse(ctx, lookup, &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=60"},
+			"Date":          []string{formatTime(currentTime())},
+		},
+		Body: io.NopCloser(strings.NewReader("body")),
+	}, currentTime())
+	key := lookup.Key.String()
+	ttl := p.ttls[key]
+	require.Positive(t, ttl)
+
+	m, err := provider.NewGlobMatcher(key)
+	require.NoError(t, err)
+
+	// Invalidated entries are kept for their remaining TTL, not their original TTL.
+	assert.Equal(t, 1, c.SoftPurge(ctx, m, currentTime().Add(30*time.Second)))
+	assert.Equal(t, ttl-30*time.Second, p.ttls[key])
+	assert.Equal(t, ttl, c.fetchEntry(ctx, key).TTL)
+
+	// Expired entries are not stored again.
+	c.StoreResponse(ctx, lookup, &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Cache-Control": []string{"max-age=60"}},
+		Body:       io.NopCloser(strings.NewReader("body")),
+	}, currentTime())
+	assert.Equal(t, 0, c.SoftPurge(ctx, m, currentTime().Add(ttl)))
+	assert.Equal(t, ttl, p.ttls[key])
+}
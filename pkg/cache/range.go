@@ -0,0 +1,355 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+var (
+	// errInvalidRange indicates a syntactically invalid range header.
+	errInvalidRange = errors.New("invalid range")
+
+	// errNoOverlap indicates that none of the ranges overlap the content.
+	errNoOverlap = errors.New("invalid range: failed to overlap")
+)
+
+// httpRange specifies the byte range to be sent to the client.
+type httpRange struct {
+	start, length int64
+}
+
+// ByteRange is a single byte range of a content.
+type ByteRange struct {
+	Start, Length int64
+}
+
+// ContentRange returns the Content-Range header value of the range.
+func (r ByteRange) ContentRange(size int64) string {
+	return httpRange{r.Start, r.Length}.contentRange(size)
+}
+
+// SelectRange selects the byte range requested by the range request for a content of the
+// given size and the given response header. It returns the selected range and the status
+// of the response: http.StatusPartialContent if a single range is selected,
+// http.StatusRequestedRangeNotSatisfiable if the range does not overlap the content, or
+// http.StatusOK if the complete content is to be served, i.e. the request is not a range
+// request, the range is invalid, multiple ranges are requested, or the If-Range
+// precondition is not satisfied.
+func SelectRange(req *http.Request, header http.Header, size int64) (ByteRange, int) {
+	complete := ByteRange{0, size}
+	if !IsRangeRequest(req) || !checkIfRange(req, header) {
+		return complete, http.StatusOK
+	}
+	ranges, err := parseRange(req.Header.Get(HeaderRange), size)
+	switch {
+	case errors.Is(err, errNoOverlap):
+		return ByteRange{}, http.StatusRequestedRangeNotSatisfiable
+	case err != nil || len(ranges) != 1:
+		return complete, http.StatusOK
+	}
+	return ByteRange{ranges[0].start, ranges[0].length}, http.StatusPartialContent
+}
+
+// ParseContentRangeSize returns the complete length of the content from a
+// Content-Range header, e.g. 'bytes 0-1023/146515', or -1 if unknown or invalid.
+// https://httpwg.org/specs/rfc9110.html#field.content-range
+func ParseContentRangeSize(contentRange string) int64 {
+	_, size, ok := strings.Cut(contentRange, "/")
+	if !ok || !strings.HasPrefix(contentRange, "bytes ") {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// contentRange returns the Content-Range header value of the range.
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// IsRangeRequest returns true if the request is a range request.
+// https://httpwg.org/specs/rfc9110.html#field.range
+func IsRangeRequest(req *http.Request) bool {
+	return req.Method == http.MethodGet && req.Header.Get(HeaderRange) != ""
+}
+
+// RangeResponse serves the range request from the given complete (200) response.
+// Single ranges are answered with a 206 (Partial Content) response, multiple ranges
+// with a multipart/byteranges 206 response. If none of the ranges overlaps the content,
+// a 416 (Range Not Satisfiable) response is returned. The complete response is returned
+// unchanged if the request is not a range request, the range is invalid, or the If-Range
+// precondition is not satisfied. If the size of the content is known, a single range is
+// streamed from the body of the response, otherwise the body is read into memory.
+func RangeResponse(req *http.Request, res *http.Response) *http.Response {
+	if !IsRangeRequest(req) || res.StatusCode != http.StatusOK || !checkIfRange(req, res.Header) {
+		return res
+	}
+
+	size := res.ContentLength
+	ranges, err := parseRange(req.Header.Get(HeaderRange), size)
+	if size < 0 || (err == nil && len(ranges) > 1) {
+		body, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			res.Body = io.NopCloser(bytes.NewReader(nil))
+			return res
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		res.ContentLength = int64(len(body))
+		if size < 0 {
+			size = res.ContentLength
+			ranges, err = parseRange(req.Header.Get(HeaderRange), size)
+		}
+	}
+
+	switch {
+	case errors.Is(err, errNoOverlap):
+		_ = closeBody(res.Body)
+		header := make(http.Header)
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return partialResponse(req, res, http.StatusRequestedRangeNotSatisfiable, header,
+			http.NoBody, 0)
+	case err != nil || sumRangesSize(ranges) > size:
+		// Ignore invalid ranges, or ranges requesting more than the content itself.
+		return res
+	}
+
+	header := res.Header.Clone()
+	header.Del("Content-Length")
+	header.Set("Accept-Ranges", "bytes")
+
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		header.Set("Content-Range", ra.contentRange(size))
+		return partialResponse(req, res, http.StatusPartialContent, header,
+			&rangeBody{body: res.Body, skip: ra.start, length: ra.length}, ra.length)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Range": {ra.contentRange(size)},
+			"Content-Type":  {res.Header.Get("Content-Type")},
+		})
+		if err != nil {
+			res.Body = io.NopCloser(bytes.NewReader(body))
+			return res
+		}
+		_, _ = part.Write(body[ra.start : ra.start+ra.length])
+	}
+	_ = mw.Close()
+	header.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	return partialResponse(req, res, http.StatusPartialContent, header,
+		io.NopCloser(&buf), int64(buf.Len()))
+}
+
+// partialResponse creates a response with the given status, header and body.
+func partialResponse(req *http.Request, res *http.Response, status int,
+	header http.Header, body io.ReadCloser, length int64) *http.Response {
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         res.Proto,
+		ProtoMajor:    res.ProtoMajor,
+		ProtoMinor:    res.ProtoMinor,
+		Header:        header,
+		Body:          body,
+		ContentLength: length,
+		Request:       req,
+	}
+}
+
+// rangeBody streams a single range of the underlying body. The bytes before the
+// range are skipped on the first read.
+type rangeBody struct {
+	body         io.ReadCloser
+	skip, length int64
+}
+
+// Read reads from the range of the underlying body.
+func (r *rangeBody) Read(p []byte) (int, error) {
+	if r.skip > 0 {
+		n, err := io.CopyN(io.Discard, r.body, r.skip)
+		r.skip -= n
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	if r.length <= 0 {
+		drainStored(r.body)
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.length {
+		p = p[:r.length]
+	}
+	n, err := r.body.Read(p)
+	r.length -= int64(n)
+	if err == io.EOF && r.length > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close closes the underlying body.
+func (r *rangeBody) Close() error {
+	return closeBody(r.body)
+}
+
+// closeBody drains a body that is being stored, see drainStored, and closes it.
+func closeBody(body io.ReadCloser) error {
+	drainStored(body)
+	return body.Close()
+}
+
+// drainStored reads the rest of a body that is stored in the cache while it is
+// streamed, so that it can still be stored. Reading stops as soon as the body is
+// stored, or storing is aborted, e.g. because the body is too large.
+func drainStored(body io.Reader) {
+	t, ok := body.(*teeBody)
+	if !ok {
+		return
+	}
+	buf := make([]byte, 32<<10)
+	for t.storing() {
+		if _, err := t.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// checkIfRange evaluates the If-Range precondition. It returns true if the precondition
+// is absent or satisfied, i.e. the range request can be served.
+// https://httpwg.org/specs/rfc9110.html#field.if-range
+func checkIfRange(req *http.Request, header http.Header) bool {
+	ifRange := strings.TrimSpace(req.Header.Get(HeaderIfRange))
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		etag := header.Get(HeaderEtag)
+		return !isWeakEtag(ifRange) && !isWeakEtag(etag) && ifRange == etag
+	}
+	date := parseHttpTime(ifRange)
+	lm := parseHttpTime(header.Get(HeaderLastModified))
+	return !date.IsZero() && !lm.IsZero() && lm.Equal(date)
+}
+
+// parseRange parses a Range header string as per RFC 9110.
+// https://httpwg.org/specs/rfc9110.html#byte.ranges
+func parseRange(s string, size int64) ([]httpRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		start, end = textproto.TrimString(start), textproto.TrimString(end)
+		var r httpRange
+		if start == "" {
+			// Suffix range, i.e. the last N bytes of the content.
+			if end == "" || end[0] == '-' {
+				return nil, errInvalidRange
+			}
+			i, err := strconv.ParseInt(end, 10, 64)
+			if i < 0 || err != nil {
+				return nil, errInvalidRange
+			}
+			if i == 0 {
+				noOverlap = true
+				continue
+			}
+			if i > size {
+				i = size
+			}
+			r.start = size - i
+			r.length = size - r.start
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errInvalidRange
+			}
+			if i >= size {
+				// The range begins after the content ends.
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if end == "" {
+				// No end specified, range extends to end of the content.
+				r.length = size - r.start
+			} else {
+				i, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || r.start > i {
+					return nil, errInvalidRange
+				}
+				if i >= size {
+					i = size - 1
+				}
+				r.length = i - r.start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	if len(ranges) == 0 {
+		return nil, errInvalidRange
+	}
+	return ranges, nil
+}
+
+// sumRangesSize returns the total size of the ranges.
+func sumRangesSize(ranges []httpRange) (size int64) {
+	for _, ra := range ranges {
+		size += ra.length
+	}
+	return
+}
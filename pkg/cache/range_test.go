@@ -0,0 +1,258 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		header string
+		size   int64
+		want   []httpRange
+		err    error
+	}{
+		{"bytes=0-4", 10, []httpRange{{0, 5}}, nil},
+		{"bytes=2-", 10, []httpRange{{2, 8}}, nil},
+		{"bytes=-3", 10, []httpRange{{7, 3}}, nil},
+		{"bytes=-20", 10, []httpRange{{0, 10}}, nil},
+		{"bytes=5-20", 10, []httpRange{{5, 5}}, nil},
+		{"bytes=0-0, 2-3", 10, []httpRange{{0, 1}, {2, 2}}, nil},
+		{"bytes=10-", 10, nil, errNoOverlap},
+		{"bytes=-0", 10, nil, errNoOverlap},
+		{"bytes=10-20, 0-1", 10, []httpRange{{0, 2}}, nil},
+		{"bytes=5-4", 10, nil, errInvalidRange},
+		{"bytes=a-b", 10, nil, errInvalidRange},
+		{"bytes=", 10, nil, errInvalidRange},
+		{"items=0-4", 10, nil, errInvalidRange},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			ranges, err := parseRange(tc.header, tc.size)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.want, ranges)
+		})
+	}
+}
+
+func TestRangeResponse(t *testing.T) {
+	lastModified := formatTime(currentTime())
+
+	newResponse := func() *http.Response {
+		res := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			Body:          io.NopCloser(strings.NewReader("0123456789")),
+			ContentLength: 10,
+		}
+		res.Header.Set(HeaderEtag, `"a1"`)
+		res.Header.Set(HeaderLastModified, lastModified)
+		res.Header.Set("Content-Type", "text/plain")
+		res.Header.Set("Content-Length", "10")
+		return res
+	}
+	newRequest := func(ra string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderRange, ra)
+		return req
+	}
+	readBody := func(res *http.Response) string {
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("Single range", func(t *testing.T) {
+		res := RangeResponse(newRequest("bytes=2-5"), newResponse())
+		assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+		assert.Equal(t, "206 Partial Content", res.Status)
+		assert.Equal(t, "bytes 2-5/10", res.Header.Get("Content-Range"))
+		assert.Equal(t, "4", res.Header.Get("Content-Length"))
+		assert.Equal(t, "2345", readBody(res))
+	})
+
+	t.Run("Single range streamed", func(t *testing.T) {
+		// The body beyond the range is not read.
+		res := newResponse()
+		res.Body = io.NopCloser(io.MultiReader(strings.NewReader("0123456789"),
+			iotest.ErrReader(errors.New("read beyond range"))))
+		res = RangeResponse(newRequest("bytes=2-5"), res)
+		assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+		assert.Equal(t, int64(4), res.ContentLength)
+		assert.Equal(t, "2345", readBody(res))
+	})
+
+	t.Run("Single range of unknown size", func(t *testing.T) {
+		res := newResponse()
+		res.ContentLength = -1
+		res = RangeResponse(newRequest("bytes=-3"), res)
+		assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+		assert.Equal(t, "bytes 7-9/10", res.Header.Get("Content-Range"))
+		assert.Equal(t, "789", readBody(res))
+	})
+
+	t.Run("Multiple ranges", func(t *testing.T) {
+		res := RangeResponse(newRequest("bytes=0-1, 8-"), newResponse())
+		assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+
+		mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+
+		mr := multipart.NewReader(res.Body, params["boundary"])
+		for _, want := range []struct{ contentRange, body string }{
+			{"bytes 0-1/10", "01"},
+			{"bytes 8-9/10", "89"},
+		} {
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+			assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+			body, err := io.ReadAll(part)
+			require.NoError(t, err)
+			assert.Equal(t, want.body, string(body))
+		}
+		_, err = mr.NextPart()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Range not satisfiable", func(t *testing.T) {
+		res := RangeResponse(newRequest("bytes=20-"), newResponse())
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+		assert.Equal(t, "bytes */10", res.Header.Get("Content-Range"))
+	})
+
+	t.Run("Invalid range", func(t *testing.T) {
+		res := RangeResponse(newRequest("bytes=5-1"), newResponse())
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "0123456789", readBody(res))
+	})
+
+	t.Run("If-Range", func(t *testing.T) {
+		testCases := []struct {
+			ifRange string
+			want    int
+		}{
+			{`"a1"`, http.StatusPartialContent},
+			{`"a2"`, http.StatusOK},
+			{`W/"a1"`, http.StatusOK},
+			{lastModified, http.StatusPartialContent},
+			{formatTime(currentTime().Add(seconds(-10))), http.StatusOK},
+		}
+		for _, tc := range testCases {
+			req := newRequest("bytes=0-1")
+			req.Header.Set(HeaderIfRange, tc.ifRange)
+			assert.Equal(t, tc.want, RangeResponse(req, newResponse()).StatusCode, tc.ifRange)
+		}
+	})
+
+	t.Run("Non complete response", func(t *testing.T) {
+		res := newResponse()
+		res.StatusCode = http.StatusNotFound
+		assert.Equal(t, http.StatusNotFound, RangeResponse(newRequest("bytes=0-1"), res).StatusCode)
+	})
+}
+
+func TestSelectRange(t *testing.T) {
+	header := http.Header{}
+	header.Set("Etag", `"a1"`)
+
+	tests := []struct {
+		name    string
+		header  map[string]string
+		want    ByteRange
+		wantSts int
+	}{
+		{"no range", nil, ByteRange{0, 10}, http.StatusOK},
+		{"single range", map[string]string{"Range": "bytes=2-5"}, ByteRange{2, 4}, http.StatusPartialContent},
+		{"suffix range", map[string]string{"Range": "bytes=-3"}, ByteRange{7, 3}, http.StatusPartialContent},
+		{"multiple ranges", map[string]string{"Range": "bytes=0-1,4-5"}, ByteRange{0, 10}, http.StatusOK},
+		{"invalid range", map[string]string{"Range": "bytes=a-b"}, ByteRange{0, 10}, http.StatusOK},
+		{"no overlap", map[string]string{"Range": "bytes=20-"}, ByteRange{}, http.StatusRequestedRangeNotSatisfiable},
+		{"if-range match", map[string]string{"Range": "bytes=2-5", "If-Range": `"a1"`}, ByteRange{2, 4}, http.StatusPartialContent},
+		{"if-range mismatch", map[string]string{"Range": "bytes=2-5", "If-Range": `"b2"`}, ByteRange{0, 10}, http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "http://example.com", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			ra, status := SelectRange(req, header, 10)
+			assert.Equal(t, tc.wantSts, status)
+			assert.Equal(t, tc.want, ra)
+		})
+	}
+}
+
+func TestParseContentRangeSize(t *testing.T) {
+	assert.Equal(t, int64(146515), ParseContentRangeSize("bytes 0-1023/146515"))
+	assert.Equal(t, int64(10), ParseContentRangeSize("bytes */10"))
+	assert.Equal(t, int64(-1), ParseContentRangeSize("bytes 0-1023/*"))
+	assert.Equal(t, int64(-1), ParseContentRangeSize("items 0-1/10"))
+	assert.Equal(t, int64(-1), ParseContentRangeSize(""))
+}
+
+func TestCloseBody(t *testing.T) {
+	newTee := func(limit int64) (*teeBody, *strings.Reader, *string) {
+		r := strings.NewReader(strings.Repeat("x", 1<<20))
+		result := ""
+		return &teeBody{
+			body:  io.NopCloser(r),
+			limit: limit,
+			store: func(body []byte) { result = "stored" },
+			abort: func(err error) { result = err.Error() },
+		}, r, &result
+	}
+
+	// A body being stored is drained, so that it is stored completely.
+	tee, r, result := newTee(2 << 20)
+	require.NoError(t, closeBody(tee))
+	assert.Equal(t, 0, r.Len())
+	assert.Equal(t, "stored", *result)
+
+	// Once storing is aborted or finished, the body is closed without draining it.
+	tee, r, result = newTee(1 << 10)
+	require.NoError(t, closeBody(tee))
+	assert.Positive(t, r.Len())
+	assert.Equal(t, errBodyTooLarge.Error(), *result)
+
+	tee, r, result = newTee(2 << 20)
+	tee.done = true
+	require.NoError(t, closeBody(tee))
+	assert.Equal(t, 1<<20, r.Len())
+	assert.Empty(t, *result)
+}
@@ -0,0 +1,379 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Rule is a declarative cache rule. A rule applies its actions to the requests, and their
+// responses, matching all of its conditions. Rules are evaluated in order; the action of a
+// later matching rule overrides the same action of an earlier one, while header edits add
+// up. Rules take precedence over Timeouts, KeyPolicies and Exclude.
+type Rule struct {
+	// Name is the name of the rule, used to explain which rules apply to a request.
+	Name string `yaml:"name" json:"name"`
+
+	// Match holds the conditions of the rule. A rule without conditions matches every request.
+	Match RuleMatch `yaml:"match" json:"match"`
+
+	// TTL is the TTL of the stored responses. Overrides the path and negative TTLs.
+	TTL time.Duration `yaml:"ttl" json:"ttl"`
+
+	// Bypass specifies that matching requests bypass the cache. A rule with response
+	// conditions does not bypass the cache, but prevents matching responses from being stored.
+	Bypass bool `yaml:"bypass" json:"bypass"`
+
+	// ForceCache specifies that matching responses are stored, regardless of their cache
+	// control directives, status code or content, and served as fresh for their TTL.
+	ForceCache bool `yaml:"force_cache" json:"force_cache"`
+
+	// Key is the cache key policy of matching requests. Its path is ignored.
+	Key *KeyPolicy `yaml:"key" json:"key,omitempty"`
+
+	// CacheControl overrides the Cache-Control header of matching origin responses
+	// before they are stored and served downstream.
+	CacheControl string `yaml:"cache_control" json:"cache_control"`
+
+	// BrowserCacheControl overrides the Cache-Control header sent downstream with matching
+	// responses served from, or stored in, the cache, see HttpCacheConfig.BrowserCacheControl.
+	BrowserCacheControl string `yaml:"browser_cache_control" json:"browser_cache_control"`
+
+	// RequestHeaders edits the header of matching requests forwarded upstream.
+	RequestHeaders *HeaderEdits `yaml:"request_headers" json:"request_headers,omitempty"`
+
+	// ResponseHeaders edits the header of matching responses served downstream.
+	ResponseHeaders *HeaderEdits `yaml:"response_headers" json:"response_headers,omitempty"`
+
+	// invalid marks a rule with invalid conditions, which never matches.
+	invalid bool
+}
+
+// RuleMatch holds the conditions of a rule. Host, path, content type and the values of
+// query parameters, header fields and cookies are matched by regex. Header fields, query
+// parameters and cookies must be present. Response conditions are the status codes and
+// the content type; request actions of a rule with response conditions are ignored.
+type RuleMatch struct {
+	// Methods holds the request methods, e.g. GET.
+	Methods []string `yaml:"methods" json:"methods,omitempty"`
+
+	// Host is the request host. String or Regex.
+	Host string `yaml:"host" json:"host,omitempty"`
+
+	// Path is the request path. String or Regex.
+	Path string `yaml:"path" json:"path,omitempty"`
+
+	// Query holds the query parameters by name.
+	Query map[string]string `yaml:"query" json:"query,omitempty"`
+
+	// Headers holds the request header fields by name.
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+
+	// Cookies holds the request cookies by name.
+	Cookies map[string]string `yaml:"cookies" json:"cookies,omitempty"`
+
+	// Status holds the response status codes (e.g. 404) or classes (e.g. 5xx).
+	Status []string `yaml:"status" json:"status,omitempty"`
+
+	// ContentType is the response content type. String or Regex.
+	ContentType string `yaml:"content_type" json:"content_type,omitempty"`
+
+	// host, path, query, headers, cookies and contentType hold the compiled regexes.
+	host, path, contentType *regexp.Regexp
+	query, headers, cookies map[string]*regexp.Regexp
+
+	// status holds the parsed status ranges.
+	status [][2]int
+}
+
+// HeaderEdits holds the edits of a header. Fields are removed first, then set, then added.
+type HeaderEdits struct {
+	// Set holds the header fields replacing existing fields of the same name.
+	Set map[string]string `yaml:"set" json:"set,omitempty"`
+
+	// Add holds the header fields added to existing fields of the same name.
+	Add map[string]string `yaml:"add" json:"add,omitempty"`
+
+	// Remove holds the names of the header fields removed.
+	Remove []string `yaml:"remove" json:"remove,omitempty"`
+}
+
+// apply applies the edits to the header.
+func (e *HeaderEdits) apply(h http.Header) {
+	for _, k := range e.Remove {
+		h.Del(headerName(k))
+	}
+	for k, v := range e.Set {
+		h.Set(headerName(k), v)
+	}
+	for k, v := range e.Add {
+		h.Add(headerName(k), v)
+	}
+}
+
+// compile compiles the conditions of the rule.
+func (m *RuleMatch) compile() error {
+	var err error
+	if m.host, err = compileOptional(m.Host); err != nil {
+		return err
+	}
+	if m.path, err = compileOptional(m.Path); err != nil {
+		return err
+	}
+	if m.contentType, err = compileOptional(m.ContentType); err != nil {
+		return err
+	}
+	if m.query, err = compileAll(m.Query); err != nil {
+		return err
+	}
+	if m.headers, err = compileAll(m.Headers); err != nil {
+		return err
+	}
+	if m.cookies, err = compileAll(m.Cookies); err != nil {
+		return err
+	}
+	m.status = make([][2]int, len(m.Status))
+	for i, s := range m.Status {
+		from, to, err := parseStatusRange(s)
+		if err != nil {
+			return err
+		}
+		m.status[i] = [2]int{from, to}
+	}
+	return nil
+}
+
+// compileOptional compiles the regex, if specified.
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// compileAll compiles the regexes by name.
+func compileAll(exprs map[string]string) (map[string]*regexp.Regexp, error) {
+	compiled := make(map[string]*regexp.Regexp, len(exprs))
+	for name, expr := range exprs {
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		compiled[name] = r
+	}
+	return compiled, nil
+}
+
+// hasResponseConditions checks if the rule matches on responses.
+func (m *RuleMatch) hasResponseConditions() bool {
+	return len(m.status) > 0 || m.contentType != nil
+}
+
+// matchesRequest checks if the request satisfies the request conditions.
+func (m *RuleMatch) matchesRequest(req *http.Request) bool {
+	if len(m.Methods) > 0 && !containsFold(m.Methods, req.Method) {
+		return false
+	}
+	if m.host != nil && !m.host.MatchString(req.Host) {
+		return false
+	}
+	if m.path != nil && !m.path.MatchString(req.URL.Path) {
+		return false
+	}
+	query := req.URL.Query()
+	for name, r := range m.query {
+		if !matchesValue(r, query[name]) {
+			return false
+		}
+	}
+	for name, r := range m.headers {
+		if !matchesValue(r, req.Header.Values(headerName(name))) {
+			return false
+		}
+	}
+	for name, r := range m.cookies {
+		cookie, err := req.Cookie(name)
+		if err != nil || !r.MatchString(cookie.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesResponse checks if the response satisfies the response conditions.
+func (m *RuleMatch) matchesResponse(res *http.Response) bool {
+	if len(m.status) > 0 {
+		matched := false
+		for _, s := range m.status {
+			matched = matched || (s[0] <= res.StatusCode && res.StatusCode <= s[1])
+		}
+		if !matched {
+			return false
+		}
+	}
+	return m.contentType == nil || m.contentType.MatchString(res.Header.Get("Content-Type"))
+}
+
+// matchesValue checks if any of the values matches the regex.
+func matchesValue(r *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if r.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold checks if the list contains the string, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerName returns the header field name of a configured name,
+// where underscores stand for dashes, e.g. x_requested_with.
+func headerName(name string) string {
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+// RuleActions holds the combined actions of the rules matching a request or response.
+type RuleActions struct {
+	// Rules holds the names of the matching rules.
+	Rules []string
+
+	// TTL is the TTL of stored responses, or 0 if not set by a rule.
+	TTL time.Duration
+
+	// Bypass and ForceCache hold the bypass and force-cache actions.
+	Bypass, ForceCache bool
+
+	// Key is the cache key policy, if set by a rule.
+	Key *KeyPolicy
+
+	// CacheControl overrides the Cache-Control header of responses, if not empty.
+	CacheControl string
+
+	// BrowserCacheControl overrides the Cache-Control header sent downstream, if not empty.
+	BrowserCacheControl string
+
+	// RequestHeaders and ResponseHeaders hold the header edits in rule order.
+	RequestHeaders, ResponseHeaders []*HeaderEdits
+}
+
+// add adds the actions of the matching rule. Request actions are only
+// added for rules without response conditions.
+func (a *RuleActions) add(r *Rule) {
+	a.Rules = append(a.Rules, r.Name)
+	if r.TTL > 0 {
+		a.TTL = r.TTL
+	}
+	if r.Bypass {
+		a.Bypass, a.ForceCache = true, false
+	}
+	if r.ForceCache {
+		a.Bypass, a.ForceCache = false, true
+	}
+	if r.CacheControl != "" {
+		a.CacheControl = r.CacheControl
+	}
+	if r.BrowserCacheControl != "" {
+		a.BrowserCacheControl = r.BrowserCacheControl
+	}
+	if r.ResponseHeaders != nil {
+		a.ResponseHeaders = append(a.ResponseHeaders, r.ResponseHeaders)
+	}
+	if r.Match.hasResponseConditions() {
+		return
+	}
+	if r.Key != nil {
+		a.Key = r.Key
+	}
+	if r.RequestHeaders != nil {
+		a.RequestHeaders = append(a.RequestHeaders, r.RequestHeaders)
+	}
+}
+
+// ttl returns the TTL set by the rules, or the given default TTL.
+func (a *RuleActions) ttl(def time.Duration) time.Duration {
+	if a.TTL > 0 {
+		return a.TTL
+	}
+	return def
+}
+
+// EditRequestHeader applies the request header edits to the header.
+func (a *RuleActions) EditRequestHeader(h http.Header) {
+	for _, e := range a.RequestHeaders {
+		e.apply(h)
+	}
+}
+
+// EditResponseHeader applies the response header edits to the header.
+func (a *RuleActions) EditResponseHeader(h http.Header) {
+	for _, e := range a.ResponseHeaders {
+		e.apply(h)
+	}
+}
+
+// RequestRules returns the actions of the rules matching the request. Rules with
+// response conditions are skipped, as they cannot be evaluated before the response.
+func (c *HttpCache) RequestRules(req *http.Request) RuleActions {
+	return c.rules(req, nil)
+}
+
+// ResponseRules returns the actions of the rules matching the request and its response.
+func (c *HttpCache) ResponseRules(req *http.Request, res *http.Response) RuleActions {
+	return c.rules(req, res)
+}
+
+// rules returns the actions of the rules matching the request and, if not nil, the response.
+func (c *HttpCache) rules(req *http.Request, res *http.Response) RuleActions {
+	var actions RuleActions
+	config := c.loadConfig()
+	for i := range config.Rules {
+		r := &config.Rules[i]
+		if r.invalid || !r.Match.matchesRequest(req) {
+			continue
+		}
+		if r.Match.hasResponseConditions() && (res == nil || !r.Match.matchesResponse(res)) {
+			continue
+		}
+		actions.add(r)
+	}
+	return actions
+}
+
+// RewriteCacheControl overrides the Cache-Control header of the response to
+// the request, if a matching rule says so.
+func (c *HttpCache) RewriteCacheControl(req *http.Request, res *http.Response) {
+	if actions := c.ResponseRules(req, res); actions.CacheControl != "" {
+		res.Header.Set(HeaderCacheControl, actions.CacheControl)
+	}
+}
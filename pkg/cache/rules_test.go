@@ -0,0 +1,199 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		match    RuleMatch
+		method   string
+		url      string
+		header   http.Header
+		status   int
+		expected bool
+	}{
+		{"empty", RuleMatch{}, "GET", "http://example.com/", nil, 200, true},
+		{"method", RuleMatch{Methods: []string{"get", "HEAD"}}, "GET", "http://example.com/", nil, 200, true},
+		{"method mismatch", RuleMatch{Methods: []string{"HEAD"}}, "GET", "http://example.com/", nil, 200, false},
+		{"host", RuleMatch{Host: `^(www\.)?example\.com$`}, "GET", "http://www.example.com/", nil, 200, true},
+		{"host mismatch", RuleMatch{Host: `^example\.com$`}, "GET", "http://example.org/", nil, 200, false},
+		{"path", RuleMatch{Path: "^/api/"}, "GET", "http://example.com/api/v1", nil, 200, true},
+		{"query", RuleMatch{Query: map[string]string{"preview": "^(1|true)$"}},
+			"GET", "http://example.com/?preview=true", nil, 200, true},
+		{"query missing", RuleMatch{Query: map[string]string{"preview": ""}},
+			"GET", "http://example.com/?id=1", nil, 200, false},
+		{"header", RuleMatch{Headers: map[string]string{"x_requested_with": "XMLHttpRequest"}},
+			"GET", "http://example.com/", http.Header{"X-Requested-With": {"XMLHttpRequest"}}, 200, true},
+		{"header missing", RuleMatch{Headers: map[string]string{"X-Debug": ""}},
+			"GET", "http://example.com/", nil, 200, false},
+		{"cookie", RuleMatch{Cookies: map[string]string{"session": "."}},
+			"GET", "http://example.com/", http.Header{"Cookie": {"theme=dark; session=abc"}}, 200, true},
+		{"cookie missing", RuleMatch{Cookies: map[string]string{"session": ""}},
+			"GET", "http://example.com/", http.Header{"Cookie": {"theme=dark"}}, 200, false},
+		{"status class", RuleMatch{Status: []string{"404", "5xx"}}, "GET", "http://example.com/", nil, 503, true},
+		{"status mismatch", RuleMatch{Status: []string{"404"}}, "GET", "http://example.com/", nil, 200, false},
+		{"content type", RuleMatch{ContentType: "^image/"}, "GET", "http://example.com/", nil, 200, true},
+		{"all", RuleMatch{Methods: []string{"GET"}, Path: "^/img", ContentType: "^image/", Status: []string{"2xx"}},
+			"GET", "http://example.com/img/1.png", nil, 200, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.match.compile())
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			require.NoError(t, err)
+			if tt.header != nil {
+				req.Header = tt.header
+			}
+			res := &http.Response{StatusCode: tt.status, Header: http.Header{"Content-Type": {"image/png"}}}
+			assert.Equal(t, tt.expected, tt.match.matchesRequest(req) && tt.match.matchesResponse(res))
+		})
+	}
+}
+
+func TestRuleActions(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Rules: []Rule{
+			{Name: "all", TTL: time.Minute, ResponseHeaders: &HeaderEdits{Set: map[string]string{"X-Rule": "all"}}},
+			{Name: "invalid", Match: RuleMatch{Path: "(", Status: []string{"200"}}, Bypass: true},
+			{Name: "api", Match: RuleMatch{Path: "^/api/"}, Bypass: true,
+				Key:            &KeyPolicy{IgnoreQuery: []string{"*"}},
+				RequestHeaders: &HeaderEdits{Remove: []string{"Cookie"}}},
+			{Name: "api public", Match: RuleMatch{Path: "^/api/public/"}, ForceCache: true, TTL: time.Hour},
+			{Name: "errors", Match: RuleMatch{Status: []string{"5xx"}}, Bypass: true,
+				Key:             &KeyPolicy{IgnoreScheme: true},
+				ResponseHeaders: &HeaderEdits{Add: map[string]string{"X-Rule": "errors"}}},
+		},
+	}, p)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/api/public/1?id=1", nil)
+	actions := c.RequestRules(req)
+	assert.Equal(t, []string{"all", "api", "api public"}, actions.Rules)
+	assert.Equal(t, time.Hour, actions.TTL)
+	assert.True(t, actions.ForceCache)
+	assert.False(t, actions.Bypass)
+	assert.Len(t, actions.RequestHeaders, 1)
+	assert.Equal(t, "kache-http://example.com/api/public/1", c.KeyFromRequest(req).String())
+
+	// Request actions of rules with response conditions are ignored.
+	actions = c.ResponseRules(req, &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}})
+	assert.Equal(t, []string{"all", "api", "api public", "errors"}, actions.Rules)
+	assert.True(t, actions.Bypass)
+	assert.False(t, actions.ForceCache)
+	require.NotNil(t, actions.Key)
+	assert.False(t, actions.Key.IgnoreScheme)
+
+	header := http.Header{"X-Rule": {"origin"}}
+	actions.EditResponseHeader(header)
+	assert.Equal(t, []string{"all", "errors"}, header.Values("X-Rule"))
+
+	req, _ = http.NewRequest(http.MethodGet, "http://example.com/home", nil)
+	assert.Equal(t, []string{"all"}, c.RequestRules(req).Rules)
+	assert.Equal(t, "", c.BypassReason(req))
+	req, _ = http.NewRequest(http.MethodGet, "http://example.com/api/me", nil)
+	assert.Equal(t, BypassRule, c.BypassReason(req))
+}
+
+func TestRulesStore(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Strict:   true,
+		Timeouts: []Timeout{{Path: "^/", TTL: time.Minute}},
+		Exclude:  &Exclude{Content: []Content{{Type: "^video/"}}},
+		Rules: []Rule{
+			{Name: "videos", Match: RuleMatch{ContentType: "^video/"}, ForceCache: true, TTL: time.Hour},
+			{Name: "html", Match: RuleMatch{ContentType: "^text/html"}, Bypass: true},
+		},
+	}, p)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	store := func(contentType, cacheControl string) (*LookupRequest, *http.Response) {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com/"+strings.ReplaceAll(contentType, "/", "-"), nil)
+		lookup := c.NewLookup(req, time.Now())
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Content-Type":  {contentType},
+				"Cache-Control": {cacheControl},
+				"Date":          {time.Now().UTC().Format(http.TimeFormat)},
+			},
+		}
+		return lookup, res
+	}
+
+	// A forced response is stored despite its directives and excluded content type,
+	// and served as fresh for the rule TTL.
+	lookup, res := store("video/mp4", "no-cache, no-store")
+	assert.Equal(t, "", c.UnstorableReason(lookup, res))
+	c.StoreResponse(ctx, lookup, res, time.Now())
+	cached := c.FetchResponse(ctx, *lookup)
+	assert.Equal(t, EntryOk, cached.Status)
+	entry := c.fetchEntry(ctx, lookup.Key.String())
+	require.NotNil(t, entry)
+	assert.True(t, entry.Forced)
+	assert.Equal(t, time.Hour, entry.TTL)
+
+	lookup, res = store("text/html", "max-age=60")
+	assert.Equal(t, ReasonRule, c.UnstorableReason(lookup, res))
+
+	// The path TTL applies if no rule sets a TTL.
+	lookup, res = store("text/plain", "max-age=60")
+	assert.Equal(t, "", c.UnstorableReason(lookup, res))
+	c.StoreResponse(ctx, lookup, res, time.Now())
+	entry = c.fetchEntry(ctx, lookup.Key.String())
+	require.NotNil(t, entry)
+	assert.False(t, entry.Forced)
+	assert.Equal(t, time.Minute, entry.TTL)
+}
+
+func TestRewriteCacheControl(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		Rules: []Rule{{Match: RuleMatch{Path: "^/static/"}, CacheControl: "public, max-age=86400"}},
+	}, p)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/static/app.js", nil)
+	res := &http.Response{Header: http.Header{"Cache-Control": {"no-cache"}}}
+	c.RewriteCacheControl(req, res)
+	assert.Equal(t, "public, max-age=86400", res.Header.Get("Cache-Control"))
+
+	req, _ = http.NewRequest(http.MethodGet, "http://example.com/index.html", nil)
+	res = &http.Response{Header: http.Header{"Cache-Control": {"no-cache"}}}
+	c.RewriteCacheControl(req, res)
+	assert.Equal(t, "no-cache", res.Header.Get("Cache-Control"))
+}
@@ -0,0 +1,142 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errBodyTooLarge indicates that a streamed response body exceeds the maximum body size.
+var errBodyTooLarge = errors.New("response body exceeds max body size")
+
+// StreamResponse stores the response in the cache while its body is streamed to the client.
+// The response body is replaced by a body that copies every byte read into a buffer. Once
+// the body has been read completely, the buffered response is stored in the cache. Storing
+// is aborted, if the body exceeds the maximum body size, reading the body fails, or the body
+// is closed before it has been read completely, e.g. because the client disconnected.
+func (c *HttpCache) StreamResponse(ctx context.Context, lookup *LookupRequest,
+	response *http.Response, responseTime time.Time) {
+	limit := c.MaxBodySize()
+	if response.ContentLength > limit {
+		log.Debug().Str("cache-key", lookup.Key.String()).Err(errBodyTooLarge).Msg("Response not stored")
+		return
+	}
+
+	// The response header is captured now, as the response served
+	// downstream may be modified while its body is streamed.
+	stored := *response
+	stored.Header = response.Header.Clone()
+
+	response.Body = &teeBody{
+		body:  response.Body,
+		limit: limit,
+		store: func(body []byte) {
+			stored.Body = io.NopCloser(bytes.NewReader(body))
+			stored.ContentLength = int64(len(body))
+			stored.TransferEncoding = nil
+			c.StoreResponse(ctx, lookup, &stored, responseTime)
+		},
+		abort: func(err error) {
+			log.Debug().Str("cache-key", lookup.Key.String()).Err(err).Msg("Aborted storing response")
+		},
+	}
+}
+
+// teeBody is a response body that buffers the bytes read from the underlying body.
+// The buffered body is passed to store, once the underlying body is read completely,
+// or dropped, if storing is aborted.
+type teeBody struct {
+	body  io.ReadCloser
+	limit int64
+
+	store func(body []byte)
+	abort func(err error)
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	done bool
+}
+
+// Read reads from the underlying body and buffers the bytes read.
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.body.Read(p)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return n, err
+	}
+	if n > 0 {
+		if int64(t.buf.Len()+n) > t.limit {
+			t.finish(errBodyTooLarge)
+			return n, err
+		}
+		t.buf.Write(p[:n])
+	}
+	switch {
+	case err == io.EOF:
+		t.finish(nil)
+	case err != nil:
+		t.finish(err)
+	}
+	return n, err
+}
+
+// Close closes the underlying body. Storing is aborted,
+// if the body has not been read completely.
+func (t *teeBody) Close() error {
+	t.mu.Lock()
+	if !t.done {
+		t.finish(io.ErrUnexpectedEOF)
+	}
+	t.mu.Unlock()
+	return t.body.Close()
+}
+
+// storing returns true, while the body is being stored, i.e. it has
+// neither been stored completely nor storing has been aborted.
+func (t *teeBody) storing() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.done
+}
+
+// finish stores the buffered body, or aborts storing if err is not nil.
+// It must be called with the lock held.
+func (t *teeBody) finish(err error) {
+	t.done = true
+	if err != nil {
+		t.abort(err)
+	} else {
+		t.store(t.buf.Bytes())
+	}
+	t.buf = bytes.Buffer{}
+}
@@ -0,0 +1,117 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamResponse(t *testing.T) {
+	testCases := []struct {
+		name          string
+		maxBodySize   int64
+		contentLength int64
+		read          func(body io.Reader)
+		stored        bool
+	}{
+		{
+			name:          "body read completely",
+			contentLength: 10,
+			read:          func(body io.Reader) { _, _ = io.ReadAll(body) },
+			stored:        true,
+		},
+		{
+			name:          "unknown content length",
+			contentLength: -1,
+			read:          func(body io.Reader) { _, _ = io.ReadAll(body) },
+			stored:        true,
+		},
+		{
+			name:          "body closed early",
+			contentLength: 10,
+			read:          func(body io.Reader) { _, _ = body.Read(make([]byte, 4)) },
+			stored:        false,
+		},
+		{
+			name:          "content length exceeds limit",
+			maxBodySize:   4,
+			contentLength: 10,
+			read:          func(body io.Reader) { _, _ = io.ReadAll(body) },
+			stored:        false,
+		},
+		{
+			name:          "streamed body exceeds limit",
+			maxBodySize:   4,
+			contentLength: -1,
+			read:          func(body io.Reader) { _, _ = io.ReadAll(body) },
+			stored:        false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, _ := provider.NewSimpleCache(nil)
+			c, err := NewHttpCache(&HttpCacheConfig{MaxBodySize: tc.maxBodySize}, p)
+			require.NoError(t, err)
+
+			req, _ := http.NewRequest("GET", "http://example.com/stream", nil)
+			lookup := NewLookupRequest(req, currentTime(), true)
+
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				ProtoMajor: 1, ProtoMinor: 1,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=3600"},
+					"Date":          []string{formatTime(currentTime())},
+				},
+				ContentLength: tc.contentLength,
+				Body:          io.NopCloser(strings.NewReader("0123456789")),
+			}
+			c.StreamResponse(context.Background(), lookup, res, currentTime())
+
+			// Nothing is stored before the body is read.
+			assert.Equal(t, EntryInvalid, c.FetchResponse(context.Background(), *lookup).Status)
+
+			tc.read(res.Body)
+			require.NoError(t, res.Body.Close())
+
+			result := c.FetchResponse(context.Background(), *lookup)
+			if !tc.stored {
+				assert.Equal(t, EntryInvalid, result.Status)
+				return
+			}
+			require.Equal(t, EntryOk, result.Status)
+			body, err := io.ReadAll(result.Response().Body)
+			require.NoError(t, err)
+			assert.Equal(t, "0123456789", string(body))
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// EdgeCacheControl returns the cache directives of the response which govern the cache,
+// i.e. the Cache-Control directives, overlaid by the Surrogate-Control directives. A
+// Surrogate-Control max-age takes precedence over the freshness directives of Cache-Control
+// (max-age, s-maxage and no-cache), which are then left to the browser. A grace period,
+// e.g. 'max-age=300+60', is the stale-if-error window. The no-store and private directives
+// of either header are honored. Surrogate-Control directives targeted at a specific
+// surrogate, e.g. 'max-age=60;edge1', are ignored.
+func EdgeCacheControl(header http.Header) ResponseCacheControl {
+	cc := ParseResponseCacheControl(header.Get(HeaderCacheControl))
+	for _, directive := range splitDirectives(strings.Join(header.Values(HeaderSurrogateControl), ",")) {
+		if isTargeted(directive) {
+			continue
+		}
+		dir, arg := splitDirective(directive)
+		switch strings.ToLower(dir) {
+		case "no-store":
+			cc.NoStore = true
+		case "max-age":
+			age, grace, ok := strings.Cut(arg, "+")
+			if maxAge := parseDuration(age); maxAge >= 0 {
+				cc.MaxAge, cc.MustValidate = maxAge, false
+			}
+			if ok {
+				cc.StaleIfError = parseDuration(grace)
+			}
+		}
+	}
+	return cc
+}
+
+// isTargeted checks if the Surrogate-Control directive is targeted at a specific
+// surrogate, i.e. it carries a device token after any quoted argument.
+func isTargeted(directive string) bool {
+	return strings.LastIndexByte(directive, ';') > strings.LastIndexByte(directive, '"')
+}
+
+// BrowserCacheControl returns the Cache-Control header sent downstream with the response to
+// the request, if served from, or stored in the cache. The browser Cache-Control of the
+// matching rules takes precedence over the configured one. Returns an empty string, if the
+// Cache-Control header of the response is left as is.
+func (c *HttpCache) BrowserCacheControl(req *http.Request, res *http.Response) string {
+	if actions := c.ResponseRules(req, res); actions.BrowserCacheControl != "" {
+		return actions.BrowserCacheControl
+	}
+	config := c.loadConfig()
+	return config.BrowserCacheControl
+}
@@ -0,0 +1,99 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEdgeCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		surrogate    []string
+		maxAge       time.Duration
+		mustValidate bool
+		noStore      bool
+		staleIfError time.Duration
+	}{
+		{"cache-control only", "max-age=60, no-cache", nil, time.Minute, true, false, -1},
+		{"s-maxage", "max-age=60, s-maxage=300", nil, 5 * time.Minute, false, false, -1},
+		{"surrogate max-age", "max-age=60, no-cache", []string{"max-age=86400"}, 24 * time.Hour, false, false, -1},
+		{"surrogate grace", "", []string{`content="ESI/1.0", max-age=300+60`}, 5 * time.Minute, false, false, time.Minute},
+		{"surrogate no-store", "max-age=60", []string{"no-store"}, time.Minute, false, true, -1},
+		{"private kept", "private", []string{"max-age=300"}, 5 * time.Minute, false, true, -1},
+		{"targeted ignored", "max-age=60", []string{"max-age=300;edge1", `content="ESI/1.0";edge1`}, time.Minute, false, false, -1},
+		{"multiple fields", "", []string{"max-age=300;edge1", "max-age=600"}, 10 * time.Minute, false, false, -1},
+		{"invalid max-age", "max-age=60", []string{"max-age=x"}, time.Minute, false, false, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{HeaderCacheControl: {tt.cacheControl}, HeaderSurrogateControl: tt.surrogate}
+			cc := EdgeCacheControl(header)
+			assert.Equal(t, tt.maxAge, cc.MaxAge)
+			assert.Equal(t, tt.mustValidate, cc.MustValidate)
+			assert.Equal(t, tt.noStore, cc.NoStore)
+			assert.Equal(t, tt.staleIfError, cc.StaleIfError)
+		})
+	}
+}
+
+func TestSurrogateControlCacheable(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{Strict: true}, p)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	lookup := c.NewLookup(req, time.Now())
+
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		HeaderSurrogateControl: {"max-age=86400"},
+	}}
+	assert.Equal(t, "", c.UnstorableReason(lookup, res))
+
+	res.Header.Set(HeaderSurrogateControl, "no-store")
+	assert.Equal(t, ReasonNoStore, c.UnstorableReason(lookup, res))
+}
+
+func TestBrowserCacheControl(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{
+		BrowserCacheControl: "max-age=60",
+		Rules: []Rule{
+			{Match: RuleMatch{Path: "^/assets/"}, BrowserCacheControl: "public, max-age=31536000, immutable"},
+		},
+	}, p)
+	require.NoError(t, err)
+
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/news", nil)
+	assert.Equal(t, "max-age=60", c.BrowserCacheControl(req, res))
+	req, _ = http.NewRequest(http.MethodGet, "http://example.com/assets/app.js", nil)
+	assert.Equal(t, "public, max-age=31536000, immutable", c.BrowserCacheControl(req, res))
+}
@@ -0,0 +1,94 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultTagHeader is the default response header holding the tags of a response.
+const DefaultTagHeader = "Surrogate-Key"
+
+// TagHeader returns the name of the response header holding the tags of a response.
+func (c *HttpCache) TagHeader() string {
+	config := c.loadConfig()
+	if config.TagHeader == "" {
+		return DefaultTagHeader
+	}
+	return config.TagHeader
+}
+
+// ParseTags parses the tags from a tag header value. Tags are separated by
+// spaces, e.g. 'Surrogate-Key: product-123 category-7'; commas are accepted
+// as separators as well, e.g. 'Cache-Tag: product-123,category-7'.
+func ParseTags(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	})
+	tags := make([]string, 0, len(fields))
+	seen := make(map[string]struct{}, len(fields))
+	for _, tag := range fields {
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// primaryTagPrefix prefixes the internal tag indexing all keys derived from a primary key.
+const primaryTagPrefix = "kache-primary:"
+
+// primaryTag returns the internal tag indexing all keys derived from the primary key of
+// the key, i.e. the keys of its variants, slices and the responses stored per key policy
+// fields, request body or credential, see Key.String. Keys are indexed by their primary
+// tag when stored, so that derived keys are found without enumerating the keyspace.
+func primaryTag(key string) string {
+	primary, _, _ := strings.Cut(key, "#")
+	return primaryTagPrefix + primary
+}
+
+// tag indexes the key by the given tags and by its primary tag.
+func (c *HttpCache) tag(ctx context.Context, key string, tags []string, ttl time.Duration) {
+	c.cache.Tag(ctx, key, append(slices.Clip(tags), primaryTag(key)), ttl)
+}
+
+// PurgeTags purges all entries tagged with any of the given tags from the cache.
+// It returns the purged keys.
+func (c *HttpCache) PurgeTags(ctx context.Context, tags []string) ([]string, error) {
+	var (
+		purged []string
+		errs   []error
+	)
+	for _, tag := range tags {
+		keys, err := c.cache.PurgeTag(ctx, tag)
+		purged = append(purged, keys...)
+		errs = append(errs, err)
+	}
+	return purged, errors.Join(errs...)
+}
@@ -0,0 +1,82 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTags(t *testing.T) {
+	assert.Equal(t, []string{"product-123", "category-7"}, ParseTags("product-123 category-7"))
+	assert.Equal(t, []string{"product-123", "category-7"}, ParseTags(" product-123,category-7, product-123 "))
+	assert.Empty(t, ParseTags(""))
+}
+
+func TestStorePurgeTags(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := NewHttpCache(&HttpCacheConfig{TagHeader: "Cache-Tag"}, p)
+	require.NoError(t, err)
+	assert.Equal(t, "Cache-Tag", c.TagHeader())
+
+	store := func(url, tags string, header http.Header) *LookupRequest {
+		req, _ := http.NewRequest("GET", url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		lookup := NewLookupRequest(req, currentTime(), true)
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Cache-Control": []string{"max-age=3600"},
+				"Cache-Tag":     []string{tags},
+				"Date":          []string{formatTime(currentTime())},
+				"Vary":          []string{"Accept-Language"},
+			},
+			Body: io.NopCloser(strings.NewReader("body")),
+		}
+		c.StoreResponse(context.Background(), lookup, res, currentTime())
+		return lookup
+	}
+
+	en := store("http://example.com/products/123", "product-123 category-7",
+		http.Header{"Accept-Language": []string{"en"}})
+	de := store("http://example.com/products/123", "product-123 category-7",
+		http.Header{"Accept-Language": []string{"de"}})
+	other := store("http://example.com/products/456", "product-456 category-7", nil)
+
+	keys, err := c.PurgeTags(context.Background(), []string{"product-123"})
+	require.NoError(t, err)
+	// The primary key and both variants are purged.
+	assert.Len(t, keys, 3)
+	assert.Equal(t, EntryInvalid, c.FetchResponse(context.Background(), *en).Status)
+	assert.Equal(t, EntryInvalid, c.FetchResponse(context.Background(), *de).Status)
+	assert.Equal(t, EntryOk, c.FetchResponse(context.Background(), *other).Status)
+}
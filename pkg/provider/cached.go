@@ -116,12 +116,37 @@ func (c *Cached) Keys(ctx context.Context, prefix string) []string {
 	return c.inner.Keys(ctx, prefix) // always satisfied by inner cache.
 }
 
-// Purge purges all keys matching the spedified pattern from the cache.
-func (c *Cached) Purge(ctx context.Context, pattern string) error {
+// Purge purges all keys matching the matcher from the cache. The number
+// of purged keys is reported by the inner cache.
+func (c *Cached) Purge(ctx context.Context, m *Matcher) (int, error) {
 	c.mu.Lock()
-	_ = c.outer.Purge(ctx, pattern)
+	_, _ = c.outer.Purge(ctx, m)
 	c.mu.Unlock()
-	return c.inner.Purge(ctx, pattern)
+	return c.inner.Purge(ctx, m)
+}
+
+// Tag associates the key with the given tags in both caches.
+func (c *Cached) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) {
+	c.inner.Tag(ctx, key, tags, ttl)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.outer.Tag(ctx, key, tags, ttl)
+}
+
+// PurgeTag purges all keys associated with the tag from the cache. Keys purged from the
+// inner cache are also deleted from the outer cache, as items fetched from the inner
+// cache are not tagged in the outer cache.
+func (c *Cached) PurgeTag(ctx context.Context, tag string) ([]string, error) {
+	purged, err := c.inner.PurgeTag(ctx, tag)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range purged {
+		c.outer.Delete(ctx, key)
+	}
+	_, _ = c.outer.PurgeTag(ctx, tag)
+
+	return purged, err
 }
 
 // Flush deletes all elements from the cache.
@@ -136,3 +161,14 @@ func (c *Cached) Flush(ctx context.Context) error {
 func (c *Cached) Size() int {
 	return len(c.inner.Keys(context.Background(), ""))
 }
+
+// GetMeta retrieves a metadata element from the inner cache, so that
+// metadata is shared with other instances using the same inner cache.
+func (c *Cached) GetMeta(ctx context.Context, key string) []byte {
+	return c.inner.GetMeta(ctx, key)
+}
+
+// SetMeta adds a metadata element to the inner cache.
+func (c *Cached) SetMeta(key string, value []byte) {
+	c.inner.SetMeta(key, value)
+}
@@ -69,3 +69,36 @@ func TestCached(t *testing.T) {
 	assert.Equal(t, 1, len(cache.outer.Keys(ctx, "")))
 	assert.Equal(t, 2, len(cache.inner.Keys(ctx, "")))
 }
+
+func TestCachedTags(t *testing.T) {
+	s := miniredis.RunT(t)
+	client, err := NewRedisClient("redis", RedisClientConfig{
+		Endpoint:            s.Addr(),
+		MaxQueueBufferSize:  32 << 8,
+		MaxQueueConcurrency: 56,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	ttl := time.Duration(120 * time.Second)
+
+	cache, err := NewCached(NewRedisCache("inner", client), "cached", ttl, DefaultInMemoryCacheConfig)
+	require.NoError(t, err)
+
+	// B is stored and tagged by another instance, and fetched into layer 1 untagged.
+	require.NoError(t, client.Store("B", []byte("Bob"), ttl))
+	require.NoError(t, client.Tag(ctx, "B", []string{"category-1"}, ttl))
+	assert.Equal(t, "Bob", string(cache.Get(ctx, "B")))
+
+	cache.Set("A", []byte("Alice"), ttl)
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.Equal(t, "Alice", string(cache.inner.Get(ctx, "A")))
+	}, time.Second, 10*time.Millisecond)
+	cache.Tag(ctx, "A", []string{"category-1"}, ttl)
+
+	purged, err := cache.PurgeTag(ctx, "category-1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"A", "B"}, purged)
+	assert.Nil(t, cache.Get(ctx, "A"))
+	assert.Nil(t, cache.Get(ctx, "B"))
+}
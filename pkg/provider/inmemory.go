@@ -25,7 +25,6 @@ package provider
 import (
 	"context"
 	"fmt"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -66,6 +65,15 @@ type inMemoryCache struct {
 	// ttlEviction specifices if TTL eviction is enabled.
 	ttlEviction bool
 
+	// tags holds the keys associated with a tag.
+	tags map[string]map[string]struct{}
+
+	// keyTags holds the tags associated with a key.
+	keyTags map[string][]string
+
+	// meta holds the metadata elements, which are not affected by eviction or reset.
+	meta map[string][]byte
+
 	// currentTime is the time source.
 	currentTime func() time.Time
 }
@@ -125,6 +133,9 @@ func NewInMemoryCache(config InMemoryCacheConfig) (Provider, error) {
 		defaultTTL:       ttl,
 		ttlEviction:      config.TTLEviction,
 		ttl:              make(map[string]time.Time),
+		tags:             make(map[string]map[string]struct{}),
+		keyTags:          make(map[string][]string),
+		meta:             make(map[string][]byte),
 		currentTime:      time.Now,
 	}
 
@@ -144,6 +155,7 @@ func NewInMemoryCache(config InMemoryCacheConfig) (Provider, error) {
 // onEvict is the eviction callback.
 func (c *inMemoryCache) onEvict(key string, val []byte) {
 	c.curSize -= itemSize(val)
+	c.untag(key)
 }
 
 // Get retrieves an element based on the provided key.
@@ -217,6 +229,8 @@ func (c *inMemoryCache) reset() {
 	c.inner.Purge()
 	c.curSize = 0
 	c.ttl = make(map[string]time.Time)
+	c.tags = make(map[string]map[string]struct{})
+	c.keyTags = make(map[string][]string)
 }
 
 // Delete deletes an element in the cache.
@@ -248,25 +262,71 @@ func (c *inMemoryCache) Keys(_ context.Context, prefix string) []string {
 	return keys
 }
 
-// Purge purges all keys matching the spedified pattern from the cache.
-func (c *inMemoryCache) Purge(ctx context.Context, pattern string) error {
-	if len(pattern) == 0 {
-		return c.Flush(ctx)
+// Purge purges all keys matching the matcher from the cache.
+func (c *inMemoryCache) Purge(ctx context.Context, m *Matcher) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, k := range c.inner.Keys() {
+		if m.Match(k) && c._delete(ctx, k) {
+			n++
+		}
 	}
-	r, err := regexp.Compile(wildcardToRegex(pattern))
-	if err != nil {
-		return err
+	return n, nil
+}
+
+// Tag associates the key with the given tags. Any tags previously
+// associated with the key are replaced.
+func (c *inMemoryCache) Tag(_ context.Context, key string, tags []string, _ time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.inner.Contains(key) {
+		return
 	}
-	for _, k := range c.inner.Keys() {
-		if r.MatchString(k) {
-			c.Delete(ctx, k)
+	c.untag(key)
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
 		}
+		keys[key] = struct{}{}
 	}
-	return nil
+	c.keyTags[key] = tags
+}
+
+// untag removes the key from the tag index. Guarded by caller.
+func (c *inMemoryCache) untag(key string) {
+	for _, tag := range c.keyTags[key] {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+	delete(c.keyTags, key)
+}
+
+// PurgeTag purges all keys associated with the tag from the cache.
+func (c *inMemoryCache) PurgeTag(ctx context.Context, tag string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var purged []string
+	for key := range c.tags[tag] {
+		if c._delete(ctx, key) {
+			purged = append(purged, key)
+		}
+	}
+	delete(c.tags, tag)
+	return purged, nil
 }
 
 // Flush deletes all elements from the cache.
 func (c *inMemoryCache) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.reset()
 	return nil
 }
@@ -276,23 +336,16 @@ func (c *inMemoryCache) Size() int {
 	return c.inner.Len()
 }
 
-// wildcardToRegex converts a wildcard pattern to a regex pattern.
-// Needed since Go does not natively support wildcard matching on strings.
-// TODO: check if we should use a module for this or implement it ourselves and not use regex.
-func wildcardToRegex(pattern string) string {
-	parts := strings.Split(pattern, "*")
-	if len(parts) == 1 {
-		// No *'s, return exact match pattern.
-		return "^" + pattern + "$"
-	}
-	var result strings.Builder
-	for i, p := range parts {
-		// Replace * with .*
-		if i > 0 {
-			_, _ = result.WriteString(".*")
-		}
-		// Quote any regular expression meta character.
-		_, _ = result.WriteString(regexp.QuoteMeta(p))
-	}
-	return "^" + result.String() + "$"
+// GetMeta retrieves a metadata element.
+func (c *inMemoryCache) GetMeta(_ context.Context, key string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.meta[key]
+}
+
+// SetMeta adds a metadata element to the cache.
+func (c *inMemoryCache) SetMeta(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.meta[key] = value
 }
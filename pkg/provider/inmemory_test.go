@@ -260,10 +260,14 @@ func TestInMemoryPurgeAndFlush(t *testing.T) {
 	}
 
 	// Purge with wildcards.
-	_ = cache.Purge(context.Background(), "*fonts*")
+	n, err := cache.Purge(context.Background(), mustGlob(t, "*fonts*"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
 	assert.Nil(t, cache.Get(context.Background(), items[2]))
 
-	_ = cache.Purge(context.Background(), "*/news*")
+	n, err = cache.Purge(context.Background(), mustGlob(t, "*/news*"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
 	assert.Nil(t, cache.Get(context.Background(), items[5]))
 	assert.Nil(t, cache.Get(context.Background(), items[6]))
 	assert.Nil(t, cache.Get(context.Background(), items[7]))
@@ -272,3 +276,74 @@ func TestInMemoryPurgeAndFlush(t *testing.T) {
 	_ = cache.Flush(context.Background())
 	assert.Equal(t, 0, len(cache.Keys(context.Background(), "")))
 }
+
+func TestInMemoryTags(t *testing.T) {
+	cache, _ := NewInMemoryCache(DefaultInMemoryCacheConfig)
+
+	ctx := context.Background()
+	ttl := time.Duration(120 * time.Second)
+
+	cache.Set("A", []byte("Alice"), ttl)
+	cache.Set("B", []byte("Bob"), ttl)
+	cache.Set("E", []byte("Eve"), ttl)
+	cache.Tag(ctx, "A", []string{"product-1", "category-1"}, ttl)
+	cache.Tag(ctx, "B", []string{"product-2", "category-1"}, ttl)
+	cache.Tag(ctx, "E", []string{"product-3"}, ttl)
+
+	// Missing keys are not tagged.
+	cache.Tag(ctx, "X", []string{"category-1"}, ttl)
+
+	purged, err := cache.PurgeTag(ctx, "category-1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"A", "B"}, purged)
+	assert.Nil(t, cache.Get(ctx, "A"))
+	assert.Nil(t, cache.Get(ctx, "B"))
+	assert.Equal(t, "Eve", string(cache.Get(ctx, "E")))
+
+	// Purged keys are removed from the index of their other tags.
+	purged, err = cache.PurgeTag(ctx, "product-1")
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+
+	// Re-tagging a key replaces its tags.
+	cache.Tag(ctx, "E", []string{"product-4"}, ttl)
+	purged, err = cache.PurgeTag(ctx, "product-3")
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+	assert.Equal(t, "Eve", string(cache.Get(ctx, "E")))
+
+	// Deleted and flushed keys are removed from the index.
+	cache.Delete(ctx, "E")
+	purged, _ = cache.PurgeTag(ctx, "product-4")
+	assert.Empty(t, purged)
+
+	cache.Set("G", []byte("Gopher"), ttl)
+	cache.Tag(ctx, "G", []string{"product-5"}, ttl)
+	require.NoError(t, cache.Flush(ctx))
+	purged, _ = cache.PurgeTag(ctx, "product-5")
+	assert.Empty(t, purged)
+}
+
+func TestInMemoryMeta(t *testing.T) {
+	cache, err := NewInMemoryCache(InMemoryCacheConfig{
+		MaxSize:     2 * (sliceHeaderSize + 40),
+		MaxItemSize: 1 * (sliceHeaderSize + 40),
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	ttl := time.Duration(120 * time.Second)
+
+	cache.SetMeta("bans", []byte("[]"))
+
+	// Metadata is not evicted, listed, purged or flushed.
+	for _, key := range []string{"A", "B", "C"} {
+		cache.Set(key, []byte(strings.Repeat(key, 40)), ttl)
+	}
+	assert.ElementsMatch(t, []string{"B", "C"}, cache.Keys(ctx, ""))
+	_, err = cache.Purge(ctx, mustGlob(t, ""))
+	require.NoError(t, err)
+	require.NoError(t, cache.Flush(ctx))
+	assert.Equal(t, 0, cache.Size())
+	assert.Equal(t, "[]", string(cache.GetMeta(ctx, "bans")))
+}
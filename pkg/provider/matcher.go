@@ -0,0 +1,192 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// ErrInvalidPattern is returned if a purge pattern cannot be compiled.
+var ErrInvalidPattern = errors.New("invalid pattern")
+
+// Matcher matches cache keys against a compiled glob pattern or regular expression.
+// All providers evaluate a matcher the same way, so that a pattern selects the
+// same keys regardless of the backend.
+type Matcher struct {
+	re     *regexp.Regexp
+	prefix string
+}
+
+// NewGlobMatcher returns a matcher for the glob pattern. The pattern must match the
+// entire key. It supports the following syntax:
+//
+//	'*'      matches any sequence of characters, including '/'
+//	'?'      matches any single character
+//	'[abc]'  matches any character in the class, e.g. [a-z], negated by [^abc]
+//	'\c'     matches the character c literally
+//
+// An empty pattern matches all keys.
+func NewGlobMatcher(pattern string) (*Matcher, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	return newMatcher(globToRegex(pattern))
+}
+
+// NewKeyMatcher returns a matcher for the literal cache key, i.e. the key matches
+// only itself, even if it contains glob wildcards or regex metacharacters like the
+// query of a key. An empty key matches all keys.
+func NewKeyMatcher(key string) (*Matcher, error) {
+	if key == "" {
+		return NewGlobMatcher("")
+	}
+	return newMatcher("^" + regexp.QuoteMeta(key) + "$")
+}
+
+// NewRegexMatcher returns a matcher for the regular expression (RE2 syntax).
+// The expression matches a key if it matches any part of the key; use the
+// anchors ^ and $ to match the entire key.
+func NewRegexMatcher(expr string) (*Matcher, error) {
+	return newMatcher(expr)
+}
+
+// newMatcher compiles the regular expression into a matcher.
+func newMatcher(expr string) (*Matcher, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+	}
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+	}
+	return &Matcher{re: re, prefix: literalPrefix(parsed)}, nil
+}
+
+// Match returns true if the key matches.
+func (m *Matcher) Match(key string) bool {
+	return m.re.MatchString(key)
+}
+
+// Prefix returns the literal prefix every matching key starts with.
+// It is used by remote caches to narrow down the keys to be scanned.
+func (m *Matcher) Prefix() string {
+	return m.prefix
+}
+
+// String returns the regular expression of the matcher.
+func (m *Matcher) String() string {
+	return m.re.String()
+}
+
+// literalPrefix returns the literal string following the begin-of-text anchor
+// of the parsed expression, or an empty string if the expression is not anchored.
+func literalPrefix(re *syntax.Regexp) string {
+	if re.Op != syntax.OpConcat || len(re.Sub) == 0 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	var b strings.Builder
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		_, _ = b.WriteString(string(sub.Rune))
+	}
+	return b.String()
+}
+
+// globToRegex converts a glob pattern to an anchored regular expression.
+// Needed since Go does not natively support glob matching on arbitrary strings.
+func globToRegex(pattern string) string {
+	var b strings.Builder
+	_ = b.WriteByte('^')
+	p := []rune(pattern)
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '*':
+			_, _ = b.WriteString(".*")
+		case '?':
+			_ = b.WriteByte('.')
+		case '\\':
+			if i+1 < len(p) {
+				i++
+			}
+			_, _ = b.WriteString(regexp.QuoteMeta(string(p[i])))
+		case '[':
+			end := classEnd(p, i+1)
+			if end < 0 {
+				// Unterminated class, match '[' literally.
+				_, _ = b.WriteString(`\[`)
+				continue
+			}
+			writeClass(&b, p[i+1:end])
+			i = end
+		default:
+			_, _ = b.WriteString(regexp.QuoteMeta(string(p[i])))
+		}
+	}
+	_ = b.WriteByte('$')
+	return b.String()
+}
+
+// classEnd returns the index of the ']' terminating the character class
+// starting at index i, or -1 if the class is not terminated.
+func classEnd(p []rune, i int) int {
+	for ; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// writeClass writes the glob character class as regular expression class.
+func writeClass(b *strings.Builder, class []rune) {
+	_ = b.WriteByte('[')
+	if len(class) > 0 && class[0] == '^' {
+		_ = b.WriteByte('^')
+		class = class[1:]
+	}
+	for i := 0; i < len(class); i++ {
+		c := class[i]
+		if c == '\\' && i+1 < len(class) {
+			i++
+			c = class[i]
+		} else if c == '-' {
+			_ = b.WriteByte('-')
+			continue
+		}
+		if strings.ContainsRune(`\[]^-`, c) {
+			_ = b.WriteByte('\\')
+		}
+		_, _ = b.WriteRune(c)
+	}
+	_ = b.WriteByte(']')
+}
@@ -0,0 +1,211 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustGlob(t *testing.T, pattern string) *Matcher {
+	t.Helper()
+	m, err := NewGlobMatcher(pattern)
+	require.NoError(t, err)
+	return m
+}
+
+func TestGlobMatcher(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		match   bool
+		prefix  string
+	}{
+		{"", "kache-http://example.com/", true, ""},
+		{"*", "kache-http://example.com/", true, ""},
+		{"kache-http://example.com/", "kache-http://example.com/", true, "kache-http://example.com/"},
+		{"kache-http://example.com/", "kache-http://example.com/news", false, "kache-http://example.com/"},
+		{"*/news*", "kache-http://example.com/news/article", true, ""},
+		{"*/news*", "kache-http://example.com/archive", false, ""},
+		{"kache-http://*.com/*.css", "kache-http://example.com/assets/main.css", true, "kache-http://"},
+		{"kache-http://*.com/*.css", "kache-http://example.com/assets/main.js", false, "kache-http://"},
+		{"*/v?/*", "kache-http://example.com/v1/users", true, ""},
+		{"*/v?/*", "kache-http://example.com/v10/users", false, ""},
+		{"*/v[0-9]/*", "kache-http://example.com/v2/users", true, ""},
+		{"*/v[^0-9]/*", "kache-http://example.com/v2/users", false, ""},
+		{"*/v[^0-9]/*", "kache-http://example.com/vx/users", true, ""},
+		{`*\**`, "kache-http://example.com/*", true, ""},
+		{`*\**`, "kache-http://example.com/a", false, ""},
+		{"*?q=[ab]", "kache-http://example.com/?q=b", true, ""},
+		{"a.c", "abc", false, "a.c"},
+		{"a[b", "a[b", true, "a[b"},
+		{"a+b(c)", "a+b(c)", true, "a+b(c)"},
+	}
+	for _, tt := range tests {
+		m := mustGlob(t, tt.pattern)
+		assert.Equal(t, tt.match, m.Match(tt.key), "%q %q", tt.pattern, tt.key)
+		assert.Equal(t, tt.prefix, m.Prefix(), tt.pattern)
+	}
+
+	_, err := NewGlobMatcher("a[]")
+	assert.ErrorIs(t, err, ErrInvalidPattern)
+}
+
+func TestKeyMatcher(t *testing.T) {
+	tests := []struct {
+		key    string
+		match  string
+		want   bool
+		prefix string
+	}{
+		{"", "kache-http://example.com/", true, ""},
+		{"kache-http://h/p?a=1", "kache-http://h/p?a=1", true, "kache-http://h/p?a=1"},
+		{"kache-http://h/p?a=1", "kache-http://h/pXa=1", false, "kache-http://h/p?a=1"},
+		{"kache-http://h/p?a=[1]", "kache-http://h/p?a=1", false, "kache-http://h/p?a=[1]"},
+		{"kache-http://h/*", "kache-http://h/news", false, "kache-http://h/*"},
+		{"kache-http://h/p", "kache-http://h/p#slice-0", false, "kache-http://h/p"},
+	}
+	for _, tt := range tests {
+		m, err := NewKeyMatcher(tt.key)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, m.Match(tt.match), "%q %q", tt.key, tt.match)
+		assert.Equal(t, tt.prefix, m.Prefix(), tt.key)
+	}
+}
+
+func TestRegexMatcher(t *testing.T) {
+	tests := []struct {
+		expr   string
+		key    string
+		match  bool
+		prefix string
+	}{
+		{"", "kache-http://example.com/", true, ""},
+		{`\.css$`, "kache-http://example.com/main.css", true, ""},
+		{`\.css$`, "kache-http://example.com/main.css?v=1", false, ""},
+		{`^kache-http://example\.com/assets/.*\.css$`, "kache-http://example.com/assets/a.css", true, "kache-http://example.com/assets/"},
+		{`^kache-http://example\.com/assets/.*\.css$`, "kache-http://example.org/assets/a.css", false, "kache-http://example.com/assets/"},
+		{`^(?i)kache`, "KACHE", true, ""},
+		{`^a|b`, "xb", true, ""},
+	}
+	for _, tt := range tests {
+		m, err := NewRegexMatcher(tt.expr)
+		require.NoError(t, err)
+		assert.Equal(t, tt.match, m.Match(tt.key), "%q %q", tt.expr, tt.key)
+		assert.Equal(t, tt.prefix, m.Prefix(), tt.expr)
+	}
+
+	_, err := NewRegexMatcher("^/assets/(*.css")
+	assert.ErrorIs(t, err, ErrInvalidPattern)
+}
+
+func TestPurgeSemantics(t *testing.T) {
+	// The same pattern purges the same keys with every provider.
+
+	ctx := context.Background()
+	ttl := time.Duration(120 * time.Second)
+
+	s := miniredis.RunT(t)
+	newRedis := func() Provider {
+		s.FlushAll()
+		client, err := NewRedisClient("redis", RedisClientConfig{
+			Endpoint:            s.Addr(),
+			MaxItemSize:         1 << 14,
+			MaxQueueBufferSize:  32 << 8,
+			MaxQueueConcurrency: 56,
+		})
+		require.NoError(t, err)
+		return NewRedisCache("redis", client)
+	}
+
+	providers := map[string]func() Provider{
+		"inmemory": func() Provider {
+			c, err := NewInMemoryCache(DefaultInMemoryCacheConfig)
+			require.NoError(t, err)
+			return c
+		},
+		"simple": func() Provider {
+			c, err := NewSimpleCache(nil)
+			require.NoError(t, err)
+			return c
+		},
+		"redis": newRedis,
+		"cached": func() Provider {
+			c, err := NewCached(newRedis(), "cached", ttl, DefaultInMemoryCacheConfig)
+			require.NoError(t, err)
+			return c
+		},
+	}
+
+	keys := []string{
+		"kache-http://example.com/",
+		"kache-http://example.com/v1/users",
+		"kache-http://example.com/v2/users?page=2",
+		"kache-http://example.com/v10/users",
+		"kache-http://example.com/assets/main.css",
+		"kache-http://example.com/assets/main.css#vary-0123456789abcdef",
+		"kache-http://example.org/assets/main.css",
+		"kache-http://example.com/[draft]",
+	}
+
+	tests := []struct {
+		glob   string
+		regex  string
+		purged int
+	}{
+		{glob: "*/v?/*", purged: 2},
+		{glob: "kache-http://example.com/assets/*", purged: 2},
+		{glob: `*/\[draft\]`, purged: 1},
+		{glob: "*.css", purged: 2},
+		{regex: `\.css`, purged: 3},
+		{regex: `^kache-http://example\.com/v[0-9]+/`, purged: 3},
+	}
+
+	for name, newProvider := range providers {
+		for _, tt := range tests {
+			cache := newProvider()
+			for _, key := range keys {
+				cache.Set(key, []byte("value"), ttl)
+			}
+			assert.EventuallyWithT(t, func(c *assert.CollectT) {
+				assert.Len(c, cache.Keys(ctx, ""), len(keys))
+			}, time.Second, 10*time.Millisecond)
+
+			m := mustGlob(t, tt.glob)
+			if tt.regex != "" {
+				var err error
+				m, err = NewRegexMatcher(tt.regex)
+				require.NoError(t, err)
+			}
+			n, err := cache.Purge(ctx, m)
+			require.NoError(t, err)
+			assert.Equal(t, tt.purged, n, "%s: %s", name, m)
+			assert.Len(t, cache.Keys(ctx, ""), len(keys)-tt.purged, "%s: %s", name, m)
+		}
+	}
+}
@@ -49,14 +49,32 @@ type Provider interface {
 	// Keys returns a slice of cache keys.
 	Keys(ctx context.Context, prefix string) []string
 
-	// Purge purges all keys matching the specified pattern from the cache.
-	Purge(ctx context.Context, pattern string) error
+	// Purge purges all keys matching the matcher from the cache.
+	// It returns the number of purged keys.
+	Purge(ctx context.Context, m *Matcher) (int, error)
+
+	// Tag associates the key with the given tags (surrogate keys),
+	// so that the key can be purged by any of the tags.
+	Tag(ctx context.Context, key string, tags []string, ttl time.Duration)
+
+	// PurgeTag purges all keys associated with the tag from the cache.
+	// It returns the purged keys.
+	PurgeTag(ctx context.Context, tag string) ([]string, error)
 
 	// Flush deletes all elements from the cache.
 	Flush(ctx context.Context) error
 
 	// Size returns the number of entries currently stored in the Cache.
 	Size() int
+
+	// GetMeta retrieves a metadata element, e.g. the ban list, returning nil if the
+	// element does not exist. Metadata is stored outside of the keyspace of cached
+	// elements: it never expires, is not evicted, and is neither listed by Keys nor
+	// deleted by Purge or Flush.
+	GetMeta(ctx context.Context, key string) []byte
+
+	// SetMeta adds a metadata element to the cache, see GetMeta.
+	SetMeta(key string, value []byte)
 }
 
 // RemoteCacheClient is a generalized interface to interact with a remote cache.
@@ -78,12 +96,28 @@ type RemoteCacheClient interface {
 	// Keys returns a slice of cache keys.
 	Keys(ctx context.Context, prefix string) []string
 
-	// Purge purges all keys matching the spedified pattern from the remote cache.
-	Purge(ctx context.Context, pattern string) error
+	// Purge deletes all keys matching the matcher from the remote cache.
+	// It returns the number of deleted keys.
+	Purge(ctx context.Context, m *Matcher) (int, error)
 
-	// Flush deletes all keys from the remote cache.
+	// Tag adds the key to the index of each of the given tags.
+	Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error
+
+	// PurgeTag deletes all keys in the index of the tag from the remote cache.
+	// It returns the deleted keys.
+	PurgeTag(ctx context.Context, tag string) ([]string, error)
+
+	// Flush deletes all keys from the remote cache, except metadata.
 	Flush(ctx context.Context) error
 
+	// FetchMeta fetches a metadata element stored outside of the keyspace
+	// of cached elements. Returns nil if an error occurs.
+	FetchMeta(ctx context.Context, key string) []byte
+
+	// StoreMeta stores a metadata element outside of the keyspace of cached
+	// elements, without expiry. Returns an error in case the operation fails.
+	StoreMeta(ctx context.Context, key string, value []byte) error
+
 	// Stop closes the client connection.
 	Stop()
 
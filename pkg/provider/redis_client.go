@@ -82,6 +82,29 @@ func (c *RedisClientConfig) Validate() error {
 	return nil
 }
 
+// tagKeyPrefix is the prefix of the keys holding the set of keys associated with a tag.
+const tagKeyPrefix = "kache-tag:"
+
+// metaKeyPrefix is the prefix of the keys holding metadata, which are excluded from
+// Keys, Purge and Flush. Metadata is stored without expiry, so that it is not evicted
+// by the volatile eviction policies of Redis.
+const metaKeyPrefix = "kache-meta:"
+
+// tagScript adds a key to the set of a tag. The expiry of the set is only ever extended,
+// so that the set does not expire before any of its keys. A TTL of 0 never expires.
+var tagScript = redis.NewScript(`
+local ttl = tonumber(ARGV[1])
+local exists = redis.call('EXISTS', KEYS[1])
+local current = redis.call('PTTL', KEYS[1])
+redis.call('SADD', KEYS[1], ARGV[2])
+if ttl <= 0 then
+	redis.call('PERSIST', KEYS[1])
+elseif exists == 0 or (current >= 0 and current < ttl) then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
 // redisClient wraps a Redis Universal Client.
 type redisClient struct {
 	redis.UniversalClient
@@ -158,8 +181,11 @@ func (c *redisClient) Delete(ctx context.Context, key string) error {
 // Keys returns a slice of cache keys.
 func (c *redisClient) Keys(ctx context.Context, prefix string) []string {
 	var keys []string
-	iter := c.Scan(ctx, 0, prefix+"*", 0).Iterator()
+	iter := c.Scan(ctx, 0, escapeGlob(prefix)+"*", 0).Iterator()
 	for iter.Next(ctx) {
+		if isReservedKey(iter.Val()) {
+			continue // skip tag index and metadata
+		}
 		keys = append(keys, iter.Val())
 	}
 	if err := iter.Err(); err != nil {
@@ -176,25 +202,105 @@ func (c *redisClient) Stop() {
 	}
 }
 
-// Purge purges keys matching the specified pattern from the cache. If the pattern is
-// empty, all keys will be removed from the cache, similar to a `Flush`.
-func (c *redisClient) Purge(ctx context.Context, pattern string) error {
-	iter := c.Scan(ctx, 0, pattern, 0).Iterator()
+// Purge purges keys matching the matcher from the cache. Keys are matched on the client
+// rather than by the SCAN MATCH option, as Redis glob patterns differ from the patterns
+// supported by other providers. Only keys starting with the literal prefix of the
+// matcher are scanned.
+func (c *redisClient) Purge(ctx context.Context, m *Matcher) (int, error) {
+	iter := c.Scan(ctx, 0, escapeGlob(m.Prefix())+"*", 0).Iterator()
 
+	n := 0
 	for iter.Next(ctx) {
 		key := iter.Val()
-		if len(key) > 0 {
-			// TODO: evaluate non-blocking Unlink.
-			if err := c.Del(ctx, key).Err(); err != nil {
-				return err
-			}
+		if isReservedKey(key) || !m.Match(key) {
+			continue
+		}
+		// TODO: evaluate non-blocking Unlink.
+		deleted, err := c.Del(ctx, key).Result()
+		if err != nil {
+			return n, err
 		}
+		n += int(deleted)
 	}
 
-	return iter.Err()
+	return n, iter.Err()
+}
+
+// isReservedKey returns true if the key holds a tag index or metadata.
+func isReservedKey(key string) bool {
+	return strings.HasPrefix(key, tagKeyPrefix) || strings.HasPrefix(key, metaKeyPrefix)
+}
+
+// escapeGlob escapes all special characters of a Redis glob pattern.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]^\`, c) {
+			_ = b.WriteByte('\\')
+		}
+		_, _ = b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Tag adds the key to the set of each of the given tags.
+func (c *redisClient) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	for _, tag := range tags {
+		if err := tagScript.Run(ctx, c, []string{tagKeyPrefix + tag}, ttl.Milliseconds(), key).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeTag deletes all keys in the set of the tag. Keys added to the set
+// concurrently are kept in the set.
+func (c *redisClient) PurgeTag(ctx context.Context, tag string) ([]string, error) {
+	setKey := tagKeyPrefix + tag
+	keys, err := c.SMembers(ctx, setKey).Result()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	var purged []string
+	for _, key := range keys {
+		n, err := c.Del(ctx, key).Result()
+		if err != nil {
+			return purged, err
+		}
+		if n > 0 {
+			purged = append(purged, key)
+		}
+	}
+
+	members := make([]interface{}, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+	return purged, c.SRem(ctx, setKey, members...).Err()
 }
 
-// Flush deletes all keys from the cache.
+// Flush deletes all keys from the cache, including the tag indexes, but
+// except metadata.
 func (c *redisClient) Flush(ctx context.Context) error {
-	return c.FlushDB(ctx).Err()
+	iter := c.Scan(ctx, 0, "*", 0).Iterator()
+	for iter.Next(ctx) {
+		if strings.HasPrefix(iter.Val(), metaKeyPrefix) {
+			continue
+		}
+		if err := c.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// FetchMeta performs a Redis Get operation for a metadata key.
+func (c *redisClient) FetchMeta(ctx context.Context, key string) []byte {
+	return c.Fetch(ctx, metaKeyPrefix+key)
+}
+
+// StoreMeta stores a metadata key and value into Redis without expiry.
+func (c *redisClient) StoreMeta(ctx context.Context, key string, value []byte) error {
+	return c.Set(ctx, metaKeyPrefix+key, value, 0).Err()
 }
@@ -167,10 +167,14 @@ func TestRedisPurgeAndFlush(t *testing.T) {
 		_ = cache.Store(item, []byte("test"), 120*time.Second)
 	}
 
-	_ = cache.Purge(context.Background(), "*fonts*")
+	n, err := cache.Purge(context.Background(), mustGlob(t, "*fonts*"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
 	assert.Nil(t, cache.Fetch(context.Background(), items[2]))
 
-	_ = cache.Purge(context.Background(), "*/news*")
+	n, err = cache.Purge(context.Background(), mustGlob(t, "*/news*"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
 	assert.Nil(t, cache.Fetch(context.Background(), items[5]))
 	assert.Nil(t, cache.Fetch(context.Background(), items[6]))
 	assert.Nil(t, cache.Fetch(context.Background(), items[7]))
@@ -179,3 +183,62 @@ func TestRedisPurgeAndFlush(t *testing.T) {
 	_ = cache.Flush(context.Background())
 	assert.Equal(t, 0, len(cache.Keys(context.Background(), "")))
 }
+
+func TestRedisClientTags(t *testing.T) {
+	s := miniredis.RunT(t)
+	cache, err := NewRedisClient("test", RedisClientConfig{
+		Endpoint: s.Addr(),
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{"A", "B", "E"} {
+		require.NoError(t, cache.Store(key, []byte("test"), 120*time.Second))
+	}
+	require.NoError(t, cache.Tag(ctx, "A", []string{"product-1", "category-1"}, 60*time.Second))
+	require.NoError(t, cache.Tag(ctx, "B", []string{"product-2", "category-1"}, 120*time.Second))
+	require.NoError(t, cache.Tag(ctx, "E", []string{"product-3"}, 120*time.Second))
+
+	// The tag index expires with the longest living key.
+	assert.Equal(t, 120*time.Second, s.TTL(tagKeyPrefix+"category-1"))
+	require.NoError(t, cache.Tag(ctx, "A", []string{"category-1"}, 30*time.Second))
+	assert.Equal(t, 120*time.Second, s.TTL(tagKeyPrefix+"category-1"))
+
+	// The tag index is not listed as cache keys.
+	assert.ElementsMatch(t, []string{"A", "B", "E"}, cache.Keys(ctx, ""))
+
+	purged, err := cache.PurgeTag(ctx, "category-1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"A", "B"}, purged)
+	assert.Nil(t, cache.Fetch(ctx, "A"))
+	assert.Nil(t, cache.Fetch(ctx, "B"))
+	assert.NotNil(t, cache.Fetch(ctx, "E"))
+	assert.False(t, s.Exists(tagKeyPrefix+"category-1"))
+
+	purged, err = cache.PurgeTag(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+}
+
+func TestRedisClientMeta(t *testing.T) {
+	s := miniredis.RunT(t)
+	cache, err := NewRedisClient("test", RedisClientConfig{
+		Endpoint: s.Addr(),
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, cache.Store("A", []byte("test"), 120*time.Second))
+	require.NoError(t, cache.StoreMeta(ctx, "bans", []byte("[]")))
+	assert.Equal(t, time.Duration(0), s.TTL(metaKeyPrefix+"bans"))
+
+	// Metadata is neither listed, nor purged or flushed.
+	assert.Equal(t, []string{"A"}, cache.Keys(ctx, ""))
+	n, err := cache.Purge(ctx, mustGlob(t, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, cache.Store("B", []byte("test"), 120*time.Second))
+	require.NoError(t, cache.Flush(ctx))
+	assert.Nil(t, cache.Fetch(ctx, "B"))
+	assert.Equal(t, "[]", string(cache.FetchMeta(ctx, "bans")))
+}
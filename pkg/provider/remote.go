@@ -25,6 +25,8 @@ package provider
 import (
 	"context"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 // RedisCache is a Redis-based cache.
@@ -76,9 +78,21 @@ func (c *remoteCache) Keys(ctx context.Context, prefix string) []string {
 	return c.client.Keys(ctx, prefix)
 }
 
-// Purge purges all keys matching the spedified pattern from the cache.
-func (c *remoteCache) Purge(ctx context.Context, pattern string) error {
-	return c.client.Purge(ctx, pattern)
+// Purge purges all keys matching the matcher from the cache.
+func (c *remoteCache) Purge(ctx context.Context, m *Matcher) (int, error) {
+	return c.client.Purge(ctx, m)
+}
+
+// Tag associates the key with the given tags.
+func (c *remoteCache) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) {
+	if err := c.client.Tag(ctx, key, tags, ttl); err != nil {
+		log.Error().Err(err).Str("cache-key", key).Msg("Error tagging item")
+	}
+}
+
+// PurgeTag purges all keys associated with the tag from the cache.
+func (c *remoteCache) PurgeTag(ctx context.Context, tag string) ([]string, error) {
+	return c.client.PurgeTag(ctx, tag)
 }
 
 // Flush deletes all elements from the cache.
@@ -89,3 +103,15 @@ func (c *remoteCache) Flush(ctx context.Context) error {
 // Size returns the number of entries currently stored in the Cache.
 // TODO: not implemented yet.
 func (c *remoteCache) Size() int { return -1 }
+
+// GetMeta retrieves a metadata element.
+func (c *remoteCache) GetMeta(ctx context.Context, key string) []byte {
+	return c.client.FetchMeta(ctx, key)
+}
+
+// SetMeta adds a metadata element to the cache.
+func (c *remoteCache) SetMeta(key string, value []byte) {
+	if err := c.client.StoreMeta(context.Background(), key, value); err != nil {
+		log.Error().Err(err).Str("meta-key", key).Msg("Error storing metadata")
+	}
+}
@@ -44,6 +44,15 @@ type simpleCache struct {
 	mu          sync.RWMutex
 	entryMap    map[string]*list.Element
 	iterateList *list.List
+
+	// tags holds the keys associated with a tag.
+	tags map[string]map[string]struct{}
+
+	// keyTags holds the tags associated with a key.
+	keyTags map[string][]string
+
+	// meta holds the metadata elements.
+	meta map[string][]byte
 }
 
 // NewSimpleCache creates a new simple cache with given options.
@@ -55,6 +64,9 @@ func NewSimpleCache(opts *SimpleOptions) (Provider, error) {
 	cache := &simpleCache{
 		iterateList: list.New(),
 		entryMap:    make(map[string]*list.Element, opts.InitialCapacity),
+		tags:        make(map[string]map[string]struct{}),
+		keyTags:     make(map[string][]string),
+		meta:        make(map[string][]byte),
 	}
 	return cache, nil
 }
@@ -82,13 +94,18 @@ func (c *simpleCache) Set(key string, val []byte, _ time.Duration) {
 func (c *simpleCache) Delete(_ context.Context, key string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c._delete(key)
+}
 
+// _delete deletes the key and removes it from the tag index. Guarded by caller.
+func (c *simpleCache) _delete(key string) bool {
 	ent := c.entryMap[key]
 	if ent == nil {
 		return false
 	}
 	_ = c.iterateList.Remove(ent).([]byte)
 	delete(c.entryMap, key)
+	c.untag(key)
 	return true
 }
 
@@ -111,8 +128,79 @@ func (c *simpleCache) Keys(_ context.Context, _ string) []string {
 	return keys
 }
 
-func (c *simpleCache) Purge(_ context.Context, _ string) error {
-	return errors.New("not yet implemented")
+// Tag associates the key with the given tags. Any tags previously
+// associated with the key are replaced.
+func (c *simpleCache) Tag(_ context.Context, key string, tags []string, _ time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entryMap[key]; !ok {
+		return
+	}
+	c.untag(key)
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	c.keyTags[key] = tags
+}
+
+// untag removes the key from the tag index. Guarded by caller.
+func (c *simpleCache) untag(key string) {
+	for _, tag := range c.keyTags[key] {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+	delete(c.keyTags, key)
+}
+
+// PurgeTag deletes all keys associated with the tag.
+func (c *simpleCache) PurgeTag(_ context.Context, tag string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var purged []string
+	for key := range c.tags[tag] {
+		if c._delete(key) {
+			purged = append(purged, key)
+		}
+	}
+	delete(c.tags, tag)
+	return purged, nil
+}
+
+// Purge deletes all keys matching the matcher.
+func (c *simpleCache) Purge(_ context.Context, m *Matcher) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for key := range c.entryMap {
+		if m.Match(key) && c._delete(key) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GetMeta retrieves a metadata element.
+func (c *simpleCache) GetMeta(_ context.Context, key string) []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.meta[key]
+}
+
+// SetMeta adds a metadata element to the cache.
+func (c *simpleCache) SetMeta(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.meta[key] = value
 }
 
 func (c *simpleCache) Flush(_ context.Context) error {
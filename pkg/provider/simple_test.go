@@ -29,6 +29,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSimpleCache(t *testing.T) {
@@ -99,3 +100,39 @@ func TestSimpleCacheConcurrentAccess(t *testing.T) {
 	close(ch)
 	wg.Wait()
 }
+
+func TestSimpleCacheTags(t *testing.T) {
+	p, _ := NewSimpleCache(nil)
+	cache := p.(*simpleCache)
+
+	ctx := context.Background()
+	ttl := time.Duration(120 * time.Second)
+
+	cache.Set("A", []byte("Alice"), ttl)
+	cache.Set("B", []byte("Bob"), ttl)
+
+	// Re-storing a key does not add it to the index again.
+	for i := 0; i < 3; i++ {
+		cache.Tag(ctx, "A", []string{"category-1"}, ttl)
+	}
+	cache.Tag(ctx, "B", []string{"category-1", "product-2"}, ttl)
+	assert.Len(t, cache.tags["category-1"], 2)
+
+	// Missing keys are not tagged.
+	cache.Tag(ctx, "X", []string{"category-1"}, ttl)
+	assert.Len(t, cache.tags["category-1"], 2)
+
+	// Deleted and purged keys are removed from the index.
+	cache.Delete(ctx, "A")
+	m, err := NewGlobMatcher("B")
+	require.NoError(t, err)
+	_, _ = cache.Purge(ctx, m)
+	assert.Empty(t, cache.tags)
+	assert.Empty(t, cache.keyTags)
+
+	cache.Set("A", []byte("Alice"), ttl)
+	purged, err := cache.PurgeTag(ctx, "category-1")
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+	assert.Equal(t, "Alice", string(cache.Get(ctx, "A")))
+}
@@ -26,11 +26,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kacheio/kache/pkg/cache"
+	"github.com/kacheio/kache/pkg/provider"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
@@ -45,39 +50,336 @@ func (s *Server) CacheKeysHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// CacheKeyPurgeHandler handles a PURGE request and deletes the given key from the
-// cache. The cache key is obtained from a custom request header 'X-Purge-Key'.
-// When running in a cluster a invalidation signal gets broadcasted to other instances.
+// CacheKeyPurgeHandler handles a PURGE request and deletes the matching keys from the
+// cache. The keys are selected by one of the custom request headers 'X-Purge-Regex'
+// (regular expression), 'X-Purge-Glob' (glob pattern) or 'X-Purge-Key' (literal cache
+// key), see purgeKeys. With 'X-Purge-Soft: true' the
+// keys are soft purged, i.e. marked stale instead of deleted. The response body reports
+// the number of purged keys. When running in a cluster with a layered cache, an
+// invalidation signal gets broadcasted to other instances, see broadcastsPurges.
 func (s *Server) CacheKeyPurgeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "PURGE" {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
 
-	// TODO: implement regex header, e.g. 'X-Purge-Regex: ^/assets/*.css'.
-	key := r.Header.Get("X-Purge-Key")
-	if err := s.cache.Purge(context.Background(), key); err != nil {
-		w.WriteHeader(http.StatusNotFound)
+	// Purge by tag: curl -X PURGE -H 'X-Purge-Tag: <tag> [<tag> ...]' kacheserver:PORT
+	if tags := cache.ParseTags(r.Header.Get("X-Purge-Tag")); len(tags) > 0 {
+		s.purgeTags(w, r, tags)
+		return
+	}
+
+	n, err := s.purgeKeys(r)
+	if err != nil {
+		purgeError(w, err)
 		return
 	}
 	s.broadcastPurge(r)
 
-	w.WriteHeader(http.StatusOK)
+	writePurged(w, n)
 }
 
-// CacheInvalidateHandler handles the DELETE request to invalidate the provided key in the cache.
-// When running in a cluster, this does not broadcast to other kache instances.
+// CacheInvalidateHandler handles the DELETE request to invalidate the keys selected by the
+// purge headers in the cache, see CacheKeyPurgeHandler. When running in a cluster, this
+// does not broadcast to other kache instances.
 func (s *Server) CacheInvalidateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	key := r.Header.Get("X-Purge-Key")
-	if err := s.cache.Purge(context.Background(), key); err != nil {
-		w.WriteHeader(http.StatusNotFound)
+	n, err := s.purgeKeys(r)
+	if err != nil {
+		purgeError(w, err)
+		return
+	}
+	writePurged(w, n)
+}
+
+// purgeKeys purges the keys selected by the purge headers of the request and returns the
+// number of purged keys. The headers are evaluated in the following order:
+//
+//	X-Purge-Regex: ^kache-https://example.com/assets/.*\.css$
+//	X-Purge-Glob: kache-https://example.com/assets/*.css
+//	X-Purge-Key: kache-https://example.com/assets/main.css
+//
+// Glob patterns and keys must match the entire cache key, whereas regular expressions
+// match any part of the key, unless anchored. Keys are matched literally, so that the
+// '?' and '[' of a query are not taken as wildcards; use the glob header for patterns.
+// Patterns are evaluated the same way by all cache providers. A missing or empty key
+// purges all keys.
+//
+// If the 'X-Purge-Soft' header is true, the matching entries are invalidated instead of
+// deleted, so that they are validated by the origin with a conditional request on the
+// next access and can still be served if the origin fails, see HttpCache.SoftPurge.
+func (s *Server) purgeKeys(r *http.Request) (int, error) {
+	var (
+		m   *provider.Matcher
+		err error
+	)
+	if expr := r.Header.Get("X-Purge-Regex"); expr != "" {
+		m, err = provider.NewRegexMatcher(expr)
+	} else if glob := r.Header.Get("X-Purge-Glob"); glob != "" {
+		m, err = provider.NewGlobMatcher(glob)
+	} else {
+		m, err = provider.NewKeyMatcher(r.Header.Get("X-Purge-Key"))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if soft, _ := strconv.ParseBool(r.Header.Get("X-Purge-Soft")); soft {
+		n := s.httpcache.SoftPurge(r.Context(), m, time.Now())
+		log.Debug().Str("pattern", m.String()).Int("keys", n).Msg("Soft purged keys")
+		return n, nil
+	}
+
+	n, err := s.cache.Purge(r.Context(), m)
+	if err != nil {
+		log.Error().Err(err).Str("pattern", m.String()).Msg("Error purging keys")
+		return n, err
+	}
+	log.Debug().Str("pattern", m.String()).Int("keys", n).Msg("Purged keys")
+	return n, nil
+}
+
+// purgeError writes the error response of a failed purge.
+func purgeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, provider.ErrInvalidPattern) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// purgeResult is the response body of a purge request.
+type purgeResult struct {
+	// Purged is the number of purged keys.
+	Purged int `json:"purged"`
+}
+
+// writePurged writes the number of purged keys as response.
+func writePurged(w http.ResponseWriter, n int) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(purgeResult{Purged: n})
+}
+
+// CacheInvalidateKeysHandler handles the DELETE request to invalidate the exact keys listed
+// in the JSON request body, e.g. '["kache-http://example.com/news"]'. It is used to notify
+// other instances of responses invalidated by unsafe requests, which is why this does
+// not broadcast to other kache instances.
+func (s *Server) CacheInvalidateKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := 0
+	for _, key := range keys {
+		if s.cache.Delete(r.Context(), key) {
+			n++
+		}
+	}
+	writePurged(w, n)
+}
+
+// CacheInvalidateTagsHandler handles the DELETE request to invalidate all keys tagged with
+// any of the tags provided in the 'X-Purge-Tag' header, see purgeTags.
+func (s *Server) CacheInvalidateTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	tags := cache.ParseTags(r.Header.Get("X-Purge-Tag"))
+	if len(tags) == 0 {
+		http.Error(w, "missing X-Purge-Tag header", http.StatusBadRequest)
+		return
+	}
+	s.purgeTags(w, r, tags)
+}
+
+// purgeTags purges all keys tagged with any of the given tags. When running in a cluster
+// with a layered cache, the purge gets broadcasted to other instances, unless it is a
+// broadcast itself, see broadcastsPurges. The broadcast carries the purged keys in the body,
+// so that the receiving instances can delete them from their local cache layer, even
+// if the tag index of a shared (remote) cache has already been purged.
+func (s *Server) purgeTags(w http.ResponseWriter, r *http.Request, tags []string) {
+	_, broadcast := r.Header["X-Kache-Cluster"]
+	if broadcast {
+		var keys []string
+		if err := json.NewDecoder(r.Body).Decode(&keys); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, key := range keys {
+			s.cache.Delete(r.Context(), key)
+		}
+	}
+
+	keys, err := s.httpcache.PurgeTags(r.Context(), tags)
+	if err != nil {
+		log.Error().Err(err).Strs("tags", tags).Msg("Error purging tags")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Debug().Strs("tags", tags).Int("keys", len(keys)).Msg("Purged tags")
+
+	if !broadcast && s.broadcastsPurges() {
+		body, err := json.Marshal(keys)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+		s.cluster.Broadcast(r, "api", http.MethodDelete,
+			path.Join(s.cfg.API.GetPrefix(), "/cache/invalidate/tags"))
+	}
+
+	writePurged(w, len(keys))
+}
+
+// CacheBansHandler handles the GET request to list the active bans and the POST request
+// to add a ban, e.g. '{"expr": "req.url ~ ^/news"}', see cache.Ban. When running in a
+// cluster with a layered cache, an added ban gets broadcasted to other instances with the
+// time of the ban, unless it is a broadcast itself, see broadcastsPurges.
+func (s *Server) CacheBansHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.httpcache.Bans(r.Context())); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req cache.Ban
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only broadcasted bans keep their time, to be identical on all instances.
+	_, broadcast := r.Header["X-Kache-Cluster"]
+	now := time.Now()
+	if broadcast && !req.Time.IsZero() {
+		now = req.Time
+	}
+	ban, err := s.httpcache.Ban(r.Context(), req.Expr, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Debug().Str("ban", ban.Expr).Time("time", ban.Time).Msg("Added ban")
+
+	body, err := json.Marshal(ban)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !broadcast && s.broadcastsPurges() {
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+		s.cluster.Broadcast(r, "api", r.Method, r.URL.Path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// offlineState is the body of the offline mode requests.
+type offlineState struct {
+	Offline bool `json:"offline"`
+}
+
+// CacheOfflineHandler handles the GET request to render the offline mode and the PUT
+// request to toggle it, e.g. '{"offline": true}'. When running in a cluster, the toggle
+// gets broadcasted to other instances, unless it is a broadcast itself.
+func (s *Server) CacheOfflineHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "unable to read body", http.StatusBadRequest)
+			return
+		}
+		var state offlineState
+		if err := json.Unmarshal(body, &state); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.httpcache.SetOffline(state.Offline)
+		log.Info().Bool("offline", state.Offline).Msg("Toggled offline mode")
+
+		if _, ok := r.Header["X-Kache-Cluster"]; !ok && s.cluster != nil {
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+			s.cluster.Broadcast(r, "api", r.Method, r.URL.Path)
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(offlineState{Offline: s.httpcache.Offline()}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// explainRequest is the body of the explain requests.
+type explainRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+// CacheExplainHandler handles the POST request to explain how the cache would handle a
+// request with the given method (default GET), URL, header fields and body, e.g.
+// '{"url": "http://example.com/news?utm_source=x", "header": {"Accept": ["text/html"]}}'.
+// The request is routed to its upstream target, but not forwarded to the origin.
+func (s *Server) CacheExplainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var body explainRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Method == "" {
+		body.Method = http.MethodGet
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), body.Method, body.URL, strings.NewReader(body.Body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for k, vv := range body.Header {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	s.Director()(req)
+	if err := context.Cause(req.Context()); errors.Is(err, ErrMatchingTarget) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.httpcache.ExplainRequest(r.Context(), req)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
 // CacheFlushHandler handles the DELETE request to flush all keys from the cache.
@@ -130,9 +432,37 @@ func (s *Server) CacheConfigUpdateHandler(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 }
 
+// broadcastsPurges returns true if purges, invalidations and bans are broadcasted to other
+// nodes in the cluster, i.e. if the nodes hold a local cache layer. Nodes sharing a single
+// cache would only repeat the same purge.
+func (s *Server) broadcastsPurges() bool {
+	return s.cluster != nil && s.cfg.Provider.Layered
+}
+
+// broadcastInvalidation broadcasts the keys invalidated by an unsafe request to other
+// nodes in the cluster, to delete them from their local cache layer.
+func (s *Server) broadcastInvalidation(keys []string) {
+	if !s.broadcastsPurges() {
+		return
+	}
+	body, err := json.Marshal(keys)
+	if err != nil {
+		log.Error().Err(err).Msg("Error encoding invalidated keys")
+		return
+	}
+	p := path.Join(s.cfg.API.GetPrefix(), "/cache/invalidate/keys")
+	req, err := http.NewRequest(http.MethodDelete, p, bytes.NewReader(body))
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.cluster.Broadcast(req, "api", http.MethodDelete, p)
+}
+
 // broadcastPurge broadcasts a purge request to other nodes in the cluster.
 func (s *Server) broadcastPurge(req *http.Request) {
-	if s.cluster == nil || !s.cfg.Provider.Layered {
+	if !s.broadcastsPurges() {
 		return
 	}
 
@@ -0,0 +1,389 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kacheio/kache/pkg/cache"
+	"github.com/kacheio/kache/pkg/cluster"
+	"github.com/kacheio/kache/pkg/config"
+	"github.com/kacheio/kache/pkg/provider"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheTagPurgeHandlers(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, _ := cache.NewHttpCache(nil, p)
+	srv, err := NewServer(&config.Configuration{}, p, c, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{"A", "B", "E"} {
+		p.Set(key, []byte(key), time.Minute)
+	}
+	p.Tag(ctx, "A", []string{"product-1"}, time.Minute)
+	p.Tag(ctx, "B", []string{"product-2"}, time.Minute)
+
+	// Purge by tag via PURGE request.
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("PURGE", "/", nil)
+	req.Header.Set("X-Purge-Tag", "product-1")
+	srv.CacheKeyPurgeHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, p.Get(ctx, "A"))
+	assert.NotNil(t, p.Get(ctx, "B"))
+
+	// Invalidation broadcasted by another instance deletes the purged keys.
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/cache/invalidate/tags", strings.NewReader(`["E"]`))
+	req.Header.Set("X-Purge-Tag", "product-2")
+	req.Header.Set("X-Kache-Cluster", "Broadcast")
+	srv.CacheInvalidateTagsHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, p.Get(ctx, "B"))
+	assert.Nil(t, p.Get(ctx, "E"))
+
+	// Missing tags.
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/cache/invalidate/tags", nil)
+	srv.CacheInvalidateTagsHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestCachePurgeHandlers(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, _ := cache.NewHttpCache(nil, p)
+	srv, err := NewServer(&config.Configuration{}, p, c, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{
+		"kache-http://example.com/",
+		"kache-http://example.com/assets/main.css",
+		"kache-http://example.com/assets/main.js",
+		"kache-http://example.com/assets/theme.css",
+		"kache-http://example.com/news",
+		"kache-http://example.com/search?q=[a]",
+		"kache-http://example.com/searchXq=a",
+	} {
+		p.Set(key, []byte(key), time.Minute)
+	}
+
+	tests := []struct {
+		header string
+		value  string
+		code   int
+		body   string
+	}{
+		{"X-Purge-Regex", `^kache-http://example\.com/assets/main\.`, http.StatusOK, `{"purged":2}`},
+		{"X-Purge-Glob", "*.css", http.StatusOK, `{"purged":1}`},
+		{"X-Purge-Key", "kache-http://example.com/news", http.StatusOK, `{"purged":1}`},
+		{"X-Purge-Key", "kache-http://example.com/news", http.StatusOK, `{"purged":0}`},
+		{"X-Purge-Key", "kache-http://example.com/search?q=[a]", http.StatusOK, `{"purged":1}`},
+		{"X-Purge-Key", "kache-http://example.com/search?q=a", http.StatusOK, `{"purged":0}`},
+		{"X-Purge-Key", "kache-http://example.com/searchXq=a", http.StatusOK, `{"purged":1}`},
+		{"X-Purge-Regex", "^/assets/(*.css", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("PURGE", "/", nil)
+		req.Header.Set(tt.header, tt.value)
+		srv.CacheKeyPurgeHandler(rr, req)
+		assert.Equal(t, tt.code, rr.Code, tt.value)
+		if tt.body != "" {
+			assert.JSONEq(t, tt.body, rr.Body.String(), tt.value)
+		}
+	}
+	assert.Equal(t, []string{"kache-http://example.com/"}, p.Keys(ctx, ""))
+
+	// Soft purge keeps the entries.
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	c.StoreResponse(ctx, cache.NewLookupRequest(req, time.Now(), true), &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Cache-Control": []string{"max-age=60"}},
+		Body:       http.NoBody,
+	}, time.Now())
+	rr := httptest.NewRecorder()
+	purge := httptest.NewRequest("PURGE", "/", nil)
+	purge.Header.Set("X-Purge-Glob", "kache-http://*")
+	purge.Header.Set("X-Purge-Soft", "true")
+	srv.CacheKeyPurgeHandler(rr, purge)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"purged":1}`, rr.Body.String())
+	assert.Equal(t, 1, p.Size())
+
+	// Invalidate by glob via DELETE request.
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/cache/invalidate", nil)
+	req.Header.Set("X-Purge-Glob", "kache-http://*")
+	srv.CacheInvalidateHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"purged":1}`, rr.Body.String())
+	assert.Equal(t, 0, p.Size())
+}
+
+func TestCacheBansHandler(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, _ := cache.NewHttpCache(nil, p)
+	srv, err := NewServer(&config.Configuration{}, p, c, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	// Add a ban.
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/cache/bans", strings.NewReader(`{"expr": "req.url ~ ^/news"}`))
+	srv.CacheBansHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"expr":"req.url ~ ^/news"`)
+
+	// Broadcasted bans keep their time.
+	rr = httptest.NewRecorder()
+	banTime := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	req = httptest.NewRequest(http.MethodPost, "/api/cache/bans", strings.NewReader(
+		`{"expr": "obj.status == 404", "time": "`+banTime.Format(time.RFC3339)+`"}`))
+	req.Header.Set("X-Kache-Cluster", "Broadcast")
+	srv.CacheBansHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// List bans.
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/cache/bans", nil)
+	srv.CacheBansHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	bans := c.Bans(context.Background())
+	require.Len(t, bans, 2)
+	assert.Equal(t, "obj.status == 404", bans[0].Expr)
+	assert.True(t, banTime.Equal(bans[0].Time))
+	assert.Contains(t, rr.Body.String(), `"expr":"req.url ~ ^/news"`)
+
+	// Invalid ban.
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/cache/bans", strings.NewReader(`{"expr": "req.path ~ ^/news"}`))
+	srv.CacheBansHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestCacheOfflineHandler(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, _ := cache.NewHttpCache(nil, p)
+	srv, err := NewServer(&config.Configuration{}, p, c, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	srv.CacheOfflineHandler(rr, httptest.NewRequest(http.MethodGet, "/api/cache/offline", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"offline": false}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	srv.CacheOfflineHandler(rr, httptest.NewRequest(http.MethodPut, "/api/cache/offline",
+		strings.NewReader(`{"offline": true}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"offline": true}`, rr.Body.String())
+	assert.True(t, c.Offline())
+	assert.True(t, c.Strict(), "other settings are kept")
+
+	rr = httptest.NewRecorder()
+	srv.CacheOfflineHandler(rr, httptest.NewRequest(http.MethodPut, "/api/cache/offline",
+		strings.NewReader(`offline`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.True(t, c.Offline())
+}
+
+func TestCacheOfflineHandlerConcurrent(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, _ := cache.NewHttpCache(&cache.HttpCacheConfig{
+		Timeouts: []cache.Timeout{{Path: "^/news", TTL: time.Hour}},
+	}, p)
+	srv, err := NewServer(&config.Configuration{}, p, c, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	// Toggle the offline mode while requests read the config.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_ = c.Offline()
+					_ = c.PathTTL("/news/today")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		body := fmt.Sprintf(`{"offline": %t}`, i%2 == 0)
+		rr := httptest.NewRecorder()
+		srv.CacheOfflineHandler(rr, httptest.NewRequest(http.MethodPut, "/api/cache/offline",
+			strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	close(done)
+	wg.Wait()
+
+	assert.False(t, c.Offline())
+	assert.Equal(t, time.Hour, c.PathTTL("/news/today"))
+}
+
+func TestCacheExplainHandler(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, _ := cache.NewHttpCache(&cache.HttpCacheConfig{
+		Strict:      true,
+		KeyPolicies: []cache.KeyPolicy{{Path: "^/news", IgnoreQuery: []string{"utm_*"}}},
+	}, p)
+	srv, err := NewServer(&config.Configuration{
+		Upstreams: []*config.Upstream{{Name: "news", Addr: "http://origin.local", Path: "/news"}},
+	}, p, c, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	explain := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.CacheExplainHandler(rr, httptest.NewRequest(http.MethodPost, "/api/cache/explain",
+			strings.NewReader(body)))
+		return rr
+	}
+
+	// The request is keyed by its upstream target.
+	rr := explain(`{"url": "http://kache.local/news?id=1&utm_source=x"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"key": "kache-http://origin.local/news?id=1", "lookup": "EntryInvalid",
+		"path_ttl": 120, "stored": false}`, rr.Body.String())
+
+	rr = explain(`{"method": "GET", "url": "http://kache.local/news", "header": {"Cache-Control": ["no-cache"]},
+		"ignored": true}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = explain(`{"method": "DELETE", "url": "http://kache.local/news"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"bypass":"unsafe method"`)
+
+	rr = explain(`{"url": "http://kache.local/other"}`)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = explain(`url`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// broadcasts is a cluster connection recording the broadcasted requests.
+type broadcasts struct {
+	paths  []string
+	bodies []string
+}
+
+func (b *broadcasts) Endpoints(string) []cluster.Endpoint { return nil }
+
+func (b *broadcasts) Broadcast(req *http.Request, _, _, path string) {
+	body, _ := io.ReadAll(req.Body)
+	b.paths = append(b.paths, path)
+	b.bodies = append(b.bodies, string(body))
+}
+
+func (b *broadcasts) Close() {}
+
+func TestCacheInvalidateKeys(t *testing.T) {
+	p, _ := provider.NewSimpleCache(nil)
+	c, _ := cache.NewHttpCache(nil, p)
+	srv, err := NewServer(&config.Configuration{
+		API:      &config.API{},
+		Provider: &provider.ProviderBackendConfig{Layered: true},
+	}, p, c, prometheus.NewRegistry())
+	require.NoError(t, err)
+	cc := &broadcasts{}
+	srv.cluster = cc
+
+	// Keys invalidated by unsafe requests are broadcasted.
+	srv.broadcastInvalidation([]string{"kache-http://example.com/news"})
+	assert.Equal(t, []string{"/api/cache/invalidate/keys"}, cc.paths)
+	assert.Equal(t, []string{`["kache-http://example.com/news"]`}, cc.bodies)
+
+	// Broadcasted keys are deleted.
+	ctx := context.Background()
+	p.Set("kache-http://example.com/news", []byte("news"), time.Minute)
+	p.Set("kache-http://example.com/news?page=2", []byte("news"), time.Minute)
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/cache/invalidate/keys", strings.NewReader(cc.bodies[0]))
+	srv.CacheInvalidateKeysHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"purged":1}`, rr.Body.String())
+	assert.Nil(t, p.Get(ctx, "kache-http://example.com/news"))
+	assert.NotNil(t, p.Get(ctx, "kache-http://example.com/news?page=2"))
+}
+
+func TestCacheBroadcasts(t *testing.T) {
+	for _, layered := range []bool{false, true} {
+		p, _ := provider.NewSimpleCache(nil)
+		c, _ := cache.NewHttpCache(nil, p)
+		srv, err := NewServer(&config.Configuration{
+			API:      &config.API{},
+			Provider: &provider.ProviderBackendConfig{Layered: layered},
+		}, p, c, prometheus.NewRegistry())
+		require.NoError(t, err)
+		cc := &broadcasts{}
+		srv.cluster = cc
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("PURGE", "/", nil)
+		req.Header.Set("X-Purge-Tag", "product-1")
+		srv.CacheKeyPurgeHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("PURGE", "/", nil)
+		req.Header.Set("X-Purge-Key", "kache-http://example.com/news")
+		srv.CacheKeyPurgeHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = httptest.NewRecorder()
+		srv.CacheBansHandler(rr, httptest.NewRequest(http.MethodPost, "/api/cache/bans",
+			strings.NewReader(`{"expr": "req.url ~ ^/news"}`)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		srv.broadcastInvalidation([]string{"kache-http://example.com/news"})
+
+		// Purges are only broadcasted to instances holding a local cache layer.
+		if layered {
+			assert.Equal(t, []string{
+				"/api/cache/invalidate/tags",
+				"/api/cache/invalidate",
+				"/api/cache/bans",
+				"/api/cache/invalidate/keys",
+			}, cc.paths)
+		} else {
+			assert.Empty(t, cc.paths)
+		}
+	}
+}
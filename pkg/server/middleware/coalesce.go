@@ -23,22 +23,34 @@
 package middleware
 
 import (
-	"bufio"
-	"bytes"
+	"errors"
+	"io"
 	"net/http"
-	"net/http/httputil"
 	"sync"
 
+	"github.com/kacheio/kache/pkg/cache"
 	"github.com/rs/zerolog/log"
 )
 
+// requestSpecificHeaders holds the request header fields which affect the response
+// independently of the Vary header, i.e. preconditions and ranges.
+var requestSpecificHeaders = []string{
+	cache.HeaderRange,
+	cache.HeaderIfRange,
+	cache.HeaderIfMatch,
+	cache.HeaderIfNoneMatch,
+	cache.HeaderIfModifiedSince,
+	cache.HeaderIfUnmodifiedSince,
+}
+
 // requestCoalescer allows concurrent requests for the same URL to share
 // a single upstream request and response. Once a request is received for
 // processing for the first time, it will execute a HTTP transaction, obtaining
 // a response for a given request. If a duplicate request (same URL) comes in
 // at the same time, those threads will wait for the original to complete and receive
-// the same results. The original request's response is copied into the waiting
-// threads, which will then resume their execution.
+// the same results. Once the original request's response header is received, the
+// waiting threads resume their execution and read the response body concurrently,
+// while it is streamed to the original caller.
 type requestCoalescer struct {
 	sync.Mutex
 	inflights map[string]*call
@@ -52,11 +64,45 @@ type requestCoalescer struct {
 type call struct {
 	*sync.Cond // rendezvous point for goroutines.
 
-	// coalesced indicates if there are any calls waiting
+	// waiters is the number of calls waiting
 	// for the initial in-flight request's response.
-	coalesced bool
-	resp      []byte
-	err       error
+	waiters int
+	body    *sharedBody
+	err     error
+
+	// resp is a copy of the initial response, taken before it is returned to the
+	// initial caller, who may modify it while the waiting calls read the copy.
+	// It is nil, if the response is not shared, because its body is too large.
+	resp *http.Response
+
+	// header holds the header of the initial request, used to check if
+	// a negotiated (Vary) response can be shared with waiting requests.
+	header http.Header
+}
+
+// collapser is implemented by round trippers that mark responses shared with
+// coalesced requests.
+type collapser interface {
+	Collapsed(resp *http.Response)
+}
+
+// bodyReader is implemented by round trippers that key POST requests by their body.
+// ReadBody returns the request with a replayable body, see cache.HttpCache.ReadBody.
+type bodyReader interface {
+	ReadBody(req *http.Request) (*http.Request, error)
+}
+
+// cacheKeyer is implemented by round trippers that cache responses. CacheKey returns
+// the key the response to the request is cached under, see cache.HttpCache.NewLookup.
+type cacheKeyer interface {
+	CacheKey(req *http.Request) *cache.Key
+}
+
+// bodyLimiter is implemented by round trippers that limit the size of response bodies
+// stored in the cache, see cache.HttpCache.MaxBodySize. Response bodies are shared with
+// waiting requests up to this size.
+type bodyLimiter interface {
+	MaxBodySize() int64
 }
 
 // NewCoalesced returns a coalesced http roundtripper.
@@ -67,18 +113,24 @@ func NewCoalesced(next http.RoundTripper) http.RoundTripper {
 	}
 }
 
-// RoundTrip executes and returns the given request and coalesces
-// concurrent GET requests. It ensures that only one execution call is
-// in-flight for a given key at a time. Following duplicate or similar (same URL)
-// requests are blocked until the original request completes. The resulting
-// response is shared with all waiting requests.
+// RoundTrip executes and returns the given request and coalesces concurrent GET
+// requests, and POST requests keyed by their body. It ensures that only one execution
+// call is in-flight for a given key at a time. Following duplicate or similar (same cache
+// key and credential) requests are blocked until the original request completes. The
+// resulting response is shared with all waiting requests.
 func (coalescer *requestCoalescer) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Only coalesce GET requests.
-	if req.Method != http.MethodGet {
-		return coalescer.next.RoundTrip(req)
+	if b, ok := coalescer.next.(bodyReader); ok && req.Method == http.MethodPost {
+		var err error
+		if req, err = b.ReadBody(req); err != nil {
+			return nil, err
+		}
 	}
 
-	key := req.URL.String()
+	// Only coalesce GET requests, and POST requests keyed by their body.
+	if req.Method != http.MethodGet && (req.Method != http.MethodPost || cache.BodyHash(req) == "") {
+		return coalescer.next.RoundTrip(req)
+	}
+	key := coalescer.key(req)
 	coalescer.Lock()
 	inflight, ok := coalescer.inflights[key]
 	if ok {
@@ -100,7 +152,7 @@ func (coalescer *requestCoalescer) RoundTrip(req *http.Request) (*http.Response,
 		coalescer.Unlock()
 
 		// Mark that there is at least one call awaiting a shared response.
-		inflight.coalesced = true
+		inflight.waiters++
 
 		// Suspend execution of current thread until inflight request is processed.
 		inflight.Wait()
@@ -112,11 +164,26 @@ func (coalescer *requestCoalescer) RoundTrip(req *http.Request) (*http.Response,
 		if inflight.err != nil {
 			return nil, inflight.err
 		}
-		// Copy initial response to waiting caller.
-		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewBuffer(inflight.resp)), nil)
-		if err != nil {
-			log.Error().Err(err).Str("key", key).Msg("Error loading response")
-			return nil, err
+		// The response is too large to be shared. Issue a dedicated request.
+		if inflight.resp == nil {
+			return coalescer.next.RoundTrip(req)
+		}
+		// Share initial response with waiting caller.
+		resp := new(http.Response)
+		*resp = *inflight.resp
+		resp.Header = inflight.resp.Header.Clone()
+		resp.Body = &sharedBodyReader{shared: inflight.body}
+		resp.Request = req
+
+		// The shared response may have been selected by request headers that differ
+		// from the ones of the waiting request. If so, issue a dedicated request.
+		selecting := append(cache.ParseVary(resp.Header), requestSpecificHeaders...)
+		if !cache.VaryMatches(selecting, inflight.header, req.Header) {
+			_ = resp.Body.Close()
+			return coalescer.next.RoundTrip(req)
+		}
+		if c, ok := coalescer.next.(collapser); ok {
+			c.Collapsed(resp)
 		}
 		return resp, nil
 	}
@@ -124,7 +191,7 @@ func (coalescer *requestCoalescer) RoundTrip(req *http.Request) (*http.Response,
 	// No similar request in flight (common case).
 
 	// Register a new call and execute the request.
-	inflight = &call{Cond: sync.NewCond(&sync.Mutex{})}
+	inflight = &call{Cond: sync.NewCond(&sync.Mutex{}), header: req.Header.Clone()}
 	coalescer.inflights[key] = inflight
 	coalescer.Unlock()
 
@@ -140,12 +207,22 @@ func (coalescer *requestCoalescer) RoundTrip(req *http.Request) (*http.Response,
 
 	// Wake up any suspended callers waiting for this response.
 	inflight.L.Lock()
-	if inflight.coalesced {
-		if err != nil {
+	if inflight.waiters > 0 {
+		limit := cache.DefaultMaxBodySize
+		if l, ok := coalescer.next.(bodyLimiter); ok {
+			limit = l.MaxBodySize()
+		}
+		switch {
+		case err != nil:
 			inflight.resp, inflight.err = nil, err
-		} else {
-			// Copy the response before releasing it to waiter(s).
-			inflight.resp, inflight.err = httputil.DumpResponse(resp, true)
+		case resp.ContentLength > limit:
+			// Don't share responses too large to be buffered.
+			inflight.resp = nil
+		default:
+			// Share the response body with the waiter(s) while it is read.
+			inflight.body = newSharedBody(resp.Body, limit, inflight.waiters)
+			resp.Body = inflight.body
+			inflight.resp = copyResponse(resp)
 		}
 		// Wake up all waiting routines.
 		inflight.Broadcast()
@@ -159,3 +236,165 @@ func (coalescer *requestCoalescer) RoundTrip(req *http.Request) (*http.Response,
 
 	return resp, nil
 }
+
+// key returns the key of the request used to coalesce similar requests, i.e. the cache
+// key of the request, including the fields folded in by a key policy. Requests with
+// different credentials may be answered differently and are keyed by their credential.
+func (coalescer *requestCoalescer) key(req *http.Request) string {
+	key := cache.NewKeyFromRequst(req)
+	if k, ok := coalescer.next.(cacheKeyer); ok {
+		key = k.CacheKey(req)
+	}
+	key.Credential = cache.CredentialHash(req)
+	return key.String()
+}
+
+// copyResponse returns a copy of the response without body. The header and trailer
+// are cloned, so that the copy is not affected by modifications of the response.
+func copyResponse(resp *http.Response) *http.Response {
+	res := *resp
+	res.Header = resp.Header.Clone()
+	res.Trailer = resp.Trailer.Clone()
+	res.Body = nil
+	return &res
+}
+
+// errSharedBodyTooLarge is returned to waiting callers reading a shared body, which
+// exceeds the size limit of the buffered bytes.
+var errSharedBodyTooLarge = errors.New("shared response body too large")
+
+// sharedBody is the response body of the initial request shared with the waiting
+// requests. The bytes read from the body are buffered up to the limit, so that waiting
+// callers can read them concurrently via a sharedBodyReader. Beyond the limit, the body
+// is no longer shared and waiting callers fail to read it. The initial caller reads the
+// body from upstream; once it is closed, the waiting callers read the remaining body.
+// The body is closed when the last reader is closed.
+type sharedBody struct {
+	body  io.ReadCloser
+	limit int64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	// err is io.EOF once the body has been read completely, or the
+	// error reading it, or errSharedBodyTooLarge.
+	err error
+
+	// closed indicates that the initial caller closed the body,
+	// reading is set while a waiting caller reads from the body.
+	closed, reading bool
+
+	// readers is the number of open readers, including the initial caller.
+	readers int
+}
+
+// newSharedBody returns a shared body reading from the given body, buffering at most
+// limit bytes for the given number of waiting callers.
+func newSharedBody(body io.ReadCloser, limit int64, waiters int) *sharedBody {
+	b := &sharedBody{body: body, limit: limit, readers: waiters + 1}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Read reads from the underlying body and makes the bytes read available to waiters.
+func (b *sharedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+
+	b.mu.Lock()
+	b.share(p[:n], err)
+	b.mu.Unlock()
+	b.cond.Broadcast()
+
+	return n, err
+}
+
+// share buffers the bytes read from the body for the waiting callers. If the limit is
+// exceeded, the buffer is released and the waiting callers fail. Guarded by caller.
+func (b *sharedBody) share(p []byte, err error) {
+	if b.err != nil {
+		return
+	}
+	if int64(len(b.buf)+len(p)) > b.limit {
+		b.buf, b.err = nil, errSharedBodyTooLarge
+		return
+	}
+	b.buf = append(b.buf, p...)
+	b.err = err
+}
+
+// fill reads the next bytes from the body on behalf of a waiting caller, after the
+// initial caller closed the body. Guarded by caller, who must not be reading already.
+func (b *sharedBody) fill(n int) {
+	b.reading = true
+	b.mu.Unlock()
+	p := make([]byte, n)
+	n, err := b.body.Read(p)
+	b.mu.Lock()
+	b.reading = false
+	b.share(p[:n], err)
+	b.cond.Broadcast()
+}
+
+// Close closes the body for the initial caller. The waiting callers read the remaining body.
+func (b *sharedBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.cond.Broadcast()
+	return b.release()
+}
+
+// release releases a reader and closes the body, when the last reader is released.
+// Guarded by caller.
+func (b *sharedBody) release() error {
+	b.readers--
+	if b.readers > 0 {
+		return nil
+	}
+	if b.err == nil {
+		b.err = io.ErrClosedPipe
+	}
+	return b.body.Close()
+}
+
+// sharedBodyReader reads a shared body on behalf of a waiting caller.
+type sharedBodyReader struct {
+	shared *sharedBody
+	offset int
+	closed bool
+}
+
+// Read reads the bytes of the shared body, waiting until they are available.
+func (r *sharedBodyReader) Read(p []byte) (int, error) {
+	b := r.shared
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for r.offset >= len(b.buf) && b.err == nil {
+		if b.closed && !b.reading {
+			b.fill(max(len(p), 512))
+			continue
+		}
+		b.cond.Wait()
+	}
+	if r.offset < len(b.buf) {
+		n := copy(p, b.buf[r.offset:])
+		r.offset += n
+		return n, nil
+	}
+	return 0, b.err
+}
+
+// Close closes the reader. The shared body is closed, when the last reader is closed.
+func (r *sharedBodyReader) Close() error {
+	b := r.shared
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return b.release()
+}
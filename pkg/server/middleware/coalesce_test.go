@@ -29,10 +29,14 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/kacheio/kache/pkg/cache"
+	"github.com/kacheio/kache/pkg/provider"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -62,6 +66,13 @@ func (t *upstream) RoundTrip(req *http.Request) (*http.Response, error) {
 	}, nil
 }
 
+// roundTripperFunc is an adapter to use a function as http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func TestCoalescedRoundTrip(t *testing.T) {
 	// For concurrent requests to the same resource,
 	// only 1 request should hit the upstream.
@@ -107,6 +118,406 @@ func TestCoalescedRoundTrip(t *testing.T) {
 	assert.Equal(t, expected, upstream.hits)
 }
 
+func TestCoalescedRoundTripRequestSpecificHeaders(t *testing.T) {
+	// Waiting requests with different ranges or preconditions
+	// must not share the response of the initial request.
+
+	upstream := &upstream{
+		hits: make(map[string]int),
+		wait: make(chan struct{}),
+	}
+	coalesced := NewCoalesced(upstream)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, ra := range []string{"", "bytes=0-1"} {
+		go func(ra string) {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodGet, "http://test.com/coalesced", nil)
+			require.NoError(t, err)
+			req.Header.Set("coalesced", "1")
+			if ra != "" {
+				req.Header.Set("Range", ra)
+			}
+			resp, err := coalesced.RoundTrip(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+		}(ra)
+	}
+
+	// Add some grace time to wait for all requests to be made.
+	time.Sleep(100 * time.Millisecond)
+	close(upstream.wait)
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"/coalesced": 2}, upstream.hits)
+}
+
+func TestCoalescedRoundTripStreamed(t *testing.T) {
+	// Waiting requests receive the response body while it is streamed
+	// to the initial request, before the upstream completes.
+
+	pr, pw := io.Pipe()
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(100 * time.Millisecond)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: pr}, nil
+	})
+	coalesced := NewCoalesced(upstream)
+
+	var wg sync.WaitGroup
+	bodies := make([]io.ReadCloser, 2)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodGet, "http://test.com/streamed", nil)
+			require.NoError(t, err)
+			resp, err := coalesced.RoundTrip(req)
+			require.NoError(t, err)
+			bodies[i] = resp.Body
+		}(i)
+	}
+	wg.Wait()
+
+	go func() {
+		_, _ = pw.Write([]byte("first"))
+	}()
+
+	// The initial caller is the one reading from the upstream.
+	readers := make(chan string, 2)
+	for _, body := range bodies {
+		go func(body io.ReadCloser) {
+			buf := make([]byte, 5)
+			_, _ = io.ReadFull(body, buf)
+			readers <- string(buf)
+		}(body)
+	}
+	for range bodies {
+		select {
+		case first := <-readers:
+			assert.Equal(t, "first", first)
+		case <-time.After(3 * time.Second):
+			t.Fatal("body not streamed to coalesced request")
+		}
+	}
+
+	_ = pw.Close()
+
+	// Waiters read the body at the pace of the initial caller.
+	wg.Add(len(bodies))
+	for _, body := range bodies {
+		go func(body io.ReadCloser) {
+			defer wg.Done()
+			rest, err := io.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Empty(t, rest)
+			assert.NoError(t, body.Close())
+		}(body)
+	}
+	wg.Wait()
+}
+
+func TestCoalescedRoundTripResponseModified(t *testing.T) {
+	// The initial caller may modify its response, while waiting requests copy it.
+
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(100 * time.Millisecond)
+		header := http.Header{}
+		header.Set("Etag", `"a1"`)
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: http.NoBody}, nil
+	})
+	coalesced := NewCoalesced(upstream)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodGet, "http://test.com/modified", nil)
+			require.NoError(t, err)
+			resp, err := coalesced.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, `"a1"`, resp.Header.Get("Etag"))
+			assert.Empty(t, resp.Header.Get("X-Caller"))
+			resp.Header.Del("Etag")
+			resp.Header.Set("X-Caller", fmt.Sprint(i))
+		}(i)
+	}
+	wg.Wait()
+}
+
+// limited is a test transport limiting the size of shared response bodies.
+type limited struct {
+	http.RoundTripper
+	limit int64
+}
+
+func (t *limited) MaxBodySize() int64 {
+	return t.limit
+}
+
+func TestCoalescedRoundTripLimit(t *testing.T) {
+	// Response bodies are shared up to the body size limit only.
+
+	for _, length := range []int64{-1, 10} {
+		var mu sync.Mutex
+		hits := 0
+		upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			hits++
+			mu.Unlock()
+			time.Sleep(100 * time.Millisecond)
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: length,
+				Body: io.NopCloser(strings.NewReader("0123456789"))}, nil
+		})
+		coalesced := NewCoalesced(&limited{RoundTripper: upstream, limit: 4})
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				req, err := http.NewRequest(http.MethodGet, "http://test.com/large", nil)
+				require.NoError(t, err)
+				resp, err := coalesced.RoundTrip(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+				var body []byte
+				if body, errs[i] = io.ReadAll(resp.Body); errs[i] == nil {
+					assert.Equal(t, "0123456789", string(body))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if length < 0 {
+			// The body exceeds the limit while it is read, waiting requests fail.
+			assert.Equal(t, 1, hits)
+			assert.ElementsMatch(t, []error{nil, errSharedBodyTooLarge}, errs)
+		} else {
+			// The body is known to exceed the limit, waiting requests are sent upstream.
+			assert.Equal(t, 2, hits)
+			assert.Equal(t, []error{nil, nil}, errs)
+		}
+	}
+}
+
+// trackedBody is a test response body tracking whether it is closed.
+type trackedBody struct {
+	*strings.Reader
+	closed atomic.Bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed.Store(true)
+	return nil
+}
+
+func TestCoalescedRoundTripInitialClosed(t *testing.T) {
+	// If the initial caller closes the body early, the waiting requests read the
+	// remaining body. The body is closed by the last reader, not drained.
+
+	upstreamBody := &trackedBody{Reader: strings.NewReader("0123456789")}
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(100 * time.Millisecond)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: upstreamBody}, nil
+	})
+	coalesced := NewCoalesced(upstream)
+
+	var wg sync.WaitGroup
+	bodies := make([]io.ReadCloser, 2)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodGet, "http://test.com/closed", nil)
+			require.NoError(t, err)
+			resp, err := coalesced.RoundTrip(req)
+			require.NoError(t, err)
+			bodies[i] = resp.Body
+		}(i)
+	}
+	wg.Wait()
+
+	initial, waiting := bodies[0], bodies[1]
+	if _, ok := initial.(*sharedBody); !ok {
+		initial, waiting = waiting, initial
+	}
+
+	buf := make([]byte, 2)
+	_, err := io.ReadFull(initial, buf)
+	require.NoError(t, err)
+	require.NoError(t, initial.Close())
+	assert.False(t, upstreamBody.closed.Load())
+	assert.Equal(t, 8, upstreamBody.Len())
+
+	body, err := io.ReadAll(waiting)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+	assert.False(t, upstreamBody.closed.Load())
+
+	require.NoError(t, waiting.Close())
+	assert.True(t, upstreamBody.closed.Load())
+}
+
+// bodyKeyed is a test transport keying POST requests by their body.
+type bodyKeyed struct {
+	http.RoundTripper
+	cache *cache.HttpCache
+}
+
+func (t *bodyKeyed) ReadBody(req *http.Request) (*http.Request, error) {
+	return t.cache.ReadBody(req)
+}
+
+func TestCoalescedRoundTripPost(t *testing.T) {
+	// Concurrent POST requests with the same body share a single upstream request.
+
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := cache.NewHttpCache(&cache.HttpCacheConfig{Post: []cache.Post{{Path: "^/search"}}}, p)
+	require.NoError(t, err)
+
+	wait := make(chan struct{})
+	var mu sync.Mutex
+	bodies := map[string]int{}
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		bodies[string(body)]++
+		mu.Unlock()
+		<-wait
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(body))}, nil
+	})
+	coalesced := NewCoalesced(&bodyKeyed{RoundTripper: upstream, cache: c})
+
+	var wg sync.WaitGroup
+	for _, query := range []string{`{"q":"a"}`, `{"q":"a"}`, `{"q":"a"}`, `{"q":"b"}`} {
+		wg.Add(1)
+		go func(query string) {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodPost, "http://test.com/search", strings.NewReader(query))
+			require.NoError(t, err)
+			resp, err := coalesced.RoundTrip(req)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, query, string(body))
+		}(query)
+	}
+
+	// Add some grace time to wait for all requests to be made.
+	time.Sleep(100 * time.Millisecond)
+	close(wait)
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{`{"q":"a"}`: 1, `{"q":"b"}`: 1}, bodies)
+}
+
+func TestCoalescedRoundTripCredentials(t *testing.T) {
+	// Concurrent requests with different credentials never share a response.
+
+	wait := make(chan struct{})
+	var mu sync.Mutex
+	hits := map[string]int{}
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		credential := req.Header.Get("Authorization")
+		mu.Lock()
+		hits[credential]++
+		mu.Unlock()
+		<-wait
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{},
+			Body: io.NopCloser(strings.NewReader(credential))}, nil
+	})
+	coalesced := NewCoalesced(upstream)
+
+	var wg sync.WaitGroup
+	for _, credential := range []string{"Bearer alice", "Bearer alice", "Bearer bob", "Bearer bob"} {
+		wg.Add(1)
+		go func(credential string) {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodGet, "http://test.com/account", nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", credential)
+			resp, err := coalesced.RoundTrip(req)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, credential, string(body))
+		}(credential)
+	}
+
+	// Add some grace time to wait for all requests to be made.
+	time.Sleep(100 * time.Millisecond)
+	close(wait)
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"Bearer alice": 1, "Bearer bob": 1}, hits)
+}
+
+// cacheKeyed is a test transport keying requests by their cache key.
+type cacheKeyed struct {
+	http.RoundTripper
+	cache *cache.HttpCache
+}
+
+func (t *cacheKeyed) CacheKey(req *http.Request) *cache.Key {
+	return t.cache.NewLookup(req, time.Now()).Key
+}
+
+func TestCoalescedRoundTripCacheKey(t *testing.T) {
+	// Concurrent requests share a response if they share the cache key,
+	// i.e. regardless of ignored query parameters, but by folded cookies.
+
+	p, _ := provider.NewSimpleCache(nil)
+	c, err := cache.NewHttpCache(&cache.HttpCacheConfig{KeyPolicies: []cache.KeyPolicy{
+		{Path: "^/news", IgnoreQuery: []string{"utm_*"}, Cookies: []string{"lang"}},
+	}}, p)
+	require.NoError(t, err)
+
+	wait := make(chan struct{})
+	var mu sync.Mutex
+	hits := map[string]int{}
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		lang, _ := req.Cookie("lang")
+		mu.Lock()
+		hits[lang.Value]++
+		mu.Unlock()
+		<-wait
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{},
+			Body: io.NopCloser(strings.NewReader(lang.Value))}, nil
+	})
+	coalesced := NewCoalesced(&cacheKeyed{RoundTripper: upstream, cache: c})
+
+	requests := []struct{ query, lang string }{
+		{"utm_source=a", "en"}, {"utm_source=b", "en"}, {"", "de"}, {"utm_source=a", "de"},
+	}
+	var wg sync.WaitGroup
+	for _, r := range requests {
+		wg.Add(1)
+		go func(query, lang string) {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodGet, "http://test.com/news?"+query, nil)
+			require.NoError(t, err)
+			req.AddCookie(&http.Cookie{Name: "lang", Value: lang})
+			resp, err := coalesced.RoundTrip(req)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, lang, string(body))
+		}(r.query, r.lang)
+	}
+
+	// Add some grace time to wait for all requests to be made.
+	time.Sleep(100 * time.Millisecond)
+	close(wait)
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"en": 1, "de": 1}, hits)
+}
+
 //nolint:revive
 func doRequest(t *testing.T, rt http.RoundTripper, path string, coalesced bool) (*http.Response, error) {
 	u, err := url.Parse("http://test.com" + path)
@@ -25,8 +25,12 @@ package middleware
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kacheio/kache/pkg/cache"
@@ -35,8 +39,13 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// backgroundRevalidationTimeout is the timeout of a background revalidation request.
+const backgroundRevalidationTimeout = 30 * time.Second
+
 type metrics struct {
-	hits, misses prometheus.Counter
+	hits, misses, stale, staleIfError prometheus.Counter
+
+	revalidations, revalidationErrors prometheus.Counter
 }
 
 func newMetrics(reg prometheus.Registerer) *metrics {
@@ -44,9 +53,17 @@ func newMetrics(reg prometheus.Registerer) *metrics {
 		Name: "kache_http_cache_requests_total",
 		Help: "Total number of http cache requests.",
 	}, []string{"result"})
+	revalidations := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "kache_http_cache_background_revalidations_total",
+		Help: "Total number of background revalidations of stale cache entries.",
+	}, []string{"result"})
 	return &metrics{
-		hits:   requests.WithLabelValues("hit"),
-		misses: requests.WithLabelValues("miss"),
+		hits:               requests.WithLabelValues("hit"),
+		misses:             requests.WithLabelValues("miss"),
+		stale:              requests.WithLabelValues("stale"),
+		staleIfError:       requests.WithLabelValues("stale_if_error"),
+		revalidations:      revalidations.WithLabelValues("success"),
+		revalidationErrors: revalidations.WithLabelValues("error"),
 	}
 }
 
@@ -63,6 +80,18 @@ type Transport struct {
 
 	// currentTime holds the time source.
 	currentTime func() time.Time
+
+	// revalidating holds the keys of the entries currently being
+	// revalidated in the background, to deduplicate revalidations.
+	revalidating sync.Map
+
+	// OnInvalidate, if set, is called with the keys of the stored responses
+	// invalidated by an unsafe request, e.g. to notify other instances.
+	OnInvalidate func(keys []string)
+
+	// AllowExplain, if set, reports whether the client of the request is allowed to request
+	// an explanation of how the cache handles the request, see HttpCacheConfig.ExplainHeader.
+	AllowExplain func(req *http.Request) bool
 }
 
 // NewTransport returns a new Transport with the provided Cache implementation.
@@ -92,36 +121,144 @@ func NewCachedTransport(c *cache.HttpCache, reg prometheus.Registerer) *Transpor
 
 // RoundTrip issues a http roundtrip and applies the http caching logic.
 func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	if req, err = t.ReadBody(req); err != nil {
+		return nil, err
+	}
 	ctx := req.Context()
 
-	if t.Cache.IsExcludedPath(req.URL.Path) {
-		log.Debug().Interface("path", req.URL.Path).Str("x-cache", "PASS").Msg("Ignoring excluded path")
-		return t.send(req)
+	req, explanation := t.explain(req)
+	if explanation != nil {
+		defer func() {
+			if err == nil {
+				t.Cache.AddExplanation(resp.Header, explanation)
+			}
+		}()
 	}
 
-	if t.Cache.IsExcludedHeader(req.Header) {
-		log.Debug().Interface("header", req.Header).Str("x-cache", "PASS").Msg("Ignoring excluded header")
-		return t.send(req)
+	// Surrogate-Control is removed from, and header edits of matching rules
+	// are applied to, any response served downstream.
+	defer func() {
+		if err == nil {
+			resp.Header.Del(cache.HeaderSurrogateControl)
+			t.editResponse(req, resp)
+		}
+	}()
+
+	reason := t.Cache.BypassReason(req)
+	if explanation != nil {
+		explanation.Bypass = reason
 	}
 
-	if !cache.IsCacheableRequest(req) {
+	switch reason {
+	case cache.BypassUnsafeMethod:
+		return t.sendUnsafe(ctx, req)
+
+	case cache.BypassRule:
+		log.Debug().Interface("path", req.URL.Path).Str("x-cache", "PASS").Msg("Bypassing cache by rule")
+		return t.bypass(req, cache.FwdBypass)
+
+	case cache.BypassExcludedPath:
+		log.Debug().Interface("path", req.URL.Path).Str("x-cache", "PASS").Msg("Ignoring excluded path")
+		return t.bypass(req, cache.FwdBypass)
+
+	case cache.BypassExcludedHeader:
+		log.Debug().Interface("header", req.Header).Str("x-cache", "PASS").Msg("Ignoring excluded header")
+		return t.bypass(req, cache.FwdBypass)
+
+	case cache.BypassUncacheableRequest:
 		log.Debug().Interface("header", req.Header).Str("x-cache", "PASS").Msg("Ignoring uncachable request")
-		if t.Cache.MarkCachedResponses() {
-			req.Header.Set(t.Cache.XCacheHeader(), cache.MISS)
+		fwd := cache.FwdBypass
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			fwd = cache.FwdMethod
+		}
+		resp, err = t.bypass(req, fwd)
+		if err != nil {
+			return resp, err
 		}
-		return t.send(req)
+		t.markMiss(resp)
+		return resp, nil
+	}
+
+	lookup := t.newLookup(req)
+	lookup.Explanation = explanation
+
+	// Sliced responses answer client preconditions and ranges themselves.
+	if size := t.Cache.SliceSize(req.URL.Path); size > 0 && req.Method == http.MethodGet {
+		return t.serveSliced(ctx, lookup, size)
+	}
+
+	resp, status, err := t.serve(ctx, lookup)
+	if err != nil {
+		return resp, err
+	}
+	if status.HasTTL || status.Stored {
+		t.setBrowserCacheControl(lookup.Request, resp)
+	}
+
+	// Answer client preconditions and ranges based on the served response.
+	if cache.IsConditionalRequest(lookup.Request) {
+		resp = cache.ConditionalResponse(lookup.Request, resp)
+	}
+	if cache.IsRangeRequest(lookup.Request) {
+		resp = cache.RangeResponse(lookup.Request, resp)
+	}
+	t.Cache.AddCacheStatus(resp.Header, status)
+	return resp, nil
+}
+
+// sendUnsafe sends the unsafe request upstream and invalidates the stored
+// responses affected by a successful response.
+func (t *Transport) sendUnsafe(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := t.bypass(req, cache.FwdMethod)
+	if err != nil {
+		return resp, err
 	}
+	t.markMiss(resp)
+	t.invalidated(t.Cache.Invalidate(ctx, req, resp))
+	return resp, nil
+}
+
+// invalidated notifies about the keys of invalidated responses.
+func (t *Transport) invalidated(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	log.Debug().Strs("cache-keys", keys).Msg("Invalidated cache entries")
+	if t.OnInvalidate != nil {
+		t.OnInvalidate(keys)
+	}
+}
 
-	lookup := cache.NewLookupRequest(req, t.currentTime(), t.Cache.Strict())
+// serve serves the lookup request either from the cache, or from upstream.
+// It returns the response along with the status describing how it was served.
+func (t *Transport) serve(ctx context.Context, lookup *cache.LookupRequest) (*http.Response,
+	cache.CacheStatus, error) {
 	cacheKey := lookup.Key.String()
 
 	log.Debug().Str("cache-key", cacheKey).Msg("Lookup response")
 	cached := t.Cache.FetchResponse(ctx, *lookup)
+	t.Cache.Explain(lookup, cached)
+
+	if t.cacheOnly(lookup.Request) {
+		return t.serveCacheOnly(cacheKey, lookup.Request, cached)
+	}
+
+	// Client preconditions and ranges are evaluated by the cache, hence they are not
+	// forwarded upstream, to get a complete response which can be stored in the cache.
+	req := upstreamRequest(lookup.Request)
 
 	switch cached.Status {
 	case cache.EntryOk:
 		t.metrics.hits.Inc()
-		return t.handleCacheHit(cacheKey, cached)
+		return t.handleCacheHit(cacheKey, cached), hitStatus(cacheKey, cached), nil
+
+	case cache.EntryStaleWhileRevalidate:
+		t.metrics.stale.Inc()
+		t.revalidateAsync(lookup)
+		cached.Header().Set(cache.HeaderWarning, cache.WarningResponseIsStale)
+		status := hitStatus(cacheKey, cached)
+		status.Detail = "stale"
+		return t.handleCacheHit(cacheKey, cached), status, nil
 
 	case cache.EntryRequiresValidation:
 		if t.Cache.MarkCachedResponses() {
@@ -129,7 +266,7 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 		}
 		log.Debug().Str("cache-key", cacheKey).Interface("header", cached.Header()).
 			Msg("Cache HIT with validation")
-		req = t.injectValidationHeaders(lookup.Request, cached.Header())
+		req = t.injectValidationHeaders(req, cached.Header())
 
 	case cache.EntryInvalid:
 		t.metrics.misses.Inc()
@@ -139,15 +276,76 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 		log.Error().Str("cache-key", cacheKey).Str("x-cache", "ERROR").Msg("Error while retrieving the response")
 	}
 
+	return t.fetch(ctx, req, lookup, cached)
+}
+
+// serveCacheOnly serves the request from the cache only, without contacting the origin.
+// While the cache is offline, stale responses are served as well. If no suitable response
+// is stored, the request is answered with 504 (Gateway Timeout).
+// https://httpwg.org/specs/rfc9111.html#cache-request-directive.only-if-cached
+func (t *Transport) serveCacheOnly(key string, req *http.Request,
+	cached *cache.LookupResult) (*http.Response, cache.CacheStatus, error) {
+	offline := t.Cache.Offline()
+	switch {
+	case cached.Status == cache.EntryOk:
+		t.metrics.hits.Inc()
+		return t.handleCacheHit(key, cached), hitStatus(key, cached), nil
+
+	case cached.Status == cache.EntryStaleWhileRevalidate,
+		offline && cached.Status == cache.EntryRequiresValidation:
+		t.metrics.stale.Inc()
+		cached.Header().Set(cache.HeaderWarning, cache.WarningResponseIsStale)
+		if offline {
+			cached.Header().Add(cache.HeaderWarning, cache.WarningDisconnectedOperation)
+		}
+		status := hitStatus(key, cached)
+		status.Detail = "stale"
+		return t.handleCacheHit(key, cached), status, nil
+	}
+
+	t.metrics.misses.Inc()
+	log.Debug().Str("cache-key", key).Bool("offline", offline).Str("x-cache", "MISS").
+		Msg("No suitable cached response, not calling upstream")
+	return gatewayTimeout(req), t.cacheOnlyStatus(key), nil
+}
+
+// fetch sends the request upstream and stores the new or validated response in the cache.
+// It returns the response along with the status describing how it was served.
+func (t *Transport) fetch(ctx context.Context, req *http.Request, lookup *cache.LookupRequest,
+	cached *cache.LookupResult) (*http.Response, cache.CacheStatus, error) {
+	status := cache.CacheStatus{Fwd: forwardReason(cached), Key: lookup.Key.String()}
+
 	// Send request to upstream.
-	resp, err = t.send(req)
+	resp, err := t.send(req)
+	if err == nil && resp != nil {
+		status.FwdStatus = resp.StatusCode
+	}
+
+	// Serve the stale cached response if the origin fails and stale-if-error
+	// permits it. The cached entry is left untouched.
+	if isOriginError(resp, err) && cached.Status != cache.EntryInvalid &&
+		lookup.AllowsStaleIfError(cached, t.Cache.StaleIfError()) {
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		log.Debug().Err(err).Str("cache-key", lookup.Key.String()).Str("x-cache", "STALE").
+			Msg("Origin failed, serving stale response")
+		t.metrics.staleIfError.Inc()
+		cached.Header().Set(cache.HeaderWarning, cache.WarningRevalidationFailed)
+		status.TTL, status.HasTTL = cached.TTL(), true
+		status.Detail = "stale-if-error"
+		return t.handleCacheHit(lookup.Key.String(), cached), status, nil
+	}
+
 	if err != nil {
 		log.Error().Err(err).Msgf("RoundTrip: error: %v", err)
-		return resp, err
+		return resp, status, err
 	}
 
 	shouldUpdateCachedEntry := true
-	if resp.StatusCode == http.StatusNotModified {
+	validated := resp.StatusCode == http.StatusNotModified && cached.Status != cache.EntryInvalid
+	if validated {
 		// If the 304 response contains a strong validator (etag) that does not match
 		// the cached response, the cached response should not be updated.
 		resEtag := resp.Header.Get(cache.HeaderEtag)
@@ -165,40 +363,285 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 		resp = cached.Response()
 	}
 
-	// Set or update custom cache control header.
+	// Set or update custom cache control header, unless overridden by a rule.
 	updateCacheControl(resp.Header, t.Cache.DefaultCacheControl(), t.Cache.ForceCacheControl())
+	t.Cache.RewriteCacheControl(lookup.Request, resp)
 
-	// Check cacheability depending on cache mode.
-	cacheable := true
-	if t.Cache.Strict() {
-		cacheable = (cache.IsCacheableResponse(resp) && !lookup.ReqCacheControl.NoStore)
+	reason := t.Cache.UnstorableReason(lookup, resp)
+	if reason == "" && !shouldUpdateCachedEntry {
+		reason = cache.ReasonValidatorMismatch
 	}
 
-	// Store new or update validated response.
-	if cacheable && shouldUpdateCachedEntry && lookup.Request.Method != "HEAD" &&
-		!t.Cache.IsExcludedContent(resp.Header.Get("Content-Type"), resp.ContentLength) {
-		t.Cache.StoreResponse(context.Background(), lookup, resp, t.currentTime())
-	} else {
+	switch reason {
+	case cache.ReasonPartialContent, cache.ReasonAuthorization:
+		// Partial responses must never replace a complete cached response. A response to
+		// an authorized request, which must not be stored by a shared cache, must not
+		// replace the stored response either.
+
+	case "":
+		// Store new or update validated response. A new response is
+		// stored while its body is streamed downstream.
+		if validated {
+			t.Cache.StoreResponse(context.Background(), lookup, resp, t.currentTime())
+		} else {
+			t.Cache.StreamResponse(context.Background(), lookup, resp, t.currentTime())
+		}
+		status.Stored = true
+
+	default:
 		t.Cache.Delete(ctx, lookup)
 	}
+	lookup.Explanation.SetStored(status.Stored, reason)
 
-	return resp, nil
+	return resp, status, nil
+}
+
+// revalidateAsync revalidates the stale cached response in the background and updates
+// the cache entry. Concurrent revalidations of the same entry are deduplicated.
+func (t *Transport) revalidateAsync(lookup *cache.LookupRequest) {
+	key := lookup.Key.String()
+	if _, inflight := t.revalidating.LoadOrStore(key, struct{}{}); inflight {
+		return
+	}
+
+	go func() {
+		defer t.revalidating.Delete(key)
+
+		// The downstream request may be canceled before the revalidation completes, so the
+		// revalidation is detached from the cancelation of the original request context, but
+		// keeps its values, e.g. the body hash. A buffered request body is replayed.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(lookup.Request.Context()),
+			backgroundRevalidationTimeout)
+		defer cancel()
+
+		breq := lookup.Request.Clone(ctx)
+		if breq.GetBody != nil {
+			var err error
+			if breq.Body, err = breq.GetBody(); err != nil {
+				t.metrics.revalidationErrors.Inc()
+				return
+			}
+		}
+		bg := t.newLookup(breq)
+
+		// Re-fetch the cached response, as the one served downstream must not be shared.
+		cached := t.Cache.FetchResponse(ctx, *bg)
+		req := upstreamRequest(bg.Request)
+		if cached.Status != cache.EntryInvalid {
+			req = t.injectValidationHeaders(req, cached.Header())
+		}
+
+		log.Debug().Str("cache-key", key).Msg("Revalidating stale response in background")
+		resp, _, err := t.fetch(ctx, req, bg, cached)
+		if err != nil {
+			t.metrics.revalidationErrors.Inc()
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.Header.Get(cache.HeaderWarning) == cache.WarningRevalidationFailed {
+			t.metrics.revalidationErrors.Inc()
+			return
+		}
+		t.metrics.revalidations.Inc()
+	}()
 }
 
 // handleCacheHit handles a cache hit and sends the cached response downstream.
-func (t *Transport) handleCacheHit(key string, cached *cache.LookupResult) (*http.Response, error) {
+func (t *Transport) handleCacheHit(key string, cached *cache.LookupResult) *http.Response {
 	log.Debug().Str("cache-key", key).Interface("header", cached.Header()).Str("x-cache", "HIT").Send()
 	cached.Header().Set(t.Cache.XCacheHeader(), cache.HIT)
-	return cached.Response(), nil
+	return cached.Response()
+}
+
+// hitStatus returns the status of a request served from the cache.
+func hitStatus(key string, cached *cache.LookupResult) cache.CacheStatus {
+	return cache.CacheStatus{Hit: true, TTL: cached.TTL(), HasTTL: true, Key: key}
+}
+
+// forwardReason returns the reason why a request is forwarded upstream, given the
+// result of the cache lookup. A fresh response, which does not require validation
+// by itself, is only validated because of the request directives.
+func forwardReason(cached *cache.LookupResult) string {
+	if cached.Status != cache.EntryRequiresValidation {
+		return cache.FwdURIMiss
+	}
+	cc := cache.EdgeCacheControl(cached.Header())
+	if cached.TTL() > 0 && !cc.MustValidate {
+		return cache.FwdRequest
+	}
+	return cache.FwdStale
+}
+
+// markMiss marks a response, which bypassed the cache, as cache miss.
+func (t *Transport) markMiss(resp *http.Response) {
+	if t.Cache.MarkCachedResponses() {
+		resp.Header.Set(t.Cache.XCacheHeader(), cache.MISS)
+	}
+}
+
+// ReadBody reads the body of a POST request cached by its body, see cache.HttpCache.ReadBody.
+func (t *Transport) ReadBody(req *http.Request) (*http.Request, error) {
+	return t.Cache.ReadBody(req)
+}
+
+// CacheKey returns the cache key of the request, see cache.HttpCache.NewLookup.
+func (t *Transport) CacheKey(req *http.Request) *cache.Key {
+	return t.Cache.NewLookup(req, t.currentTime()).Key
+}
+
+// MaxBodySize returns the maximum size of a response body stored in the cache.
+func (t *Transport) MaxBodySize() int64 {
+	return t.Cache.MaxBodySize()
+}
+
+// Collapsed marks a response shared with a coalesced request.
+func (t *Transport) Collapsed(resp *http.Response) {
+	t.Cache.MarkCollapsed(resp.Header)
+}
+
+// newLookup creates the lookup request of the request, see cache.HttpCache.NewLookup.
+func (t *Transport) newLookup(req *http.Request) *cache.LookupRequest {
+	return t.Cache.NewLookup(req, t.currentTime())
+}
+
+// isCacheableAuthorized checks if the response can be stored with respect to the
+// authorization of the request. Responses keyed by credential can always be stored.
+func (t *Transport) isCacheableAuthorized(lookup *cache.LookupRequest, resp *http.Response) bool {
+	return lookup.Key.Credential != "" || cache.IsCacheableAuthorized(lookup.Request, resp)
+}
+
+// explain returns a new explanation of the request, if the client requests it and is
+// allowed to. The explain header is never forwarded upstream, hence the request is
+// either the original request, or a fork without the explain header.
+func (t *Transport) explain(ireq *http.Request) (*http.Request, *cache.Explanation) {
+	h := t.Cache.ExplainHeader()
+	if h == "" || ireq.Header.Get(h) == "" {
+		return ireq, nil
+	}
+	req := ireq.Clone(ireq.Context())
+	req.Header.Del(h)
+	if t.AllowExplain == nil || !t.AllowExplain(req) {
+		return req, nil
+	}
+	return req, t.Cache.NewExplanation(req)
+}
+
+// setBrowserCacheControl replaces the Cache-Control header of a response served from, or
+// stored in, the cache by the browser Cache-Control, if any. The Age header is removed, as
+// the time spent in the cache would otherwise count against the browser freshness lifetime.
+func (t *Transport) setBrowserCacheControl(req *http.Request, resp *http.Response) {
+	if cc := t.Cache.BrowserCacheControl(req, resp); cc != "" {
+		resp.Header.Set(cache.HeaderCacheControl, cc)
+		resp.Header.Del(cache.HeaderAge)
+	}
+}
+
+// editResponse applies the response header edits of the rules matching the request and response.
+func (t *Transport) editResponse(req *http.Request, resp *http.Response) {
+	rules := t.Cache.ResponseRules(req, resp)
+	rules.EditResponseHeader(resp.Header)
+}
+
+// editRequest applies the request header edits of the rules matching the request.
+// It either returns the original request or a modified fork.
+func (t *Transport) editRequest(ireq *http.Request) *http.Request {
+	rules := t.Cache.RequestRules(ireq)
+	if len(rules.RequestHeaders) == 0 {
+		return ireq
+	}
+	req := new(http.Request)
+	*req = *ireq // shallow clone
+	req.Header = ireq.Header.Clone()
+	rules.EditRequestHeader(req.Header)
+	return req
+}
+
+// cacheOnly returns true if the request must be answered from the cache only, either
+// because of the request 'only-if-cached' directive, or because the cache is offline.
+func (t *Transport) cacheOnly(req *http.Request) bool {
+	return t.Cache.Offline() ||
+		cache.ParseRequestCacheControl(req.Header.Get(cache.HeaderCacheControl)).OnlyIfCached
+}
+
+// bypass sends a request upstream that bypasses the cache for the given reason. If the
+// request must be answered from the cache only, it is answered with 504 (Gateway Timeout)
+// instead.
+func (t *Transport) bypass(req *http.Request, fwd string) (*http.Response, error) {
+	if t.cacheOnly(req) {
+		log.Debug().Str("x-cache", "PASS").Msg("Request bypasses the cache, not calling upstream")
+		resp := gatewayTimeout(req)
+		t.Cache.AddCacheStatus(resp.Header, t.cacheOnlyStatus(""))
+		return resp, nil
+	}
+	resp, err := t.send(req)
+	if err != nil {
+		return resp, err
+	}
+	t.Cache.AddCacheStatus(resp.Header, cache.CacheStatus{Fwd: fwd, FwdStatus: resp.StatusCode})
+	return resp, nil
+}
+
+// cacheOnlyStatus returns the status of a request that must be answered from the cache
+// only, but cannot be answered with a suitable response.
+func (t *Transport) cacheOnlyStatus(key string) cache.CacheStatus {
+	detail := "only-if-cached"
+	if t.Cache.Offline() {
+		detail = "offline"
+	}
+	return cache.CacheStatus{Key: key, Detail: detail}
+}
+
+// gatewayTimeout returns a 504 (Gateway Timeout) response to a request
+// that cannot be answered without contacting the origin.
+func gatewayTimeout(req *http.Request) *http.Response {
+	body := http.StatusText(http.StatusGatewayTimeout)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", http.StatusGatewayTimeout, body),
+		StatusCode:    http.StatusGatewayTimeout,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		Request:       req,
+	}
 }
 
-// send issues an upstream request.
+// send issues an upstream request, edited by the matching rules.
 func (t *Transport) send(req *http.Request) (*http.Response, error) {
 	transport := t.Transport
 	if transport == nil {
 		transport = http.DefaultTransport
 	}
-	return transport.RoundTrip(req)
+	resp, err := transport.RoundTrip(t.editRequest(req))
+	if err == nil && resp != nil {
+		// Invalidations requested by the origin are applied to any upstream response.
+		t.invalidated(t.Cache.InvalidateByOrigin(req.Context(), req, resp))
+	}
+	return resp, err
+}
+
+// isOriginError returns true if the origin failed to respond,
+// either because of an error or a server error (5xx) response.
+func isOriginError(resp *http.Response, err error) bool {
+	return err != nil || resp == nil ||
+		(resp.StatusCode >= http.StatusInternalServerError && resp.StatusCode <= 599)
+}
+
+// upstreamRequest removes any precondition and range header fields from the request.
+// It either returns the original request or a modified fork.
+func upstreamRequest(ireq *http.Request) *http.Request {
+	if !cache.IsConditionalRequest(ireq) && ireq.Header.Get(cache.HeaderRange) == "" {
+		return ireq
+	}
+	req := new(http.Request)
+	*req = *ireq // shallow clone
+	req.Header = ireq.Header.Clone()
+	cache.RemovePreconditions(req.Header)
+	req.Header.Del(cache.HeaderRange)
+	return req
 }
 
 // injectValidationHeaders injects validation headers.
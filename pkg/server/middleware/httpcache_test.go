@@ -24,9 +24,15 @@ package middleware
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -227,6 +233,8 @@ func TestExpiredFetchedNewResponse(t *testing.T) {
 	{
 		resp, err := s.client.Do(req)
 		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
 		defer resp.Body.Close()
 
 		assert.Equal(t, "", resp.Header.Get("Age"))
@@ -622,3 +630,1390 @@ func TestNoStrictExpiredValidated(t *testing.T) {
 		assert.Equal(t, "12", resp.Header.Get("Age"))
 	}
 }
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var hits atomic.Int32
+	s.mux.HandleFunc("/test_stale_while_revalidate",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := hits.Add(1)
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=20")
+			_, _ = fmt.Fprintf(w, "%d", n)
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_stale_while_revalidate", nil)
+	require.NoError(t, err)
+
+	// Send first request, get response from upstream.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "1", string(body))
+	}
+
+	// Advance time for the cached response to be stale, but within the
+	// stale-while-revalidate window.
+	advanceTime(15 * time.Second)
+
+	// Send second request, get the stale response from cache while
+	// the response is revalidated in the background.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+		assert.Equal(t, "15", resp.Header.Get("Age"))
+		assert.Equal(t, cache.WarningResponseIsStale, resp.Header.Get("Warning"))
+		assert.Equal(t, "1", string(body))
+	}
+
+	// Wait for the background revalidation to update the cached response.
+	assert.Eventually(t, func() bool {
+		_, inflight := s.transport.revalidating.Load(
+			"kache-" + s.server.URL + "/test_stale_while_revalidate")
+		return hits.Load() == 2 && !inflight
+	}, time.Second, 10*time.Millisecond)
+
+	// Send third request, get the revalidated response from cache.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+		assert.Equal(t, "", resp.Header.Get("Warning"))
+		assert.Equal(t, "2", string(body))
+	}
+	assert.Equal(t, int32(2), hits.Load())
+}
+
+func TestStaleIfError(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var failing atomic.Bool
+	s.mux.HandleFunc("/test_stale_if_error",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+			_, _ = w.Write([]byte("42"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_stale_if_error", nil)
+	require.NoError(t, err)
+
+	// Send first request, get response from upstream.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "42", string(body))
+	}
+
+	// Let the origin fail and the cached response become stale.
+	failing.Store(true)
+	advanceTime(30 * time.Second)
+
+	// Send second request, get the stale response from cache.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+		assert.Equal(t, cache.WarningRevalidationFailed, resp.Header.Get("Warning"))
+		assert.Equal(t, "42", string(body))
+	}
+
+	// Advance time beyond the stale-if-error window.
+	advanceTime(60 * time.Second)
+
+	// Send third request, the origin error is passed through.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestStaleIfErrorGracePeriod(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.StaleIfError = "60s"
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	var failing atomic.Bool
+	s.mux.HandleFunc("/test_stale_if_error_grace_period",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failing.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=10")
+			_, _ = w.Write([]byte("42"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_stale_if_error_grace_period", nil)
+	require.NoError(t, err)
+
+	resp, err := s.client.Do(req)
+	require.NoError(t, err)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	failing.Store(true)
+	advanceTime(30 * time.Second)
+
+	resp, err = s.client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, cache.WarningRevalidationFailed, resp.Header.Get("Warning"))
+	assert.Equal(t, "42", string(body))
+}
+
+func TestConditionalRequestFromCache(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var hits atomic.Int32
+	s.mux.HandleFunc("/test_conditional_request",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			// Client preconditions must not be forwarded upstream.
+			if r.Header.Get("If-None-Match") == `"a1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=10")
+			w.Header().Set("Etag", `"a1"`)
+			_, _ = w.Write([]byte("42"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_conditional_request", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", `"a1"`)
+
+	// Send first request, the response is fetched from upstream, stored,
+	// and the client precondition is evaluated against it.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, int32(1), hits.Load())
+	}
+
+	// Send second request, answered from the fresh cached response.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, `"a1"`, resp.Header.Get("Etag"))
+		assert.Equal(t, int32(1), hits.Load())
+	}
+
+	// Send third request with a non-matching etag, get the full cached response.
+	{
+		req.Header.Set("If-None-Match", `"a0"`)
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+		assert.Equal(t, "42", string(body))
+		assert.Equal(t, int32(1), hits.Load())
+	}
+
+	// Send fourth request with a failing If-Match precondition.
+	{
+		req.Header.Del("If-None-Match")
+		req.Header.Set("If-Match", `"a0"`)
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+		assert.Equal(t, int32(1), hits.Load())
+	}
+
+	// Let the cached response become stale, the origin is asked to validate it.
+	advanceTime(11 * time.Second)
+	{
+		req.Header.Del("If-Match")
+		req.Header.Set("If-None-Match", `"a1"`)
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, int32(2), hits.Load())
+	}
+}
+
+func TestRangeRequestFromCache(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var hits atomic.Int32
+	s.mux.HandleFunc("/test_range_request",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			// The complete response is requested from upstream to be stored.
+			assert.Equal(t, "", r.Header.Get("Range"))
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Header().Set("Etag", `"a1"`)
+			_, _ = w.Write([]byte("0123456789"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_range_request", nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=0-3")
+
+	// Send first request, the complete response is fetched from upstream.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 0-3/10", resp.Header.Get("Content-Range"))
+		assert.Equal(t, "0123", string(body))
+	}
+
+	// Send second request, the range is served from the cached response.
+	{
+		req.Header.Set("Range", "bytes=-2")
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+		assert.Equal(t, "89", string(body))
+	}
+
+	// Send third request without range, get the complete cached response.
+	{
+		req.Header.Del("Range")
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "0123456789", string(body))
+	}
+	assert.Equal(t, int32(1), hits.Load())
+}
+
+func TestPartialResponseNotStored(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	s.mux.HandleFunc("/test_partial_response",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Header().Set("Content-Range", "bytes 0-1/10")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("01"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_partial_response", nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "", resp.Header.Get(XCache))
+	}
+}
+
+func TestSlicedResponse(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	s.transport.Cache.UpdateConfig(&cache.HttpCacheConfig{
+		Strict:     strict,
+		XCache:     true,
+		XCacheName: XCache,
+		Slices:     []cache.Slice{{Path: "^/test_sliced", Size: 4}},
+	})
+
+	var ranges []string
+	s.mux.HandleFunc("/test_sliced_response",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ranges = append(ranges, r.Header.Get("Range"))
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Header().Set("Etag", `"a1"`)
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_sliced_response", nil)
+	require.NoError(t, err)
+
+	// Send first request, the response is fetched from upstream in slices.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "0123456789", string(body))
+		assert.Equal(t, "", resp.Header.Get(XCache))
+		assert.Equal(t, []string{"bytes=0-3", "bytes=4-7", "bytes=8-11"}, ranges)
+	}
+
+	// Send second request, the response is assembled from the cached slices.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "0123456789", string(body))
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+		assert.Equal(t, "10", resp.Header.Get("Content-Length"))
+	}
+
+	// Send range request spanning multiple slices, served from the cached slices.
+	{
+		req.Header.Set("Range", "bytes=3-8")
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 3-8/10", resp.Header.Get("Content-Range"))
+		assert.Equal(t, "345678", string(body))
+	}
+
+	// Send unsatisfiable range request.
+	{
+		req.Header.Set("Range", "bytes=20-")
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+		assert.Equal(t, "bytes */10", resp.Header.Get("Content-Range"))
+	}
+
+	// Send conditional request, answered from the first slice.
+	{
+		req.Header.Del("Range")
+		req.Header.Set("If-None-Match", `"a1"`)
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, `"a1"`, resp.Header.Get("Etag"))
+	}
+	assert.Len(t, ranges, 3)
+}
+
+func TestSlicedResponseChanged(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	s.transport.Cache.UpdateConfig(&cache.HttpCacheConfig{
+		Strict:     strict,
+		XCache:     true,
+		XCacheName: XCache,
+		Slices:     []cache.Slice{{Path: "^/test_sliced", Size: 4}},
+	})
+
+	etag, content := `"a1"`, "0123456789"
+	var ranges []string
+	s.mux.HandleFunc("/test_sliced_changed",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ranges = append(ranges, r.Header.Get("Range"))
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Header().Set("Etag", etag)
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_sliced_changed", nil)
+	require.NoError(t, err)
+
+	// Only the first slice is fetched and stored.
+	{
+		req.Header.Set("Range", "bytes=0-3")
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "0123", string(body))
+		assert.Equal(t, []string{"bytes=0-3"}, ranges)
+	}
+
+	// The response changes at the origin, the second slice does not match the first one.
+	etag, content = `"a2"`, "abcdefghij"
+	{
+		req.Header.Del("Range")
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		assert.Error(t, err)
+		defer resp.Body.Close()
+	}
+
+	// The slices are deleted, the current response is fetched.
+	ranges = nil
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "abcdefghij", string(body))
+		assert.Equal(t, `"a2"`, resp.Header.Get("Etag"))
+		assert.Equal(t, []string{"bytes=0-3", "bytes=4-7", "bytes=8-11"}, ranges)
+	}
+}
+
+func TestSlicedResponseEmpty(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	s.transport.Cache.UpdateConfig(&cache.HttpCacheConfig{
+		Strict:     strict,
+		XCache:     true,
+		XCacheName: XCache,
+		Slices:     []cache.Slice{{Path: "^/test_sliced", Size: 4}},
+	})
+
+	s.mux.HandleFunc("/test_sliced_empty",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=3600")
+			if r.Header.Get("Range") != "" {
+				// No range of an empty content is satisfiable.
+				w.Header().Set("Content-Range", "bytes */0")
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			}
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_sliced_empty", nil)
+	require.NoError(t, err)
+
+	// The origin answers the first slice with 416, the plain request gets the empty content.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "", resp.Header.Get("Content-Range"))
+		assert.Equal(t, "0", resp.Header.Get("Content-Length"))
+		assert.Empty(t, body)
+	}
+
+	// A range request is not satisfiable.
+	{
+		req.Header.Set("Range", "bytes=0-")
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+		assert.Equal(t, "bytes */0", resp.Header.Get("Content-Range"))
+	}
+}
+
+func TestSlicedResponseRangeNotSupported(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	s.transport.Cache.UpdateConfig(&cache.HttpCacheConfig{
+		Strict:     strict,
+		XCache:     true,
+		XCacheName: XCache,
+		Slices:     []cache.Slice{{Path: "^/test_sliced", Size: 4}},
+	})
+
+	var hits atomic.Int32
+	s.mux.HandleFunc("/test_sliced_no_range",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=3600")
+			_, _ = w.Write([]byte("0123456789"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_sliced_no_range", nil)
+	require.NoError(t, err)
+
+	// The origin ignores the range, the complete response is passed through.
+	for i := 0; i < 2; i++ {
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "0123456789", string(body))
+	}
+	assert.Equal(t, int32(2), hits.Load())
+}
+
+func TestStreamResponseWhileStoring(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	resume := make(chan struct{})
+	s.mux.HandleFunc("/test_stream_response",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=3600")
+			_, _ = w.Write([]byte("01234"))
+			w.(http.Flusher).Flush()
+			<-resume
+			_, _ = w.Write([]byte("56789"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_stream_response", nil)
+	require.NoError(t, err)
+
+	// Send first request, the first bytes are received before the origin completes.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(resp.Body, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "01234", string(buf))
+
+		close(resume)
+		rest, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "56789", string(rest))
+	}
+
+	// Send second request, the response has been stored once streamed completely.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+		assert.Equal(t, "0123456789", string(body))
+	}
+}
+
+func TestStreamResponseAborted(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.MaxBodySize = 4
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	var hits atomic.Int32
+	s.mux.HandleFunc("/test_stream_aborted",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", "max-age=3600")
+			_, _ = w.Write([]byte("01"))
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte("23456789"))
+		}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_stream_aborted", nil)
+	require.NoError(t, err)
+
+	// The body exceeding the max body size is served completely, but not stored.
+	for i := 0; i < 2; i++ {
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "0123456789", string(body))
+		assert.Equal(t, "", resp.Header.Get(XCache))
+	}
+	assert.Equal(t, int32(2), hits.Load())
+}
+
+func TestSoftPurgeValidated(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var validations atomic.Int32
+	var failing atomic.Bool
+	s.mux.HandleFunc("/test_soft_purge", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		if r.Header.Get("if-none-match") == "abc123" {
+			validations.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Etag", "abc123")
+		_, _ = w.Write([]byte("42"))
+	}))
+
+	req, err := http.NewRequest("GET", s.server.URL+"/test_soft_purge", nil)
+	require.NoError(t, err)
+
+	softPurge := func() {
+		m, err := provider.NewGlobMatcher("*/test_soft_purge")
+		require.NoError(t, err)
+		assert.Equal(t, 1, s.transport.Cache.SoftPurge(context.Background(), m, currentTime()))
+	}
+
+	// Send first request, get response from upstream.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "42", string(body))
+	}
+
+	softPurge()
+	advanceTime(10 * time.Second)
+
+	// Send second request, the fresh but invalidated response is validated, then served.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, int32(1), validations.Load())
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+		assert.Equal(t, "42", string(body))
+	}
+
+	// Send third request, the validated response is served from cache.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, int32(1), validations.Load())
+		assert.Equal(t, "HIT", resp.Header.Get(XCache))
+	}
+
+	softPurge()
+	failing.Store(true)
+
+	// Send fourth request, the invalidated response is served if the origin fails.
+	{
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, cache.WarningRevalidationFailed, resp.Header.Get("Warning"))
+		assert.Equal(t, "42", string(body))
+	}
+}
+
+func TestUnsafeMethodInvalidates(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var fetches atomic.Int32
+	s.mux.HandleFunc("/test_unsafe", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fetches.Add(1)
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write([]byte("42"))
+	}))
+
+	var invalidated []string
+	s.transport.OnInvalidate = func(keys []string) {
+		invalidated = keys
+	}
+
+	get := func() {
+		resp, err := s.client.Get(s.server.URL + "/test_unsafe")
+		require.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	// Send first and second request, the second is served from cache.
+	get()
+	get()
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Send unsafe request, the cached response is invalidated.
+	resp, err := s.client.Post(s.server.URL+"/test_unsafe", "text/plain", strings.NewReader("43"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Len(t, invalidated, 1)
+	assert.True(t, strings.HasSuffix(invalidated[0], "/test_unsafe"))
+
+	// Send third request, get response from upstream.
+	get()
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestOriginInvalidates(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.InvalidateHeader = "X-Kache-Invalidate"
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	var fetches atomic.Int32
+	s.mux.HandleFunc("/test_origin_article", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write([]byte("42"))
+	}))
+	s.mux.HandleFunc("/test_origin_write", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Kache-Invalidate", "/test_origin_article")
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	var invalidated []string
+	s.transport.OnInvalidate = func(keys []string) {
+		invalidated = append(invalidated, keys...)
+	}
+
+	get := func(path string) *http.Response {
+		resp, err := s.client.Get(s.server.URL + path)
+		require.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// Send first and second request, the second is served from cache.
+	get("/test_origin_article")
+	get("/test_origin_article")
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// The origin lists the cached response in the invalidation header, which is
+	// removed from the response.
+	resp := get("/test_origin_write")
+	assert.Empty(t, resp.Header.Get("X-Kache-Invalidate"))
+	require.Len(t, invalidated, 1)
+	assert.True(t, strings.HasSuffix(invalidated[0], "/test_origin_article"))
+
+	// Send third request, get response from upstream.
+	get("/test_origin_article")
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestNegativeCaching(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.NegativeTTLs = []cache.NegativeTTL{{Status: "404", TTL: 30 * time.Second}}
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	var fetches atomic.Int32
+	s.mux.HandleFunc("/test_negative", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		http.NotFound(w, r)
+	}))
+
+	get := func() {
+		resp, err := s.client.Get(s.server.URL + "/test_negative")
+		require.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	// Send first and second request, the second is served from cache,
+	// although the response has no Cache-Control header.
+	get()
+	get()
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// The negatively cached response expires after its TTL.
+	advanceTime(31 * time.Second)
+	get()
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestOnlyIfCached(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var fetches atomic.Int32
+	s.mux.HandleFunc("/test_only_if_cached", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("42"))
+	}))
+
+	get := func(cacheControl string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, s.server.URL+"/test_only_if_cached", nil)
+		require.NoError(t, err)
+		req.Header.Set("Cache-Control", cacheControl)
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// Nothing stored yet, the origin is not contacted.
+	resp := get("only-if-cached")
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, int32(0), fetches.Load())
+
+	get("")
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Fresh response is served from cache.
+	resp = get("only-if-cached")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Stale response is not served, unless permitted by max-stale.
+	advanceTime(120 * time.Second)
+	resp = get("only-if-cached")
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	resp = get("only-if-cached, max-stale=300")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestOfflineMode(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var fetches atomic.Int32
+	s.mux.HandleFunc("/test_offline", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("42"))
+	}))
+
+	get := func(path string) *http.Response {
+		resp, err := s.client.Get(s.server.URL + path)
+		require.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	get("/test_offline")
+	assert.Equal(t, int32(1), fetches.Load())
+
+	s.transport.Cache.SetOffline(true)
+
+	// Stale responses are served while offline.
+	advanceTime(120 * time.Second)
+	resp := get("/test_offline")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{cache.WarningResponseIsStale, cache.WarningDisconnectedOperation},
+		resp.Header.Values(cache.HeaderWarning))
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Uncached and unsafe requests are not forwarded upstream.
+	resp = get("/test_offline_missing")
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	resp, err := s.client.Post(s.server.URL+"/test_offline", "text/plain", strings.NewReader("43"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Back online, the stale response is validated.
+	s.transport.Cache.SetOffline(false)
+	get("/test_offline")
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestAuthorizedRequests(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.Private = []cache.Private{{Path: "^/test_auth_private"}}
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	var fetches atomic.Int32
+	handler := func(cacheControl string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fetches.Add(1)
+			w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+			w.Header().Set("Cache-Control", cacheControl)
+			_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+		}
+	}
+	s.mux.HandleFunc("/test_auth_public", handler("public, max-age=3600"))
+	s.mux.HandleFunc("/test_auth_user", handler("max-age=3600"))
+	s.mux.HandleFunc("/test_auth_private", handler("max-age=3600"))
+
+	get := func(path, credential string) string {
+		req, err := http.NewRequest(http.MethodGet, s.server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", credential)
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return string(body)
+	}
+
+	// Explicitly public responses are shared among credentials.
+	assert.Equal(t, "alice", get("/test_auth_public", "alice"))
+	assert.Equal(t, "alice", get("/test_auth_public", "bob"))
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Other responses to authorized requests are not stored.
+	fetches.Store(0)
+	assert.Equal(t, "alice", get("/test_auth_user", "alice"))
+	assert.Equal(t, "bob", get("/test_auth_user", "bob"))
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// Responses of private paths are stored per credential.
+	fetches.Store(0)
+	assert.Equal(t, "alice", get("/test_auth_private", "alice"))
+	assert.Equal(t, "bob", get("/test_auth_private", "bob"))
+	assert.Equal(t, "alice", get("/test_auth_private", "alice"))
+	assert.Equal(t, "bob", get("/test_auth_private", "bob"))
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestCacheStatus(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.CacheStatus = true
+	cfg.Exclude = &cache.Exclude{Path: []string{"^/test_status_excluded"}}
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("42"))
+	})
+	s.mux.HandleFunc("/test_status", handler)
+	s.mux.HandleFunc("/test_status_excluded", handler)
+
+	do := func(method, path, cacheControl string) *http.Response {
+		req, err := http.NewRequest(method, s.server.URL+path, nil)
+		require.NoError(t, err)
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		return resp
+	}
+	key := `key="kache-` + s.server.URL + `/test_status"`
+
+	resp := do(http.MethodGet, "/test_status", "")
+	assert.Equal(t, "kache; fwd=uri-miss; fwd-status=200; stored; "+key, resp.Header.Get("Cache-Status"))
+	assert.Equal(t, "", resp.Header.Get(XCache))
+
+	resp = do(http.MethodGet, "/test_status", "")
+	assert.Equal(t, "kache; hit; ttl=60; "+key, resp.Header.Get("Cache-Status"))
+	assert.Equal(t, "HIT", resp.Header.Get(XCache))
+
+	// The fresh response is validated because of the request directive.
+	resp = do(http.MethodGet, "/test_status", "no-cache")
+	assert.Equal(t, "kache; fwd=request; fwd-status=304; stored; "+key, resp.Header.Get("Cache-Status"))
+
+	// The stale response is validated.
+	advanceTime(120 * time.Second)
+	resp = do(http.MethodGet, "/test_status", "")
+	assert.Equal(t, "kache; fwd=stale; fwd-status=304; stored; "+key, resp.Header.Get("Cache-Status"))
+
+	resp = do(http.MethodGet, "/test_status_excluded", "")
+	assert.Equal(t, "kache; fwd=bypass; fwd-status=200", resp.Header.Get("Cache-Status"))
+
+	// Requests bypassing the cache are marked as miss on the response.
+	resp = do(http.MethodPost, "/test_status", "")
+	assert.Equal(t, "kache; fwd=method; fwd-status=200", resp.Header.Get("Cache-Status"))
+	assert.Equal(t, "MISS", resp.Header.Get(XCache))
+
+	// The response has been invalidated by the unsafe request.
+	resp = do(http.MethodGet, "/test_status", "only-if-cached")
+	assert.Equal(t, "kache; "+key+"; detail=only-if-cached", resp.Header.Get("Cache-Status"))
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+}
+
+func TestKeyPolicies(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.KeyPolicies = []cache.KeyPolicy{{Path: "^/test_key_policy", IgnoreQuery: []string{"utm_*"}}}
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	var fetches atomic.Int32
+	s.mux.HandleFunc("/test_key_policy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write([]byte(r.URL.Query().Get("id")))
+	}))
+
+	get := func(query string) string {
+		resp, err := s.client.Get(s.server.URL + "/test_key_policy?" + query)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return string(body)
+	}
+
+	// Marketing query parameters do not fragment the cache.
+	assert.Equal(t, "1", get("id=1&utm_source=newsletter"))
+	assert.Equal(t, "1", get("utm_campaign=spring&id=1"))
+	assert.Equal(t, "1", get("id=1"))
+	assert.Equal(t, int32(1), fetches.Load())
+
+	assert.Equal(t, "2", get("id=2&utm_source=newsletter"))
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestRules(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.Rules = []cache.Rule{
+		{Name: "force", Match: cache.RuleMatch{Path: "^/test_rules/force"}, ForceCache: true, TTL: time.Minute,
+			RequestHeaders:  &cache.HeaderEdits{Set: map[string]string{"X-Upstream": "kache"}},
+			ResponseHeaders: &cache.HeaderEdits{Set: map[string]string{"X-Rule": "force"}, Remove: []string{"X-Origin"}}},
+		{Name: "bypass", Match: cache.RuleMatch{Path: "^/test_rules/bypass"}, Bypass: true},
+		{Name: "static", Match: cache.RuleMatch{Path: "^/test_rules/static", ContentType: "^text/css"},
+			CacheControl: "max-age=3600"},
+	}
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	fetches := map[string]int{}
+	var mu sync.Mutex
+	s.mux.HandleFunc("/test_rules/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "text/css")
+		w.Header().Set("X-Origin", "1")
+		switch r.URL.Path {
+		case "/test_rules/force":
+			w.Header().Set("Cache-Control", "private, no-store")
+		case "/test_rules/static":
+			w.Header().Set("Cache-Control", "no-cache")
+		default:
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		_, _ = w.Write([]byte(r.Header.Get("X-Upstream")))
+	}))
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := s.client.Get(s.server.URL + path)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp, string(body)
+	}
+
+	// A forced response is stored despite its directives. Request header edits
+	// apply upstream, response header edits to cached responses as well.
+	for i := 0; i < 2; i++ {
+		resp, body := get("/test_rules/force")
+		assert.Equal(t, "kache", body)
+		assert.Equal(t, "force", resp.Header.Get("X-Rule"))
+		assert.Empty(t, resp.Header.Get("X-Origin"))
+	}
+	resp, _ := get("/test_rules/force")
+	assert.Equal(t, cache.HIT, resp.Header.Get(XCache))
+
+	get("/test_rules/bypass")
+	resp, _ = get("/test_rules/bypass")
+	assert.Empty(t, resp.Header.Get(XCache))
+
+	get("/test_rules/static")
+	resp, _ = get("/test_rules/static")
+	assert.Equal(t, cache.HIT, resp.Header.Get(XCache))
+	assert.Equal(t, "max-age=3600", resp.Header.Get("Cache-Control"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"/test_rules/force": 1, "/test_rules/bypass": 2, "/test_rules/static": 1}, fetches)
+}
+
+func TestSurrogateControl(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	var fetches atomic.Int32
+	s.mux.HandleFunc("/test_surrogate", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Surrogate-Control", "max-age=86400")
+		_, _ = w.Write([]byte("surrogate"))
+	}))
+
+	get := func() *http.Response {
+		resp, err := s.client.Get(s.server.URL + "/test_surrogate")
+		require.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// Stored for a day at the edge, while browsers revalidate every time.
+	resp := get()
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("Surrogate-Control"))
+
+	advanceTime(time.Hour)
+	resp = get()
+	assert.Equal(t, cache.HIT, resp.Header.Get(XCache))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, "3600", resp.Header.Get("Age"))
+	assert.Empty(t, resp.Header.Get("Surrogate-Control"))
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// The browser Cache-Control replaces the one of the origin downstream.
+	cfg := *s.transport.Cache.Config()
+	cfg.BrowserCacheControl = "max-age=60"
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	resp = get()
+	assert.Equal(t, cache.HIT, resp.Header.Get(XCache))
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("Age"))
+
+	advanceTime(24 * time.Hour)
+	resp = get()
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestPostCache(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.Post = []cache.Post{{Path: "^/test_graphql", GraphQL: true}}
+	s.transport.Cache.UpdateConfig(&cfg)
+
+	var fetches atomic.Int32
+	s.mux.HandleFunc("/test_graphql", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write(body)
+	}))
+
+	post := func(body string) *http.Response {
+		resp, err := s.client.Post(s.server.URL+"/test_graphql", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		echoed, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		// The origin receives the complete body.
+		assert.Equal(t, body, string(echoed))
+		return resp
+	}
+
+	// Identical queries are served from the cache.
+	query := `{"query": "query Q { user { name } }", "operationName": "Q"}`
+	post(query)
+	resp := post(query)
+	assert.Equal(t, cache.HIT, resp.Header.Get(XCache))
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Other queries are fetched from the origin.
+	post(`{"query": "query Q { user { email } }"}`)
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// Mutations are never served from the cache.
+	mutation := `{"query": "mutation { like(id: 1) { count } }"}`
+	post(mutation)
+	resp = post(mutation)
+	assert.NotEqual(t, cache.HIT, resp.Header.Get(XCache))
+	assert.Equal(t, int32(4), fetches.Load())
+}
+
+func TestExplain(t *testing.T) {
+	strict = true
+	setup(t)
+	t.Cleanup(func() { teardown(t) })
+
+	cfg := *s.transport.Cache.Config()
+	cfg.ExplainHeader = "X-Kache-Explain"
+	s.transport.Cache.UpdateConfig(&cfg)
+	s.transport.AllowExplain = func(req *http.Request) bool {
+		return req.Header.Get("X-Client") == "admin"
+	}
+
+	s.mux.HandleFunc("/test_explain", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", currentTime().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		// The explain header is never forwarded upstream.
+		w.Header().Set("X-Forwarded-Explain", r.Header.Get("X-Kache-Explain"))
+		_, _ = w.Write([]byte("42"))
+	}))
+
+	explain := func(query, client string) (*http.Response, *cache.Explanation) {
+		req, err := http.NewRequest(http.MethodGet, s.server.URL+"/test_explain?"+query, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Kache-Explain", "1")
+		req.Header.Set("X-Client", client)
+		resp, err := s.client.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		assert.Empty(t, resp.Header.Get("X-Forwarded-Explain"))
+
+		h := resp.Header.Get("X-Kache-Explain")
+		if h == "" {
+			return resp, nil
+		}
+		var e cache.Explanation
+		require.NoError(t, json.Unmarshal([]byte(h), &e))
+		return resp, &e
+	}
+
+	// Clients not allowed by the ACL get no explanation.
+	_, e := explain("cc=no-store", "guest")
+	assert.Nil(t, e)
+
+	_, e = explain("cc=no-store", "admin")
+	require.NotNil(t, e)
+	assert.Equal(t, "kache-"+s.server.URL+"/test_explain?cc=no-store", e.Key)
+	assert.Equal(t, "EntryInvalid", e.Lookup)
+	assert.Equal(t, int64(120), e.PathTTL)
+	assert.False(t, e.Stored)
+	assert.Equal(t, cache.ReasonNoStore, e.Reason)
+
+	_, e = explain("cc=max-age%3D60", "admin")
+	require.NotNil(t, e)
+	assert.True(t, e.Stored)
+	assert.Empty(t, e.Reason)
+
+	advanceTime(10 * time.Second)
+	_, e = explain("cc=max-age%3D60", "admin")
+	require.NotNil(t, e)
+	assert.Equal(t, "EntryOk", e.Lookup)
+	assert.Equal(t, &cache.Freshness{Age: 10, Lifetime: 60, TTL: 50}, e.Freshness)
+}
@@ -0,0 +1,265 @@
+// MIT License
+//
+// Copyright (c) 2023 kache.io
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/kacheio/kache/pkg/cache"
+	"github.com/rs/zerolog/log"
+)
+
+// errSliceMismatch indicates that a slice does not belong to the same response as the
+// first slice, i.e. the response has been modified on the origin in the meantime.
+var errSliceMismatch = errors.New("slice does not match the sliced response")
+
+// serveSliced serves the request from a response that is fetched from upstream
+// and cached in slices of the given size. The first slice determines the complete
+// size of the response, the remaining slices are fetched lazily, while the body
+// is read. Only single ranges are served partially, any other range request is
+// answered with the complete response. Preconditions are evaluated against the
+// first slice.
+func (t *Transport) serveSliced(ctx context.Context, lookup *cache.LookupRequest,
+	size int64) (*http.Response, error) {
+	first, hit, err := t.fetchSlice(ctx, lookup, 0, size)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheStatus := cache.CacheStatus{Hit: hit, Key: lookup.Key.String()}
+	switch {
+	case hit:
+	case t.cacheOnly(lookup.Request):
+		cacheStatus = t.cacheOnlyStatus(cacheStatus.Key)
+	default:
+		cacheStatus.Fwd, cacheStatus.FwdStatus = cache.FwdURIMiss, first.StatusCode
+	}
+
+	if first.StatusCode == http.StatusRequestedRangeNotSatisfiable && !cache.IsRangeRequest(lookup.Request) &&
+		cache.ParseContentRangeSize(first.Header.Get("Content-Range")) == 0 {
+		// The response is empty, answer the plain request with the empty content.
+		_ = first.Body.Close()
+		first.StatusCode = http.StatusOK
+		first.Status = fmt.Sprintf("%d %s", first.StatusCode, http.StatusText(first.StatusCode))
+		first.Header.Del("Content-Range")
+		first.Header.Set("Content-Length", "0")
+		first.Body, first.ContentLength = http.NoBody, 0
+	}
+
+	if first.StatusCode != http.StatusPartialContent {
+		// The origin does not support ranges, serve the response as is.
+		t.Cache.AddCacheStatus(first.Header, cacheStatus)
+		if cache.IsConditionalRequest(lookup.Request) {
+			return cache.ConditionalResponse(lookup.Request, first), nil
+		}
+		return first, nil
+	}
+	_ = first.Body.Close()
+
+	total := cache.ParseContentRangeSize(first.Header.Get("Content-Range"))
+	if total < 0 {
+		return nil, fmt.Errorf("invalid content range of sliced response: %q",
+			first.Header.Get("Content-Range"))
+	}
+
+	header := first.Header.Clone()
+	header.Del("Content-Range")
+	header.Del(cache.HeaderWarning)
+	header.Set("Accept-Ranges", "bytes")
+	if hit && t.Cache.MarkCachedResponses() {
+		header.Set(t.Cache.XCacheHeader(), cache.HIT)
+	}
+	t.Cache.AddCacheStatus(header, cacheStatus)
+
+	// Preconditions are evaluated before ranges, without fetching the remaining slices.
+	if cache.IsConditionalRequest(lookup.Request) && cache.EvaluatePreconditions(lookup.Request, header) != 0 {
+		return cache.ConditionalResponse(lookup.Request, &http.Response{
+			StatusCode: http.StatusOK,
+			Proto:      first.Proto,
+			ProtoMajor: first.ProtoMajor,
+			ProtoMinor: first.ProtoMinor,
+			Header:     header,
+			Body:       http.NoBody,
+			Request:    lookup.Request,
+		}), nil
+	}
+
+	ra, status := cache.SelectRange(lookup.Request, first.Header, total)
+	switch status {
+	case http.StatusRequestedRangeNotSatisfiable:
+		header = make(http.Header)
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+		t.Cache.AddCacheStatus(header, cacheStatus)
+	case http.StatusPartialContent:
+		header.Set("Content-Range", ra.ContentRange(total))
+	}
+	header.Set("Content-Length", strconv.FormatInt(ra.Length, 10))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         first.Proto,
+		ProtoMajor:    first.ProtoMajor,
+		ProtoMinor:    first.ProtoMinor,
+		Header:        header,
+		ContentLength: ra.Length,
+		Request:       lookup.Request,
+		Body: &sliceReader{
+			ctx:       ctx,
+			transport: t,
+			lookup:    lookup,
+			size:      size,
+			total:     total,
+			etag:      first.Header.Get(cache.HeaderEtag),
+			pos:       ra.Start,
+			end:       ra.Start + ra.Length,
+		},
+	}, nil
+}
+
+// fetchSlice fetches the slice with the given index from the cache, or from upstream if
+// the slice is missing or not fresh. Slices fetched from upstream are stored in the cache.
+// It returns true, if the slice is served from the cache.
+func (t *Transport) fetchSlice(ctx context.Context, lookup *cache.LookupRequest,
+	index, size int64) (*http.Response, bool, error) {
+	cached := t.Cache.FetchSlice(ctx, *lookup, index)
+	if index == 0 {
+		t.Cache.Explain(lookup, cached)
+	}
+	// While the cache is offline, stale slices are served as well.
+	if cached.Status == cache.EntryOk || (t.Cache.Offline() && cached.Response() != nil) {
+		if index == 0 {
+			t.metrics.hits.Inc()
+		}
+		return cached.Response(), true, nil
+	}
+	if index == 0 {
+		t.metrics.misses.Inc()
+	}
+	if t.cacheOnly(lookup.Request) {
+		return gatewayTimeout(lookup.Request), false, nil
+	}
+
+	start := index * size
+	req := lookup.Request.Clone(ctx)
+	cache.RemovePreconditions(req.Header)
+	req.Header.Set(cache.HeaderRange, fmt.Sprintf("bytes=%d-%d", start, start+size-1))
+
+	log.Debug().Str("cache-key", lookup.Key.SliceKey(index)).Msg("Fetching slice from upstream")
+	resp, err := t.send(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Only partial responses are stored as slices, unless a rule prevents it.
+	t.Cache.RewriteCacheControl(lookup.Request, resp)
+	rules := t.Cache.ResponseRules(lookup.Request, resp)
+	cacheable := resp.StatusCode == http.StatusPartialContent && t.isCacheableAuthorized(lookup, resp) &&
+		!rules.Bypass
+	if cacheable && t.Cache.Strict() && !rules.ForceCache {
+		cacheable = t.Cache.IsCacheableResponse(resp) && !lookup.ReqCacheControl.NoStore
+	}
+	if cacheable {
+		t.Cache.StoreSlice(ctx, lookup, index, resp, t.currentTime())
+	}
+	if index == 0 {
+		lookup.Explanation.SetStored(cacheable, "")
+	}
+	return resp, false, nil
+}
+
+// sliceReader reads the byte range [pos, end) of a sliced response,
+// fetching one slice at a time.
+type sliceReader struct {
+	ctx       context.Context
+	transport *Transport
+	lookup    *cache.LookupRequest
+
+	// size is the size of a single slice, total the complete size of the response.
+	size, total int64
+
+	// etag is the entity-tag of the first slice. All slices must match it.
+	etag string
+
+	// pos is the current read position, end the end of the range (exclusive).
+	pos, end int64
+
+	// buf holds the remaining bytes of the current slice.
+	buf []byte
+}
+
+// Read reads the next bytes of the sliced response.
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if r.pos >= r.end {
+		return 0, io.EOF
+	}
+	if len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.pos += int64(n)
+	return n, nil
+}
+
+// next fetches the slice containing the current read position.
+func (r *sliceReader) next() error {
+	index := r.pos / r.size
+	resp, _, err := r.transport.fetchSlice(r.ctx, r.lookup, index, r.size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get(cache.HeaderEtag) != r.etag {
+		// The response changed at the origin. Delete all slices, so that following
+		// requests fetch the current response instead of failing until they expire.
+		r.transport.invalidated(r.transport.Cache.DeleteSlices(r.ctx, r.lookup, (r.total+r.size-1)/r.size))
+		return errSliceMismatch
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	offset := r.pos - index*r.size
+	if offset >= int64(len(body)) {
+		return io.ErrUnexpectedEOF
+	}
+	r.buf = body[offset:min(int64(len(body)), r.end-index*r.size)]
+	return nil
+}
+
+// Close closes the reader.
+func (r *sliceReader) Close() error {
+	r.buf = nil
+	r.pos = r.end
+	return nil
+}
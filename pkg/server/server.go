@@ -68,6 +68,9 @@ type Server struct {
 	// httpcache holds the Http cache.
 	httpcache *cache.HttpCache
 
+	// transport holds the caching transport.
+	transport *middleware.Transport
+
 	// cluster holds a custer connection.
 	cluster cluster.Connection
 
@@ -110,9 +113,10 @@ func NewServer(
 		srv.cluster = cc
 	}
 
-	transport := middleware.NewCoalesced(
-		middleware.NewCachedTransport(srv.httpcache, reg),
-	)
+	cached := middleware.NewCachedTransport(srv.httpcache, reg)
+	cached.OnInvalidate = srv.broadcastInvalidation
+	srv.transport = cached
+	transport := middleware.NewCoalesced(cached)
 
 	// Create the reverse proxy.
 	proxy := &httputil.ReverseProxy{
@@ -204,6 +208,12 @@ func (s *Server) Director() func(req *http.Request) {
 	}
 }
 
+// AllowExplain sets the filter of clients allowed to request an explanation
+// of how the cache handles their requests, see HttpCacheConfig.ExplainHeader.
+func (s *Server) AllowExplain(allow func(req *http.Request) bool) {
+	s.transport.AllowExplain = allow
+}
+
 // Start starts the server.
 func (s *Server) Start(ctx context.Context) {
 	go func() {